@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"dockerhub-pull-watcher/internal/app"
+	"dockerhub-pull-watcher/internal/backup"
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// runBackup implements "backup": take a backup now, into -o or BACKUP_DIR.
+func runBackup(args []string) int {
+	cfg := app.LoadConfig()
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dbPath := fs.String("db", cfg.DBPath, "SQLite database file")
+	out := fs.String("o", "", "backup file (default: a new file in BACKUP_DIR, rotated)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher backup [-db path] [-o file]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if *out == "" && cfg.BackupDir == "" {
+		fmt.Fprintln(os.Stderr, "set -o or BACKUP_DIR")
+		return 2
+	}
+	if _, err := os.Stat(*dbPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	d, ok := openDB(*dbPath)
+	if !ok {
+		return 1
+	}
+	defer d.Close()
+
+	if *out == "" {
+		s := backup.NewService(d, cfg.Backup())
+		if err := os.MkdirAll(cfg.BackupDir, 0o755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		f, err := s.Run()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+			return 1
+		}
+		fmt.Printf("wrote %s (%s)\n", filepath.Join(cfg.BackupDir, f.Name), f.SizeText())
+		return 0
+	}
+
+	if err := db.BackupTo(d, *out); err != nil {
+		fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+		return 1
+	}
+	fmt.Printf("wrote %s\n", *out)
+	return 0
+}
+
+// runRestore implements "restore": replace the database with a backup.
+func runRestore(args []string) int {
+	cfg := app.LoadConfig()
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	dbPath := fs.String("db", cfg.DBPath, "SQLite database file to replace")
+	check := fs.Bool("check", false, "only check the backup")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher restore [-db path] [-check] backup.sqlite")
+		fmt.Fprintln(fs.Output(), "pullpulse must not be running; the current database is kept as <db>.before-restore-<time>.")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	src := fs.Arg(0)
+
+	if *check {
+		v, err := db.CheckBackup(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "check %s: %v\n", src, err)
+			return 1
+		}
+		fmt.Printf("%s is ok (schema version %d, this binary: %d)\n", src, v, db.LatestVersion())
+		return 0
+	}
+
+	res, err := backup.Restore(src, *dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+		return 1
+	}
+	fmt.Printf("restored %s to %s (schema version %d)\n", src, *dbPath, res.Version)
+	if res.Kept != "" {
+		fmt.Printf("previous database kept as %s\n", res.Kept)
+	}
+	if res.Version < db.LatestVersion() {
+		fmt.Printf("it will be migrated to version %d on the next start\n", db.LatestVersion())
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"dockerhub-pull-watcher/internal/app"
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// runCompact implements "compact": apply the retention policy once.
+func runCompact(args []string) int {
+	cfg := app.LoadConfig()
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	dbPath := fs.String("db", cfg.DBPath, "SQLite database file")
+	p := cfg.Retention()
+	fs.Var((*daysFlag)(&p.Full), "full", "keep every snapshot this long, e.g. 30d (0 = forever)")
+	fs.Var((*daysFlag)(&p.Hourly), "hourly", "keep hourly snapshots this long (0 = forever)")
+	fs.Var((*daysFlag)(&p.RawJSON), "raw-json", "keep raw_json this long (0 = forever)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher compact [-db path] [-full d] [-hourly d] [-raw-json d]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if !p.Enabled() {
+		fmt.Fprintln(os.Stderr, "no retention configured (set RETENTION_FULL, RETENTION_HOURLY or RAW_JSON_TTL)")
+		return 2
+	}
+	if err := p.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	d, ok := openDB(*dbPath)
+	if !ok {
+		return 1
+	}
+	defer d.Close()
+
+	res, err := db.Compact(d, p, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "compact: %v\n", err)
+		return 1
+	}
+	fmt.Printf("compacted %d repos: %d snapshots deleted, %d deltas added, %d removed, %d raw_json cleared\n",
+		res.Repos, res.SnapshotsDeleted, res.DeltasAdded, res.DeltasRemoved, res.RawJSONCleared)
+	return 0
+}
+
+// daysFlag is a duration flag that also accepts whole days ("30d").
+type daysFlag time.Duration
+
+func (f *daysFlag) String() string { return time.Duration(*f).String() }
+
+func (f *daysFlag) Set(v string) error {
+	d, err := app.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*f = daysFlag(d)
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"dockerhub-pull-watcher/internal/app"
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/export"
+)
+
+// runExport implements "export": write snapshots or deltas to a file or
+// stdout.
+func runExport(args []string) int {
+	cfg := app.LoadConfig()
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dbPath := fs.String("db", cfg.DBPath, "SQLite database file")
+	dataset := fs.String("dataset", db.DatasetSnapshots, "snapshots|deltas")
+	format := fs.String("format", export.FormatCSV, "csv|ndjson|json")
+	repo := fs.String("repo", "", "only this repo (namespace/name)")
+	namespace := fs.String("namespace", "", "only repos of this namespace")
+	from := fs.String("from", "", "start time, RFC3339 or YYYY-MM-DD")
+	to := fs.String("to", "", "end time (inclusive), RFC3339 or YYYY-MM-DD")
+	resolution := fs.String("resolution", db.ResolutionRaw, "raw|hour|day|week")
+	out := fs.String("o", "", "output file (default stdout)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher export [-db path] [-dataset snapshots|deltas] [-format csv|ndjson|json] [-repo ns/name | -namespace ns] [-from t] [-to t] [-resolution raw|hour|day|week] [-o file]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	q := db.ExportQuery{Dataset: *dataset, Namespace: *namespace, Resolution: *resolution}
+	var err error
+	if q.From, err = db.ParseTime(*from, false); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -from: %v\n", err)
+		return 2
+	}
+	if q.To, err = db.ParseTime(*to, true); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -to: %v\n", err)
+		return 2
+	}
+	if err := q.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if !export.ValidFormat(*format) {
+		fs.Usage()
+		return 2
+	}
+
+	d, ok := openDB(*dbPath)
+	if !ok {
+		return 1
+	}
+	defer d.Close()
+
+	if *repo != "" {
+		ns, name, ok := strings.Cut(*repo, "/")
+		if !ok {
+			fmt.Fprintln(os.Stderr, "-repo must be namespace/name")
+			return 2
+		}
+		r, err := db.FindRepo(d, ns, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			fmt.Fprintf(os.Stderr, "repo %s not found\n", *repo)
+			return 1
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "repo %s: %v\n", *repo, err)
+			return 1
+		}
+		q.RepoID = r.ID
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := export.Write(d, w, *format, q)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 1
+	}
+	if *out != "" {
+		if err := w.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("exported %d rows to %s\n", n, *out)
+	}
+	return 0
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"dockerhub-pull-watcher/internal/app"
+)
+
+// runHealthcheck implements "healthcheck": probe the running server, for
+// Docker's HEALTHCHECK (the image has no curl).
+func runHealthcheck(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	ready := fs.Bool("ready", false, "check /readyz instead of /healthz")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher healthcheck [-ready]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	_, port, err := net.SplitHostPort(app.LoadConfig().ListenAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LISTEN_ADDR: %v\n", err)
+		return 1
+	}
+	path := "/healthz"
+	if *ready {
+		path = "/readyz"
+	}
+
+	hc := &http.Client{Timeout: 5 * time.Second}
+	resp, err := hc.Get("http://" + net.JoinHostPort("127.0.0.1", port) + path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "%s: status %d\n", path, resp.StatusCode)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"dockerhub-pull-watcher/internal/app"
+	"dockerhub-pull-watcher/internal/importer"
+)
+
+// runImport implements "import": store historical pull counts from CSV.
+func runImport(args []string) int {
+	cfg := app.LoadConfig()
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dbPath := fs.String("db", cfg.DBPath, "SQLite database file")
+	dryRun := fs.Bool("dry-run", false, "check the file, store nothing")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher import [-db path] [-dry-run] file.csv|-")
+		fmt.Fprintln(fs.Output(), "rows: namespace,repo,timestamp,pull_count[,star_count]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var src io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		src = f
+	}
+
+	d, ok := openDB(*dbPath)
+	if !ok {
+		return 1
+	}
+	defer d.Close()
+
+	rep, err := importer.Import(d, src, *dryRun)
+	for _, is := range rep.Issues {
+		fmt.Fprintf(os.Stderr, "line %d: %s: %s\n", is.Line, is.Kind, is.Message)
+	}
+	if n := rep.MoreIssues(); n > 0 {
+		fmt.Fprintf(os.Stderr, "and %d more issues\n", n)
+	}
+	if err != nil && !errors.Is(err, importer.ErrInvalid) {
+		fmt.Fprintf(os.Stderr, "import: %v\n", err)
+		return 1
+	}
+	fmt.Println(rep.Summary())
+	if err != nil {
+		return 1
+	}
+	return 0
+}
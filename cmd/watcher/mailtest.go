@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"dockerhub-pull-watcher/internal/app"
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/mail"
+)
+
+// runMailTest implements "mail-test": send a test mail, or the digest of
+// the previous day or week, with the SMTP_* settings.
+func runMailTest(args []string) int {
+	cfg := app.LoadConfig()
+	fs := flag.NewFlagSet("mail-test", flag.ExitOnError)
+	dbPath := fs.String("db", cfg.DBPath, "SQLite database file (for -digest)")
+	digest := fs.String("digest", "", "send the digest of the previous day or week instead (daily|weekly)")
+	printOnly := fs.Bool("print", false, "print the mail instead of sending it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher mail-test [-db path] [-digest daily|weekly] [-print]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	subject := "Test mail"
+	body := "This is a test mail from pullpulse. SMTP settings work.\n"
+	if *digest != "" {
+		period := map[string]string{"daily": db.PeriodDay, "weekly": db.PeriodWeek}[*digest]
+		if period == "" {
+			fs.Usage()
+			return 2
+		}
+
+		d, ok := openDB(*dbPath)
+		if !ok {
+			return 1
+		}
+		defer d.Close()
+
+		dg, err := db.BuildDigest(d, period, db.PreviousBucket(period, time.Now()))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "digest: %v\n", err)
+			return 1
+		}
+		subject, body = mail.FormatDigest(dg)
+	}
+
+	if *printOnly {
+		fmt.Printf("Subject: [pullpulse] %s\n\n%s", subject, body)
+		return 0
+	}
+	if err := mail.Send(cfg.Mail(), subject, body); err != nil {
+		fmt.Fprintf(os.Stderr, "send: %v\n", err)
+		return 1
+	}
+	fmt.Printf("sent to %d recipient(s)\n", len(cfg.SMTPTo))
+	return 0
+}
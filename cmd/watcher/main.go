@@ -1,17 +1,146 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
 
 	"dockerhub-pull-watcher/internal/app"
+	"dockerhub-pull-watcher/internal/db"
 )
 
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands in the order "help" lists them. Without a command, serve runs.
+var commands = []command{
+	{"serve", "run the web UI and the watcher (default)", runServe},
+	{"poll-once", "poll all due targets, or one target, and exit", runPollOnce},
+	{"targets", "list, add, rm, enable or disable targets", runTargets},
+	{"repos", "list known repos", runRepos},
+	{"migrate", "show or apply schema migrations", runMigrate},
+	{"export", "export snapshots or deltas as CSV, NDJSON or JSON", runExport},
+	{"import", "import historical pull counts from CSV", runImport},
+	{"backup", "back up the database", runBackup},
+	{"restore", "replace the database with a checked backup", runRestore},
+	{"repair-deltas", "recompute deltas from snapshots", runRepairDeltas},
+	{"rebuild-rollups", "recompute rollups from snapshots", runRebuildRollups},
+	{"compact", "apply the retention policy once", runCompact},
+	{"mail-test", "send a test mail or digest", runMailTest},
+	{"healthcheck", "probe the running server (Docker HEALTHCHECK)", runHealthcheck},
+}
+
 func main() {
-	a, err := app.NewFromEnv()
+	if len(os.Args) < 2 {
+		checkConfigFile()
+		os.Exit(runServe(nil))
+	}
+	name, args := os.Args[1], os.Args[2:]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return
+	}
+	checkConfigFile()
+	for _, c := range commands {
+		if c.name == name {
+			os.Exit(c.run(args))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage(os.Stderr)
+	os.Exit(2)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: watcher [command] [flags]")
+	fmt.Fprintln(w, "\ncommands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nSettings come from the environment and CONFIG_FILE (see README); -db overrides DB_PATH.")
+	fmt.Fprintln(w, `Run "watcher <command> -h" for a command's flags.`)
+}
+
+// checkConfigFile exits if CONFIG_FILE is set but broken, before any
+// command runs on partly applied settings.
+func checkConfigFile() {
+	if err := app.CheckConfigFile(); err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runServe implements "serve": the web UI and the schedule loop until
+// SIGINT/SIGTERM. SIGHUP reconciles the config file targets again.
+func runServe(args []string) int {
+	cfg := app.LoadConfig()
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&cfg.DBPath, "db", cfg.DBPath, "SQLite database file")
+	fs.StringVar(&cfg.ListenAddr, "listen", cfg.ListenAddr, "HTTP listen address")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher serve [-db path] [-listen addr]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Catch SIGHUP before startup: its default action kills the process, and
+	// a reload requested meanwhile is applied once the app is up.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	a, err := app.New(cfg)
 	if err != nil {
 		log.Fatalf("startup: %v", err)
 	}
-	if err := a.Run(); err != nil {
+
+	go func() {
+		for {
+			select {
+			case <-hup:
+				if err := a.ReloadTargets(); err != nil {
+					log.Printf("config: reload: %v (targets unchanged)", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if err := a.Run(ctx); err != nil {
 		log.Fatalf("run: %v", err)
 	}
+	return 0
+}
+
+// openDB opens and migrates the database for commands that work on it
+// directly, printing the error.
+func openDB(path string) (*sql.DB, bool) {
+	d, err := db.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open %s: %v\n", path, err)
+		return nil, false
+	}
+	if err := db.Migrate(d); err != nil {
+		d.Close()
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return nil, false
+	}
+	return d, true
 }
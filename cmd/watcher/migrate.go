@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"dockerhub-pull-watcher/internal/app"
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// runMigrate implements "migrate [status|up]".
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dbPath := fs.String("db", app.LoadConfig().DBPath, "SQLite database file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher migrate [-db path] [status|up]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	action := "status"
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+
+	d, err := db.Open(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open %s: %v\n", *dbPath, err)
+		return 1
+	}
+	defer d.Close()
+
+	switch action {
+	case "status":
+	case "up":
+		if err := db.Migrate(d); err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			return 1
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	st, err := db.GetMigrationStatus(d)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "status: %v\n", err)
+		return 1
+	}
+	printMigrationStatus(st)
+	if st.Current > st.Latest {
+		return 1
+	}
+	return 0
+}
+
+func printMigrationStatus(st db.MigrationStatus) {
+	fmt.Printf("current version: %d\n", st.Current)
+	fmt.Printf("latest version:  %d\n", st.Latest)
+	if st.Current > st.Latest {
+		fmt.Println("database is newer than this binary; upgrade pullpulse")
+	}
+	for _, a := range st.Applied {
+		fmt.Printf("  applied  %3d  %-30s %s\n", a.Version, a.Name, a.AppliedUTC)
+	}
+	for _, m := range st.Pending {
+		fmt.Printf("  pending  %3d  %s\n", m.Version, m.Name)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"dockerhub-pull-watcher/internal/app"
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// runPollOnce implements "poll-once": poll the due targets (or one target)
+// like the server would, deliver the resulting notifications and exit. The
+// exit status is 1 if any run failed, so cron and CI notice.
+func runPollOnce(args []string) int {
+	cfg := app.LoadConfig()
+	fs := flag.NewFlagSet("poll-once", flag.ExitOnError)
+	fs.StringVar(&cfg.DBPath, "db", cfg.DBPath, "SQLite database file")
+	target := fs.String("target", "", "poll only this target (id or name), even if it is not due or disabled")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher poll-once [-db path] [-target id|name]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a, err := app.Open(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "startup: %v\n", err)
+		return 1
+	}
+	defer a.Close()
+
+	var id int64
+	if *target != "" {
+		tg, err := findTarget(a.DB(), *target)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		id = tg.ID
+	}
+
+	runs, err := a.PollOnce(ctx, id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "poll: %v\n", err)
+		return 1
+	}
+	if len(runs) == 0 {
+		fmt.Println("no targets due")
+		return 0
+	}
+
+	names := map[int64]string{}
+	if targets, err := db.ListTargets(a.DB()); err == nil {
+		for _, t := range targets {
+			names[t.ID] = t.Name
+		}
+	}
+	code := 0
+	for _, r := range runs {
+		status := "ok"
+		if r.Error != "" || r.ReposFailed > 0 {
+			status = "failed"
+			code = 1
+		}
+		fmt.Printf("%s (#%d): %s in %dms, %d ok, %d failed, %d skipped\n",
+			names[r.TargetID], r.TargetID, status, r.DurationMS, r.ReposOK, r.ReposFailed, r.ReposSkipped)
+		if r.Error != "" {
+			fmt.Printf("  error: %s\n", r.Error)
+		}
+		for _, rr := range r.Repos {
+			if rr.Error != "" {
+				fmt.Printf("  %s: %s\n", rr.Repo, rr.Error)
+			}
+		}
+	}
+	return code
+}
+
+// findTarget resolves a target by id or, failing that, by unique name.
+func findTarget(d *sql.DB, arg string) (db.Target, error) {
+	targets, err := db.ListTargets(d)
+	if err != nil {
+		return db.Target{}, err
+	}
+	var found []db.Target
+	for _, t := range targets {
+		if strconv.FormatInt(t.ID, 10) == arg {
+			return t, nil
+		}
+		if t.Name == arg {
+			found = append(found, t)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return db.Target{}, fmt.Errorf("target %q not found", arg)
+	case 1:
+		return found[0], nil
+	}
+	return db.Target{}, fmt.Errorf("target name %q is ambiguous, use the id", arg)
+}
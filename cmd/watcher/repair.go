@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"dockerhub-pull-watcher/internal/app"
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// runRepairDeltas implements "repair-deltas": recompute repo_deltas from
+// repo_snapshots.
+func runRepairDeltas(args []string) int {
+	fs := flag.NewFlagSet("repair-deltas", flag.ExitOnError)
+	dbPath := fs.String("db", app.LoadConfig().DBPath, "SQLite database file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher repair-deltas [-db path]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	d, ok := openDB(*dbPath)
+	if !ok {
+		return 1
+	}
+	defer d.Close()
+
+	rep, err := db.RepairDeltas(d)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "repair: %v\n", err)
+		return 1
+	}
+	fmt.Printf("checked %d repos: %d deltas added, %d removed\n", rep.Repos, rep.Added, rep.Removed)
+	return 0
+}
+
+// runRebuildRollups implements "rebuild-rollups": recompute the hourly,
+// daily and weekly rollups from repo_snapshots.
+func runRebuildRollups(args []string) int {
+	fs := flag.NewFlagSet("rebuild-rollups", flag.ExitOnError)
+	dbPath := fs.String("db", app.LoadConfig().DBPath, "SQLite database file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher rebuild-rollups [-db path]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	d, ok := openDB(*dbPath)
+	if !ok {
+		return 1
+	}
+	defer d.Close()
+
+	n, err := db.RebuildRollups(d)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rebuild: %v\n", err)
+		return 1
+	}
+	fmt.Printf("rebuilt rollups of %d repos\n", n)
+	return 0
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"dockerhub-pull-watcher/internal/app"
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// reposListItem is one line of "repos list -json".
+type reposListItem struct {
+	db.Repo
+	PullCount   *int64   `json:"pull_count"`
+	PerHour     *float64 `json:"per_hour"`
+	LastSnapUTC string   `json:"last_snapshot_ts_utc,omitempty"`
+}
+
+// runRepos implements "repos list".
+func runRepos(args []string) int {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintln(os.Stderr, "usage: watcher repos list [flags]")
+		return 2
+	}
+	fs := flag.NewFlagSet("repos list", flag.ExitOnError)
+	dbPath := fs.String("db", app.LoadConfig().DBPath, "SQLite database file")
+	namespace := fs.String("namespace", "", "only repos of this namespace")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher repos list [-db path] [-namespace ns] [-json]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args[1:])
+
+	d, ok := openDB(*dbPath)
+	if !ok {
+		return 1
+	}
+	defer d.Close()
+
+	repos, err := db.ListKnownRepos(d)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	latest, err := db.ListRepoLatest(d)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	byID := map[int64]db.RepoLatest{}
+	for _, l := range latest {
+		byID[l.ID] = l
+	}
+
+	items := []reposListItem{}
+	for _, r := range repos {
+		if *namespace != "" && r.Namespace != *namespace {
+			continue
+		}
+		it := reposListItem{Repo: r}
+		if l, ok := byID[r.ID]; ok {
+			it.PullCount, it.LastSnapUTC = &l.PullCount, l.TSUTC
+			if l.HasPerHour {
+				it.PerHour = &l.PerHour
+			}
+		}
+		items = append(items, it)
+	}
+	if *asJSON {
+		return printJSON(items)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tREPO\tPULLS\tPER HOUR\tLAST SNAPSHOT\tGONE SINCE")
+	for _, it := range items {
+		pulls, rate := "-", "-"
+		if it.PullCount != nil {
+			pulls = strconv.FormatInt(*it.PullCount, 10)
+		}
+		if it.PerHour != nil {
+			rate = strconv.FormatFloat(*it.PerHour, 'f', 1, 64)
+		}
+		fmt.Fprintf(tw, "%d\t%s/%s\t%s\t%s\t%s\t%s\n", it.ID, it.Namespace, it.Name, pulls, rate, dash(it.LastSnapUTC), dash(it.GoneUTC))
+	}
+	tw.Flush()
+	return 0
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"dockerhub-pull-watcher/internal/app"
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// runTargets implements "targets list|add|rm|enable|disable".
+func runTargets(args []string) int {
+	sub := ""
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	switch sub {
+	case "list":
+		return runTargetsList(args)
+	case "add":
+		return runTargetsAdd(args)
+	case "rm":
+		return runTargetsRm(args)
+	case "enable":
+		return runTargetsEnable(args, true)
+	case "disable":
+		return runTargetsEnable(args, false)
+	}
+	fmt.Fprintln(os.Stderr, "usage: watcher targets list|add|rm|enable|disable [flags]")
+	return 2
+}
+
+func runTargetsList(args []string) int {
+	fs := flag.NewFlagSet("targets list", flag.ExitOnError)
+	dbPath := fs.String("db", app.LoadConfig().DBPath, "SQLite database file")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher targets list [-db path] [-json]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	d, ok := openDB(*dbPath)
+	if !ok {
+		return 1
+	}
+	defer d.Close()
+
+	targets, err := db.ListTargets(d)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *asJSON {
+		return printJSON(targets)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tMODE\tNAMESPACE\tREPOS\tINTERVAL\tENABLED\tCONFIG FILE\tLAST RUN\tLAST ERROR")
+	for _, t := range targets {
+		managed := "-"
+		switch {
+		case t.ReadOnly:
+			managed = "read-only"
+		case t.FromFile:
+			managed = "yes"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%t\t%s\t%s\t%s\n", t.ID, t.Name, t.Mode, t.Namespace,
+			dash(t.ReposCSV), time.Duration(t.IntervalSeconds)*time.Second, t.Enabled, managed, dash(t.LastRunUTC), dash(t.LastError))
+	}
+	tw.Flush()
+	return 0
+}
+
+func runTargetsAdd(args []string) int {
+	fs := flag.NewFlagSet("targets add", flag.ExitOnError)
+	dbPath := fs.String("db", app.LoadConfig().DBPath, "SQLite database file")
+	t := db.Target{Mode: "user", Enabled: true}
+	fs.StringVar(&t.Name, "name", "", "target name (default: the namespace)")
+	fs.StringVar(&t.Mode, "mode", t.Mode, "user (all public repos of the namespace) or repos")
+	fs.StringVar(&t.Namespace, "namespace", "", "Docker Hub user or organization")
+	fs.StringVar(&t.ReposCSV, "repos", "", "comma separated repos, for -mode repos")
+	interval := 15 * time.Minute
+	fs.Var((*daysFlag)(&interval), "interval", "poll interval, e.g. 15m or 1d")
+	disabled := fs.Bool("disabled", false, "add the target disabled")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher targets add [-db path] -namespace ns [-mode user|repos] [-repos a,b] [-name n] [-interval d] [-disabled]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if t.Name == "" {
+		t.Name = t.Namespace
+	}
+	t.IntervalSeconds = int64(interval.Seconds())
+	t.Enabled = !*disabled
+	if err := t.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	d, ok := openDB(*dbPath)
+	if !ok {
+		return 1
+	}
+	defer d.Close()
+
+	id, err := db.UpsertTarget(d, t)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("added target %s (#%d)\n", t.Name, id)
+	return 0
+}
+
+func runTargetsRm(args []string) int {
+	fs := flag.NewFlagSet("targets rm", flag.ExitOnError)
+	dbPath := fs.String("db", app.LoadConfig().DBPath, "SQLite database file")
+	purge := fs.Bool("purge", false, "also delete the repos and history no other target tracks")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: watcher targets rm [-db path] [-purge] id|name...")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	d, ok := openDB(*dbPath)
+	if !ok {
+		return 1
+	}
+	defer d.Close()
+
+	for _, arg := range fs.Args() {
+		t, err := findTarget(d, arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		purged, err := db.DeleteTarget(d, t.ID, *purge)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("removed target %s (#%d)", t.Name, t.ID)
+		if *purge {
+			fmt.Printf(", purged %d repos", purged)
+		}
+		fmt.Println()
+	}
+	return 0
+}
+
+func runTargetsEnable(args []string, enabled bool) int {
+	verb := map[bool]string{true: "enable", false: "disable"}[enabled]
+	fs := flag.NewFlagSet("targets "+verb, flag.ExitOnError)
+	dbPath := fs.String("db", app.LoadConfig().DBPath, "SQLite database file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: watcher targets %s [-db path] id|name...\n", verb)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	d, ok := openDB(*dbPath)
+	if !ok {
+		return 1
+	}
+	defer d.Close()
+
+	var ids []int64
+	var names []string
+	for _, arg := range fs.Args() {
+		t, err := findTarget(d, arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		ids = append(ids, t.ID)
+		names = append(names, t.Name)
+	}
+	if _, err := db.SetTargetsEnabled(d, ids, enabled); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%sd %s\n", verb, strings.Join(names, ", "))
+	return 0
+}
+
+func printJSON(v any) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -1,25 +1,71 @@
 package app
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 
+	"dockerhub-pull-watcher/internal/backup"
 	"dockerhub-pull-watcher/internal/db"
 	"dockerhub-pull-watcher/internal/dockerhub"
+	"dockerhub-pull-watcher/internal/events"
+	"dockerhub-pull-watcher/internal/mail"
 	"dockerhub-pull-watcher/internal/watcher"
 	"dockerhub-pull-watcher/internal/web"
+	"dockerhub-pull-watcher/internal/webhook"
 )
 
 type App struct {
 	cfg    Config
+	db     *sql.DB
 	w      *watcher.Service
+	hooks  *webhook.Dispatcher
+	mailer *mail.Mailer // nil without SMTP settings
+	backup *backup.Service
 	server *http.Server
 }
 
 func NewFromEnv() (*App, error) {
-	cfg := LoadConfig()
+	return New(LoadConfig())
+}
+
+// New builds the server for cfg; see Open for the parts without HTTP.
+func New(cfg Config) (*App, error) {
+	a, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err := web.LoadTemplates("/app/web/templates")
+	if err != nil {
+		// local dev (non-docker)
+		tpl, err = web.LoadTemplates("web/templates")
+		if err != nil {
+			a.db.Close()
+			return nil, err
+		}
+	}
+
+	router := web.NewRouter(a.db, a.w, tpl, a.backup)
+
+	a.server = &http.Server{
+		Addr:    cfg.ListenAddr,
+		Handler: router,
+	}
+	return a, nil
+}
+
+// Open opens and migrates the database, reconciles the config file targets
+// and wires the watcher to its notifiers, without the HTTP server. Commands
+// that poll use it directly.
+func Open(cfg Config) (*App, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	if err := os.MkdirAll(filepath.Dir(cfg.DBPath), 0o755); err != nil {
 		return nil, err
 	}
@@ -28,38 +74,134 @@ func NewFromEnv() (*App, error) {
 		return nil, err
 	}
 	if err := db.Migrate(d); err != nil {
+		d.Close()
 		return nil, err
 	}
 
 	dh := dockerhub.NewClient(dockerhub.ClientConfig{
+		BaseURL:     cfg.HubBaseURL,
 		HTTPTimeout: cfg.HTTPTimeout,
 		UserAgent:   cfg.UserAgent,
 		Token:       cfg.HubToken,
+		Retry: dockerhub.RetryPolicy{
+			MaxAttempts: cfg.HubRetryMaxAttempts,
+			BaseDelay:   cfg.HubRetryBaseDelay,
+			MaxDelay:    cfg.HubRetryMaxDelay,
+		},
+		RequestsPerMinute: cfg.HubRequestsPerMin,
 	})
 
-	w := watcher.NewService(d, dh)
+	hooks := webhook.NewDispatcher(d, webhook.Config{
+		Timeout:     cfg.WebhookTimeout,
+		MaxAttempts: cfg.WebhookMaxAttempts,
+		UserAgent:   cfg.UserAgent,
+		PublicURL:   cfg.PublicURL,
+	})
 
-	tpl, err := web.LoadTemplates("/app/web/templates")
-	if err != nil {
-		// local dev (non-docker)
-		tpl, err = web.LoadTemplates("web/templates")
-		if err != nil {
-			return nil, err
-		}
+	sinks := events.Multi{hooks}
+	var mailer *mail.Mailer
+	if mc := cfg.Mail(); mc.Enabled() {
+		mailer = mail.NewMailer(d, mc)
+		sinks = append(sinks, mailer)
 	}
 
-	router := web.NewRouter(d, w, tpl)
+	w := watcher.NewService(d, dh, watcher.Config{
+		Workers:         cfg.Workers,
+		RepoTimeout:     cfg.RepoTimeout,
+		Retention:       cfg.Retention(),
+		CompactInterval: cfg.CompactInterval,
+		Events:          sinks,
+	})
 
-	srv := &http.Server{
-		Addr:    cfg.ListenAddr,
-		Handler: router,
+	a := &App{cfg: cfg, db: d, w: w, hooks: hooks, mailer: mailer, backup: backup.NewService(d, cfg.Backup())}
+	if err := a.ReloadTargets(); err != nil {
+		d.Close()
+		return nil, err
 	}
+	return a, nil
+}
+
+// PollOnce polls one target (regardless of its schedule), or with targetID
+// 0 every due target, then sends the resulting webhooks and mails and
+// returns the runs. It is the one-shot alternative to Run for cron jobs.
+func (a *App) PollOnce(ctx context.Context, targetID int64) ([]db.TargetRun, error) {
+	var runs []db.TargetRun
+	var err error
+	if targetID != 0 {
+		var run db.TargetRun
+		if run, err = a.w.PollNow(ctx, targetID); err == nil {
+			runs = append(runs, run)
+		}
+	} else {
+		runs, err = a.w.PollDue(ctx)
+	}
+
+	a.hooks.Flush()
+	if a.mailer != nil {
+		a.mailer.Flush()
+	}
+	return runs, err
+}
+
+// DB returns the database of an opened app.
+func (a *App) DB() *sql.DB {
+	return a.db
+}
 
-	return &App{cfg: cfg, w: w, server: srv}, nil
+// Close closes the database of an app that was opened but not Run.
+func (a *App) Close() error {
+	return a.db.Close()
 }
 
-func (a *App) Run() error {
+// Run serves HTTP and runs the watcher until ctx is cancelled, then shuts
+// down in order: stop accepting requests, drain polls, stop webhook
+// deliveries and mail, let a running backup finish, close the DB.
+func (a *App) Run(ctx context.Context) error {
 	log.Printf("listening on %s", a.cfg.ListenAddr)
+	a.hooks.Start()
+	if a.mailer != nil {
+		a.mailer.Start()
+	}
 	a.w.Start()
-	return a.server.ListenAndServe()
+	a.backup.Start()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- a.server.ListenAndServe()
+	}()
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		log.Printf("shutting down (timeout %s)", a.cfg.ShutdownTimeout)
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			runErr = err
+		}
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := a.server.Shutdown(sctx); err != nil {
+		log.Printf("shutdown: http: %v", err)
+	}
+	if err := a.w.Stop(sctx); err != nil {
+		log.Printf("shutdown: watcher: %v (in-flight polls were cancelled)", err)
+	}
+	if err := a.hooks.Stop(sctx); err != nil {
+		log.Printf("shutdown: webhooks: %v (pending deliveries resume on next start)", err)
+	}
+	if a.mailer != nil {
+		if err := a.mailer.Stop(sctx); err != nil {
+			log.Printf("shutdown: mail: %v", err)
+		}
+	}
+	if err := a.backup.Stop(sctx); err != nil {
+		log.Printf("shutdown: backup: %v", err)
+	}
+	if err := a.db.Close(); err != nil {
+		log.Printf("shutdown: db: %v", err)
+	}
+	return runErr
 }
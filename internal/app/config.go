@@ -1,31 +1,171 @@
 package app
 
 import (
+	"cmp"
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"dockerhub-pull-watcher/internal/backup"
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/mail"
 )
 
 type Config struct {
+	ConfigFile  string
 	DBPath      string
 	ListenAddr  string
 	HTTPTimeout time.Duration
 	UserAgent   string
 	HubToken    string
+	HubBaseURL  string
+
+	HubRetryMaxAttempts int
+	HubRetryBaseDelay   time.Duration
+	HubRetryMaxDelay    time.Duration
+	HubRequestsPerMin   int
+
+	Workers     int
+	RepoTimeout time.Duration
+
+	RetentionFull   time.Duration
+	RetentionHourly time.Duration
+	RawJSONTTL      time.Duration
+	CompactInterval time.Duration
+
+	WebhookTimeout     time.Duration
+	WebhookMaxAttempts int
+	PublicURL          string
+
+	SMTPHost       string
+	SMTPPort       int
+	SMTPUsername   string
+	SMTPPassword   string
+	SMTPFrom       string
+	SMTPTo         []string
+	SMTPTLS        string
+	MailEvents     []string
+	MailDigest     string // off|daily|weekly
+	MailDigestHour int
+
+	BackupDir      string
+	BackupInterval time.Duration
+	BackupKeep     int
+
+	ShutdownTimeout time.Duration
 }
 
 func LoadConfig() Config {
 	return Config{
+		ConfigFile:  configFilePath(),
 		DBPath:      env("DB_PATH", "/data/pulls.sqlite"),
 		ListenAddr:  env("LISTEN_ADDR", ":8080"),
 		HTTPTimeout: envDur("HTTP_TIMEOUT", 15*time.Second),
 		UserAgent:   env("USER_AGENT", "dockerhub-pull-watcher/1.0"),
-		HubToken:    strings.TrimSpace(os.Getenv("DOCKERHUB_TOKEN")),
+		HubToken:    lookup("DOCKERHUB_TOKEN"),
+		HubBaseURL:  env("DOCKERHUB_BASE_URL", "https://hub.docker.com"),
+
+		HubRetryMaxAttempts: envInt("DOCKERHUB_RETRY_MAX_ATTEMPTS", 3),
+		HubRetryBaseDelay:   envDur("DOCKERHUB_RETRY_BASE_DELAY", 500*time.Millisecond),
+		HubRetryMaxDelay:    envDur("DOCKERHUB_RETRY_MAX_DELAY", 30*time.Second),
+		HubRequestsPerMin:   envInt("DOCKERHUB_REQUESTS_PER_MINUTE", 0),
+
+		Workers:     envInt("WATCHER_WORKERS", 4),
+		RepoTimeout: envDur("WATCHER_REPO_TIMEOUT", 30*time.Second),
+
+		// Retention is opt-in; 0 keeps everything.
+		RetentionFull:   envDur("RETENTION_FULL", 0),
+		RetentionHourly: envDur("RETENTION_HOURLY", 0),
+		RawJSONTTL:      envDur("RAW_JSON_TTL", 0),
+		CompactInterval: envDur("COMPACT_INTERVAL", time.Hour),
+
+		WebhookTimeout:     envDur("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookMaxAttempts: envInt("WEBHOOK_MAX_ATTEMPTS", 6),
+		PublicURL:          env("PUBLIC_URL", ""),
+
+		SMTPHost:       env("SMTP_HOST", ""),
+		SMTPPort:       envInt("SMTP_PORT", 587),
+		SMTPUsername:   env("SMTP_USERNAME", ""),
+		SMTPPassword:   cmp.Or(os.Getenv("SMTP_PASSWORD"), fileSetting("SMTP_PASSWORD")),
+		SMTPFrom:       env("SMTP_FROM", ""),
+		SMTPTo:         envList("SMTP_TO", ""),
+		SMTPTLS:        strings.ToLower(env("SMTP_TLS", mail.TLSStartTLS)),
+		MailEvents:     envList("MAIL_EVENTS", "alert.fired,target.run_failed,repo.gone"),
+		MailDigest:     strings.ToLower(env("MAIL_DIGEST", "off")),
+		MailDigestHour: envInt("MAIL_DIGEST_HOUR", 7),
+
+		// Scheduled backups are opt-in.
+		BackupDir:      env("BACKUP_DIR", ""),
+		BackupInterval: envDur("BACKUP_INTERVAL", 24*time.Hour),
+		BackupKeep:     envInt("BACKUP_KEEP", 7),
+
+		// Docker sends SIGKILL 10s after SIGTERM by default.
+		ShutdownTimeout: envDur("SHUTDOWN_TIMEOUT", 8*time.Second),
+	}
+}
+
+// Validate rejects settings that would otherwise be ignored or misapplied
+// silently. LoadConfig falls back to defaults for unparsable values; these
+// are the ones where a typo changes behavior.
+func (c Config) Validate() error {
+	if err := c.Retention().Validate(); err != nil {
+		return fmt.Errorf("RETENTION_HOURLY/RETENTION_FULL: %w", err)
+	}
+	switch c.MailDigest {
+	case "off", "daily", "weekly":
+	default:
+		return fmt.Errorf("MAIL_DIGEST: %q is not off, daily or weekly", c.MailDigest)
+	}
+	switch c.SMTPTLS {
+	case mail.TLSStartTLS, mail.TLSImplicit, mail.TLSNone:
+	default:
+		return fmt.Errorf("SMTP_TLS: %q is not %s, %s or %s", c.SMTPTLS, mail.TLSStartTLS, mail.TLSImplicit, mail.TLSNone)
+	}
+	return nil
+}
+
+func (c Config) Retention() db.RetentionPolicy {
+	return db.RetentionPolicy{
+		Full:    c.RetentionFull,
+		Hourly:  c.RetentionHourly,
+		RawJSON: c.RawJSONTTL,
+	}
+}
+
+// Mail returns the SMTP notifier settings; Enabled() is false without
+// SMTP_HOST and SMTP_TO.
+func (c Config) Mail() mail.Config {
+	digest := ""
+	switch c.MailDigest {
+	case "daily":
+		digest = db.PeriodDay
+	case "weekly":
+		digest = db.PeriodWeek
+	}
+	return mail.Config{
+		Host:       c.SMTPHost,
+		Port:       c.SMTPPort,
+		Username:   c.SMTPUsername,
+		Password:   c.SMTPPassword,
+		From:       c.SMTPFrom,
+		To:         c.SMTPTo,
+		TLS:        c.SMTPTLS,
+		Events:     c.MailEvents,
+		Digest:     digest,
+		DigestHour: c.MailDigestHour,
 	}
 }
 
+// Backup returns the scheduled backup settings; Enabled() is false without
+// BACKUP_DIR.
+func (c Config) Backup() backup.Config {
+	return backup.Config{Dir: c.BackupDir, Interval: c.BackupInterval, Keep: c.BackupKeep}
+}
+
 func env(k, def string) string {
-	v := strings.TrimSpace(os.Getenv(k))
+	v := lookup(k)
 	if v == "" {
 		return def
 	}
@@ -33,13 +173,52 @@ func env(k, def string) string {
 }
 
 func envDur(k string, def time.Duration) time.Duration {
-	v := strings.TrimSpace(os.Getenv(k))
+	v := lookup(k)
 	if v == "" {
 		return def
 	}
-	d, err := time.ParseDuration(v)
+	d, err := ParseDuration(v)
 	if err != nil {
 		return def
 	}
 	return d
 }
+
+// ParseDuration extends time.ParseDuration with whole days ("30d").
+func ParseDuration(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// envList splits a comma separated value; "none" yields an empty list.
+func envList(k, def string) []string {
+	v := env(k, def)
+	if v == "none" {
+		return nil
+	}
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func envInt(k string, def int) int {
+	v := lookup(k)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// FileConfig is the YAML file named by CONFIG_FILE. Settings holds
+// environment variable names and values; a variable that is set in the
+// environment wins. Targets, when the key is present, is the complete list
+// of file-managed targets.
+type FileConfig struct {
+	Settings map[string]string `yaml:"settings"`
+	ReadOnly bool              `yaml:"read_only"`
+	Targets  *[]FileTarget     `yaml:"targets"`
+}
+
+type FileTarget struct {
+	Name      string   `yaml:"name"` // default: the namespace
+	Mode      string   `yaml:"mode"` // default: repos with repos, else user
+	Namespace string   `yaml:"namespace"`
+	Repos     []string `yaml:"repos"`
+	Interval  string   `yaml:"interval"` // e.g. 15m, 6h, 1d; default 15m
+	Enabled   *bool    `yaml:"enabled"`  // default true
+}
+
+// LoadConfigFile reads and validates a config file. Unknown keys are
+// errors, so a typo doesn't silently drop a setting.
+func LoadConfigFile(path string) (FileConfig, error) {
+	var fc FileConfig
+	f, err := os.Open(path)
+	if err != nil {
+		return fc, err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&fc); err != nil && !errors.Is(err, io.EOF) {
+		return fc, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := fc.DBTargets(); err != nil {
+		return fc, fmt.Errorf("%s: %w", path, err)
+	}
+	return fc, nil
+}
+
+// DBTargets converts and validates the file targets.
+func (fc FileConfig) DBTargets() ([]db.Target, error) {
+	if fc.Targets == nil {
+		return nil, nil
+	}
+	out := make([]db.Target, 0, len(*fc.Targets))
+	seen := map[string]bool{}
+	for i, ft := range *fc.Targets {
+		t := db.Target{
+			Name:      strings.TrimSpace(ft.Name),
+			Mode:      strings.TrimSpace(ft.Mode),
+			Namespace: strings.TrimSpace(ft.Namespace),
+			ReposCSV:  strings.Join(db.Target{ReposCSV: strings.Join(ft.Repos, ",")}.ReposList(), ","),
+			Enabled:   ft.Enabled == nil || *ft.Enabled,
+		}
+		if t.Name == "" {
+			t.Name = t.Namespace
+		}
+		if t.Mode == "" {
+			t.Mode = "user"
+			if t.ReposCSV != "" {
+				t.Mode = "repos"
+			}
+		}
+		if ft.Interval != "" {
+			d, err := ParseDuration(ft.Interval)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("targets[%d]: invalid interval %q", i, ft.Interval)
+			}
+			t.IntervalSeconds = int64(d.Seconds())
+		}
+		if err := t.Validate(); err != nil {
+			return nil, fmt.Errorf("targets[%d]: %w", i, err)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("targets[%d]: duplicate name %q", i, t.Name)
+		}
+		seen[t.Name] = true
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// The settings of CONFIG_FILE, read once per process. used collects the
+// keys LoadConfig asked for, to catch unknown ones.
+var fileSettings struct {
+	once sync.Once
+	vals map[string]string
+	used map[string]bool
+	err  error
+}
+
+func fileSetting(k string) string {
+	fileSettings.once.Do(func() {
+		fileSettings.used = map[string]bool{}
+		if path := configFilePath(); path != "" {
+			fc, err := LoadConfigFile(path)
+			fileSettings.vals, fileSettings.err = fc.Settings, err
+		}
+	})
+	fileSettings.used[k] = true
+	return fileSettings.vals[k]
+}
+
+func configFilePath() string {
+	return strings.TrimSpace(os.Getenv("CONFIG_FILE"))
+}
+
+// lookup returns the environment variable k, falling back to the config
+// file settings.
+func lookup(k string) string {
+	fv := fileSetting(k)
+	if v := strings.TrimSpace(os.Getenv(k)); v != "" {
+		return v
+	}
+	return strings.TrimSpace(fv)
+}
+
+// CheckConfigFile reports whether CONFIG_FILE, if set, loads and only holds
+// settings LoadConfig knows. Commands call it before anything else, since
+// LoadConfig itself can't fail.
+func CheckConfigFile() error {
+	LoadConfig()
+	if fileSettings.err != nil {
+		return fileSettings.err
+	}
+	var unknown []string
+	for k := range fileSettings.vals {
+		if !fileSettings.used[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return fmt.Errorf("%s: unknown settings: %s", configFilePath(), strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// ReloadTargets reconciles the targets of the config file into the
+// database. Settings are only read at startup, so a reload (SIGHUP) picks up
+// target changes only. Without a config file, or a targets key in it,
+// nothing changes.
+func (a *App) ReloadTargets() error {
+	if a.cfg.ConfigFile == "" {
+		return nil
+	}
+	fc, err := LoadConfigFile(a.cfg.ConfigFile)
+	if err != nil {
+		return err
+	}
+	if fc.Targets == nil {
+		return nil
+	}
+	want, err := fc.DBTargets()
+	if err != nil {
+		return err
+	}
+	res, err := db.ReconcileTargets(a.db, want, fc.ReadOnly)
+	if err != nil {
+		return fmt.Errorf("%s: %w", a.cfg.ConfigFile, err)
+	}
+	log.Printf("config: %s: targets %s", a.cfg.ConfigFile, res.Summary())
+	return nil
+}
@@ -0,0 +1,270 @@
+// Package backup takes scheduled, rotated backups of the SQLite database,
+// streams on-demand backups and restores a backup file over the database.
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// Backup files are named pullpulse-<UTC time>.sqlite, so they sort by age.
+const (
+	filePrefix = "pullpulse-"
+	fileSuffix = ".sqlite"
+	timeLayout = "20060102T150405Z"
+)
+
+// Config controls scheduled backups.
+type Config struct {
+	Dir      string        // "" = no scheduled backups
+	Interval time.Duration // time between backups, default 24h
+	Keep     int           // backups kept, default 7
+}
+
+// Enabled reports whether scheduled backups are configured.
+func (c Config) Enabled() bool {
+	return c.Dir != ""
+}
+
+func (c Config) withDefaults() Config {
+	if c.Interval <= 0 {
+		c.Interval = 24 * time.Hour
+	}
+	if c.Keep <= 0 {
+		c.Keep = 7
+	}
+	return c
+}
+
+// File is a backup in the backup directory.
+type File struct {
+	Name    string
+	Size    int64
+	TimeUTC time.Time
+}
+
+// SizeText is the size for display, e.g. "12.3 MB".
+func (f File) SizeText() string {
+	return formatSize(f.Size)
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+type Service struct {
+	db  *sql.DB
+	cfg Config
+
+	mu   sync.Mutex // one backup at a time
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+func NewService(dbx *sql.DB, cfg Config) *Service {
+	return &Service{db: dbx, cfg: cfg.withDefaults(), stop: make(chan struct{})}
+}
+
+// Config returns the effective settings.
+func (s *Service) Config() Config {
+	return s.cfg
+}
+
+// Start runs scheduled backups in the background, if enabled.
+func (s *Service) Start() {
+	if !s.cfg.Enabled() {
+		return
+	}
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop waits for a running backup to finish.
+func (s *Service) Stop(ctx context.Context) error {
+	s.once.Do(func() { close(s.stop) })
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loop takes a backup whenever the newest one is older than Interval. The
+// age is taken from the files, so restarts neither skip nor repeat backups.
+func (s *Service) loop() {
+	defer s.wg.Done()
+	if err := os.MkdirAll(s.cfg.Dir, 0o755); err != nil {
+		log.Printf("backup: %v", err)
+	}
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		if s.due(time.Now()) {
+			if f, err := s.Run(); err != nil {
+				log.Printf("backup: %v", err)
+			} else {
+				log.Printf("backup: wrote %s (%s)", f.Name, f.SizeText())
+			}
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (s *Service) due(now time.Time) bool {
+	files, err := s.List()
+	if err != nil {
+		log.Printf("backup: %v", err)
+		return false
+	}
+	return len(files) == 0 || now.Sub(files[0].TimeUTC) >= s.cfg.Interval
+}
+
+// Run takes a backup into the backup directory now and deletes the oldest
+// ones beyond Keep.
+func (s *Service) Run() (File, error) {
+	if !s.cfg.Enabled() {
+		return File{}, errors.New("no backup directory configured")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	name := FileName(now)
+	path := filepath.Join(s.cfg.Dir, name)
+	// Written under a temporary name so a crash never leaves a partial
+	// file that looks like a backup.
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+	if err := db.BackupTo(s.db, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return File{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return File{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return File{}, err
+	}
+	if err := s.rotate(); err != nil {
+		log.Printf("backup: rotate: %v", err)
+	}
+	return File{Name: name, Size: fi.Size(), TimeUTC: now.Truncate(time.Second)}, nil
+}
+
+func (s *Service) rotate() error {
+	files, err := s.List()
+	if err != nil {
+		return err
+	}
+	for _, f := range files[min(len(files), s.cfg.Keep):] {
+		if err := os.Remove(filepath.Join(s.cfg.Dir, f.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the backups in the backup directory, newest first.
+func (s *Service) List() ([]File, error) {
+	if !s.cfg.Enabled() {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	for _, e := range entries {
+		ts, ok := parseName(e.Name())
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, File{Name: e.Name(), Size: fi.Size(), TimeUTC: ts})
+	}
+	slices.SortFunc(files, func(a, b File) int { return b.TimeUTC.Compare(a.TimeUTC) })
+	return files, nil
+}
+
+func parseName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+		return time.Time{}, false
+	}
+	ts, err := time.Parse(timeLayout, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+	return ts, err == nil
+}
+
+// Path returns the path of the backup called name, or os.ErrNotExist if
+// there is none (names from elsewhere are never resolved).
+func (s *Service) Path(name string) (string, error) {
+	files, err := s.List()
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if f.Name == name {
+			return filepath.Join(s.cfg.Dir, f.Name), nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// Fresh takes a backup into a temporary file and returns it open for
+// reading, so a slow download doesn't hold up the database. The file is
+// already unlinked; closing it frees the space.
+func (s *Service) Fresh() (*os.File, error) {
+	f, err := os.CreateTemp("", "pullpulse-backup-*"+fileSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	if err := db.BackupTo(s.db, f.Name()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// FileName is the download name of a backup taken at t.
+func FileName(t time.Time) string {
+	return filePrefix + t.UTC().Format(timeLayout) + fileSuffix
+}
@@ -0,0 +1,110 @@
+package backup
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// newDB creates a migrated database at path holding one repo called name.
+func newDB(t *testing.T, path, name string) {
+	t.Helper()
+	dbx, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbx.Close()
+	if err := db.Migrate(dbx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.EnsureRepo(dbx, "ns", name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// repoNames lists the repos in the database at path.
+func repoNames(t *testing.T, path string) []string {
+	t.Helper()
+	dbx, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbx.Close()
+	rows, err := dbx.Query(`SELECT name FROM repos ORDER BY name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Name())
+	}
+	return out
+}
+
+func TestRunRotatesBackups(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "pulls.sqlite")
+	newDB(t, dbPath, "r")
+	dbx, err := db.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbx.Close()
+
+	dir := t.TempDir()
+	old := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 4 {
+		if err := os.WriteFile(filepath.Join(dir, FileName(old.AddDate(0, 0, i))), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewService(dbx, Config{Dir: dir, Keep: 3})
+	f, err := s.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	want := []string{f.Name, FileName(old.AddDate(0, 0, 3)), FileName(old.AddDate(0, 0, 2))}
+	if !slices.Equal(names, want) {
+		t.Errorf("backups = %v, want %v", names, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("rotation touched a file that isn't a backup: %v", err)
+	}
+	if _, err := db.CheckBackup(filepath.Join(dir, f.Name)); err != nil {
+		t.Errorf("new backup: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// RestoreResult tells what Restore did.
+type RestoreResult struct {
+	Version int    // schema version of the restored backup
+	Kept    string // where the replaced database was moved, "" if there was none
+}
+
+// Restore replaces the database at dbPath with the backup at src. The backup
+// is checked first (integrity and schema version); the current database is
+// checkpointed and kept next to it as <db>.before-restore-<time>. pullpulse
+// must not be running.
+func Restore(src, dbPath string) (RestoreResult, error) {
+	var res RestoreResult
+	if same, err := sameFile(src, dbPath); err != nil || same {
+		if err == nil {
+			err = errors.New("backup and database are the same file")
+		}
+		return res, err
+	}
+	v, err := db.CheckBackup(src)
+	if err != nil {
+		return res, fmt.Errorf("check %s: %w", src, err)
+	}
+	res.Version = v
+
+	// Copy next to the database first, so the swap is a rename.
+	tmp := dbPath + ".restore.tmp"
+	if err := copyFile(src, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return res, err
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := checkpoint(dbPath); err != nil {
+			_ = os.Remove(tmp)
+			return res, fmt.Errorf("checkpoint %s: %w", dbPath, err)
+		}
+		res.Kept = dbPath + ".before-restore-" + time.Now().UTC().Format(timeLayout)
+		if err := os.Rename(dbPath, res.Kept); err != nil {
+			_ = os.Remove(tmp)
+			return res, err
+		}
+	}
+	// The WAL and shared memory of the old database must not be applied to
+	// the restored one.
+	for _, ext := range []string{"-wal", "-shm"} {
+		if err = os.Remove(dbPath + ext); errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, dbPath)
+	}
+	if err != nil {
+		// Put the old database back rather than leave none.
+		_ = os.Remove(tmp)
+		if res.Kept != "" {
+			if rerr := os.Rename(res.Kept, dbPath); rerr != nil {
+				return res, fmt.Errorf("%w; the old database is at %s", err, res.Kept)
+			}
+			res.Kept = ""
+		}
+		return res, err
+	}
+	return res, nil
+}
+
+// checkpoint folds the WAL into the database file, so moving the file
+// alone keeps everything.
+func checkpoint(path string) error {
+	d, err := db.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	_, err = d.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`)
+	return err
+}
+
+func sameFile(a, b string) (bool, error) {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	fb, err := os.Stat(b)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(fa, fb), nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
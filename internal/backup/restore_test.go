@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRestoreKeepsTheOldDatabase(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "pulls.sqlite")
+	newDB(t, dbPath, "old")
+	src := filepath.Join(t.TempDir(), "backup.sqlite")
+	newDB(t, src, "restored")
+
+	res, err := Restore(src, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Version == 0 || res.Kept == "" {
+		t.Fatalf("Restore = %+v, want a version and the kept path", res)
+	}
+	if got := repoNames(t, dbPath); !slices.Equal(got, []string{"restored"}) {
+		t.Errorf("restored database has repos %v", got)
+	}
+	if got := repoNames(t, res.Kept); !slices.Equal(got, []string{"old"}) {
+		t.Errorf("kept database has repos %v", got)
+	}
+	for _, name := range dirNames(t, dir) {
+		if filepath.Ext(name) == ".tmp" {
+			t.Errorf("left %s behind", name)
+		}
+	}
+}
+
+func TestRestoreRejectsBadBackups(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "pulls.sqlite")
+	newDB(t, dbPath, "old")
+	before := dirNames(t, dir)
+
+	corrupt := filepath.Join(t.TempDir(), "corrupt.sqlite")
+	if err := os.WriteFile(corrupt, []byte("not a database at all, only text"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{corrupt, dbPath} {
+		if _, err := Restore(src, dbPath); err == nil {
+			t.Errorf("Restore(%s) succeeded", src)
+		}
+	}
+
+	if got := dirNames(t, dir); !slices.Equal(got, before) {
+		t.Errorf("files = %v, want %v", got, before)
+	}
+	if got := repoNames(t, dbPath); !slices.Equal(got, []string{"old"}) {
+		t.Errorf("database has repos %v after failed restores", got)
+	}
+}
@@ -0,0 +1,388 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Alert metrics.
+const (
+	MetricPullCount = "pull_count"
+	MetricPerHour   = "per_hour" // pulls per hour since the base snapshot
+	MetricDelta     = "delta"    // pulls since the base snapshot
+	MetricStarCount = "star_count"
+)
+
+var (
+	AlertMetrics = []string{MetricPullCount, MetricPerHour, MetricDelta, MetricStarCount}
+	AlertOps     = []string{">", ">=", "<", "<=", "==", "!="}
+)
+
+type AlertRule struct {
+	ID              int64   `json:"id"`
+	Name            string  `json:"name"`
+	Namespace       string  `json:"namespace"`
+	Repo            string  `json:"repo"` // empty = every repo of Namespace
+	Metric          string  `json:"metric"`
+	Op              string  `json:"op"`
+	Threshold       float64 `json:"threshold"`
+	WindowSeconds   int64   `json:"window_seconds"` // delta/per_hour base: 0 = previous snapshot
+	CooldownSeconds int64   `json:"cooldown_seconds"`
+	Enabled         bool    `json:"enabled"`
+
+	Firing int `json:"firing"` // repos the condition currently holds for (read-only)
+}
+
+// Validate checks the fields a caller controls before UpsertAlertRule.
+func (a AlertRule) Validate() error {
+	if strings.TrimSpace(a.Name) == "" {
+		return errors.New("name is required")
+	}
+	if strings.TrimSpace(a.Namespace) == "" {
+		return errors.New("namespace is required")
+	}
+	if !slices.Contains(AlertMetrics, a.Metric) {
+		return fmt.Errorf("metric must be one of %s", strings.Join(AlertMetrics, ", "))
+	}
+	if !slices.Contains(AlertOps, a.Op) {
+		return fmt.Errorf("op must be one of %s", strings.Join(AlertOps, " "))
+	}
+	if a.WindowSeconds < 0 || a.CooldownSeconds < 0 {
+		return errors.New("window_seconds and cooldown_seconds must not be negative")
+	}
+	return nil
+}
+
+// Holds reports whether v satisfies the rule's comparison.
+func (a AlertRule) Holds(v float64) bool {
+	switch a.Op {
+	case ">":
+		return v > a.Threshold
+	case ">=":
+		return v >= a.Threshold
+	case "<":
+		return v < a.Threshold
+	case "<=":
+		return v <= a.Threshold
+	case "==":
+		return v == a.Threshold
+	case "!=":
+		return v != a.Threshold
+	}
+	return false
+}
+
+// Condition renders the rule for humans, e.g. "delta over 24h < 10".
+func (a AlertRule) Condition() string {
+	m := a.Metric
+	if (m == MetricDelta || m == MetricPerHour) && a.WindowSeconds > 0 {
+		m += " over " + (time.Duration(a.WindowSeconds) * time.Second).String()
+	}
+	return m + " " + a.Op + " " + FormatAlertValue(a.Threshold)
+}
+
+// ThresholdText prints the threshold without exponent or rounding.
+func (a AlertRule) ThresholdText() string {
+	return strconv.FormatFloat(a.Threshold, 'f', -1, 64)
+}
+
+// Scope is "namespace/repo" or "namespace/*".
+func (a AlertRule) Scope() string {
+	if a.Repo == "" {
+		return a.Namespace + "/*"
+	}
+	return a.Namespace + "/" + a.Repo
+}
+
+// FormatAlertValue prints whole numbers without decimals.
+func FormatAlertValue(v float64) string {
+	if v == float64(int64(v)) {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+const alertRuleCols = `id, name, namespace, COALESCE(repo,''), metric, op, threshold, window_seconds, cooldown_seconds, enabled,
+	(SELECT COUNT(*) FROM alert_state s WHERE s.rule_id=alert_rules.id AND s.firing=1)`
+
+func scanAlertRule(sc interface{ Scan(...any) error }) (AlertRule, error) {
+	var a AlertRule
+	var enabled int
+	if err := sc.Scan(&a.ID, &a.Name, &a.Namespace, &a.Repo, &a.Metric, &a.Op, &a.Threshold,
+		&a.WindowSeconds, &a.CooldownSeconds, &enabled, &a.Firing); err != nil {
+		return AlertRule{}, err
+	}
+	a.Enabled = enabled == 1
+	return a, nil
+}
+
+func queryAlertRules(q interface {
+	Query(string, ...any) (*sql.Rows, error)
+}, where string, args ...any) ([]AlertRule, error) {
+	rows, err := q.Query(`SELECT `+alertRuleCols+` FROM alert_rules `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []AlertRule
+	for rows.Next() {
+		a, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func ListAlertRules(dbx *sql.DB) ([]AlertRule, error) {
+	return queryAlertRules(dbx, "")
+}
+
+func GetAlertRule(dbx *sql.DB, id int64) (AlertRule, error) {
+	return scanAlertRule(dbx.QueryRow(`SELECT `+alertRuleCols+` FROM alert_rules WHERE id=?`, id))
+}
+
+// UpsertAlertRule creates or updates a rule. Changing the condition resets
+// its state, so a condition that already holds fires again.
+func UpsertAlertRule(dbx *sql.DB, a AlertRule) (int64, error) {
+	if a.ID == 0 {
+		res, err := dbx.Exec(`INSERT INTO alert_rules(name, namespace, repo, metric, op, threshold, window_seconds, cooldown_seconds, enabled)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			a.Name, a.Namespace, nullIfEmpty(a.Repo), a.Metric, a.Op, a.Threshold, a.WindowSeconds, a.CooldownSeconds, boolToInt(a.Enabled))
+		if err != nil {
+			return 0, err
+		}
+		return res.LastInsertId()
+	}
+
+	old, err := GetAlertRule(dbx, a.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := dbx.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE alert_rules SET name=?, namespace=?, repo=?, metric=?, op=?, threshold=?, window_seconds=?, cooldown_seconds=?, enabled=?
+		WHERE id=?`,
+		a.Name, a.Namespace, nullIfEmpty(a.Repo), a.Metric, a.Op, a.Threshold, a.WindowSeconds, a.CooldownSeconds, boolToInt(a.Enabled),
+		a.ID); err != nil {
+		return 0, err
+	}
+	if old.Namespace != a.Namespace || old.Repo != a.Repo || old.Metric != a.Metric || old.Op != a.Op ||
+		old.Threshold != a.Threshold || old.WindowSeconds != a.WindowSeconds {
+		if _, err := tx.Exec(`DELETE FROM alert_state WHERE rule_id=?`, a.ID); err != nil {
+			return 0, err
+		}
+	}
+	return a.ID, tx.Commit()
+}
+
+// DeleteAlertRule removes a rule with its state and firing history.
+func DeleteAlertRule(dbx *sql.DB, id int64) error {
+	res, err := dbx.Exec(`DELETE FROM alert_rules WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+type AlertFiring struct {
+	ID        int64   `json:"id"`
+	RuleID    int64   `json:"rule_id"`
+	RuleName  string  `json:"rule_name"`
+	Metric    string  `json:"metric"`
+	Op        string  `json:"op"`
+	Condition string  `json:"condition"`
+	RepoID    int64   `json:"repo_id"`
+	Namespace string  `json:"namespace"`
+	Repo      string  `json:"repo"`
+	TSUTC     string  `json:"ts_utc"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+}
+
+func (f AlertFiring) ValueText() string { return FormatAlertValue(f.Value) }
+
+// QueryAlertFirings returns firings newest first, of one rule or (ruleID 0)
+// of all rules, filtered by ts_utc.
+func QueryAlertFirings(dbx *sql.DB, ruleID int64, opts ListOpts) ([]AlertFiring, error) {
+	q := `SELECT f.id, f.rule_id, f.repo_id, r.namespace, r.name, f.ts_utc, f.value, f.threshold
+		FROM alert_firings f JOIN repos r ON r.id = f.repo_id WHERE 1=1`
+	var args []any
+	if ruleID != 0 {
+		q += ` AND f.rule_id=?`
+		args = append(args, ruleID)
+	}
+	q, args = appendRange(q, args, "f.ts_utc", opts)
+	q += ` ORDER BY f.id DESC`
+	q, args = appendLimit(q, args, opts)
+
+	rows, err := dbx.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	out := []AlertFiring{}
+	for rows.Next() {
+		var f AlertFiring
+		if err := rows.Scan(&f.ID, &f.RuleID, &f.RepoID, &f.Namespace, &f.Repo, &f.TSUTC, &f.Value, &f.Threshold); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rules, err := ListAlertRules(dbx)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[int64]AlertRule{}
+	for _, a := range rules {
+		byID[a.ID] = a
+	}
+	for i := range out {
+		a := byID[out[i].RuleID]
+		a.Threshold = out[i].Threshold
+		out[i].RuleName = a.Name
+		out[i].Metric = a.Metric
+		out[i].Op = a.Op
+		out[i].Condition = a.Condition()
+	}
+	return out, nil
+}
+
+// EvaluateAlertRules checks the enabled rules covering a repo against its
+// snapshot at ts. Rules are edge-triggered: a rule fires when its condition
+// starts to hold and not again until it stopped holding in between; a
+// cooldown also drops firings that follow the previous one too closely.
+// Rules whose value can't be computed yet (no base snapshot) are skipped.
+func EvaluateAlertRules(dbx *sql.DB, repoID int64, namespace, name string, ts time.Time) ([]AlertFiring, error) {
+	tx, err := dbx.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rules, err := queryAlertRules(tx, `WHERE enabled=1 AND namespace=? AND (repo IS NULL OR repo=?)`, namespace, name)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+
+	tsUTC := ts.UTC().Format(time.RFC3339)
+	var cur RepoSnapshot
+	err = tx.QueryRow(`SELECT ts_utc, pull_count, COALESCE(star_count,0) FROM repo_snapshots WHERE repo_id=? AND ts_utc=?`,
+		repoID, tsUTC).Scan(&cur.TSUTC, &cur.PullCount, &cur.StarCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fired []AlertFiring
+	for _, a := range rules {
+		v, ok, err := alertValue(tx, a, repoID, cur, ts)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		holds := a.Holds(v)
+
+		var firing int
+		var lastFired string
+		err = tx.QueryRow(`SELECT firing, COALESCE(last_fired_ts_utc,'') FROM alert_state WHERE rule_id=? AND repo_id=?`,
+			a.ID, repoID).Scan(&firing, &lastFired)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		fire := holds && firing == 0
+		if fire && lastFired != "" && a.CooldownSeconds > 0 {
+			if t, err := time.Parse(time.RFC3339, lastFired); err == nil && ts.Sub(t) < time.Duration(a.CooldownSeconds)*time.Second {
+				fire = false
+			}
+		}
+		if fire {
+			lastFired = tsUTC
+		}
+
+		if _, err := tx.Exec(`INSERT INTO alert_state(rule_id, repo_id, firing, value, evaluated_ts_utc, last_fired_ts_utc)
+			VALUES(?, ?, ?, ?, ?, ?)
+			ON CONFLICT(rule_id, repo_id) DO UPDATE SET firing=excluded.firing, value=excluded.value,
+				evaluated_ts_utc=excluded.evaluated_ts_utc, last_fired_ts_utc=excluded.last_fired_ts_utc`,
+			a.ID, repoID, boolToInt(holds), v, tsUTC, nullIfEmpty(lastFired)); err != nil {
+			return nil, err
+		}
+		if !fire {
+			continue
+		}
+
+		res, err := tx.Exec(`INSERT INTO alert_firings(rule_id, repo_id, ts_utc, value, threshold) VALUES(?, ?, ?, ?, ?)`,
+			a.ID, repoID, tsUTC, v, a.Threshold)
+		if err != nil {
+			return nil, err
+		}
+		id, _ := res.LastInsertId()
+		fired = append(fired, AlertFiring{
+			ID: id, RuleID: a.ID, RuleName: a.Name, Metric: a.Metric, Op: a.Op, Condition: a.Condition(),
+			RepoID: repoID, Namespace: namespace, Repo: name,
+			TSUTC: tsUTC, Value: v, Threshold: a.Threshold,
+		})
+	}
+	return fired, tx.Commit()
+}
+
+// alertValue computes a rule's metric for the snapshot cur. delta and
+// per_hour compare with the latest snapshot at least WindowSeconds older
+// (or simply the previous one) and are unknown without it.
+func alertValue(tx *sql.Tx, a AlertRule, repoID int64, cur RepoSnapshot, ts time.Time) (float64, bool, error) {
+	switch a.Metric {
+	case MetricPullCount:
+		return float64(cur.PullCount), true, nil
+	case MetricStarCount:
+		return float64(cur.StarCount), true, nil
+	}
+
+	q := `SELECT ts_utc, pull_count FROM repo_snapshots WHERE repo_id=? AND ts_utc < ? ORDER BY ts_utc DESC LIMIT 1`
+	bound := cur.TSUTC
+	if a.WindowSeconds > 0 {
+		q = `SELECT ts_utc, pull_count FROM repo_snapshots WHERE repo_id=? AND ts_utc <= ? ORDER BY ts_utc DESC LIMIT 1`
+		bound = ts.Add(-time.Duration(a.WindowSeconds) * time.Second).UTC().Format(time.RFC3339)
+	}
+	var baseTS string
+	var basePull int64
+	err := tx.QueryRow(q, repoID, bound).Scan(&baseTS, &basePull)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	d, ok := computeDelta(baseTS, basePull, cur.TSUTC, cur.PullCount)
+	if !ok {
+		return 0, false, nil
+	}
+	if a.Metric == MetricDelta {
+		return float64(d.Delta), true, nil
+	}
+	return d.PerHour, true, nil
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// evaluate checks the rules of ns/r at ts and returns the fired values.
+func evaluate(t *testing.T, dbx *sql.DB, repoID int64, ts time.Time) []float64 {
+	t.Helper()
+	fired, err := EvaluateAlertRules(dbx, repoID, "ns", "r", ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []float64
+	for _, f := range fired {
+		out = append(out, f.Value)
+	}
+	return out
+}
+
+func newAlertRule(t *testing.T, dbx *sql.DB, a AlertRule) AlertRule {
+	t.Helper()
+	a.Name, a.Namespace, a.Enabled = "rule", "ns", true
+	id, err := UpsertAlertRule(dbx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.ID = id
+	return a
+}
+
+func TestAlertRulesAreEdgeTriggered(t *testing.T) {
+	dbx := openTestDB(t)
+	repoID, err := EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newAlertRule(t, dbx, AlertRule{Metric: MetricPullCount, Op: ">", Threshold: 100})
+
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, step := range []struct {
+		pulls int64
+		fires bool
+	}{
+		{50, false},
+		{150, true},
+		{160, false}, // still holds
+		{90, false},
+		{200, true}, // held again after dropping
+	} {
+		ts := start.Add(time.Duration(i) * 15 * time.Minute)
+		addSnapshot(t, dbx, repoID, ts, step.pulls)
+		if got := evaluate(t, dbx, repoID, ts); (len(got) == 1) != step.fires {
+			t.Errorf("step %d (%d pulls): fired %v, want fired=%t", i, step.pulls, got, step.fires)
+		}
+	}
+
+	firings, err := QueryAlertFirings(dbx, 0, ListOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(firings) != 2 || firings[0].Value != 200 || firings[1].Value != 150 {
+		t.Errorf("firings = %+v, want 200 and 150", firings)
+	}
+}
+
+func TestAlertCooldown(t *testing.T) {
+	dbx := openTestDB(t)
+	repoID, err := EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newAlertRule(t, dbx, AlertRule{Metric: MetricPullCount, Op: ">", Threshold: 100, CooldownSeconds: 7200})
+
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, step := range []struct {
+		after time.Duration
+		pulls int64
+		fires bool
+	}{
+		{0, 150, true},
+		{30 * time.Minute, 90, false},
+		{time.Hour, 150, false}, // within the 2h cooldown
+		{90 * time.Minute, 90, false},
+		{3 * time.Hour, 150, true},
+	} {
+		ts := start.Add(step.after)
+		addSnapshot(t, dbx, repoID, ts, step.pulls)
+		if got := evaluate(t, dbx, repoID, ts); (len(got) == 1) != step.fires {
+			t.Errorf("after %s (%d pulls): fired %v, want fired=%t", step.after, step.pulls, got, step.fires)
+		}
+	}
+}
+
+func TestUpsertAlertRuleResetsStateOnConditionChange(t *testing.T) {
+	dbx := openTestDB(t)
+	repoID, err := EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newAlertRule(t, dbx, AlertRule{Metric: MetricPullCount, Op: ">", Threshold: 100})
+
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	addSnapshot(t, dbx, repoID, start, 150)
+	if got := evaluate(t, dbx, repoID, start); len(got) != 1 {
+		t.Fatalf("fired %v, want one firing", got)
+	}
+
+	// A new name keeps the state: the condition still holds, no new firing.
+	a.Name = "renamed"
+	if _, err := UpsertAlertRule(dbx, a); err != nil {
+		t.Fatal(err)
+	}
+	ts := start.Add(15 * time.Minute)
+	addSnapshot(t, dbx, repoID, ts, 160)
+	if got := evaluate(t, dbx, repoID, ts); len(got) != 0 {
+		t.Errorf("fired %v after a rename, want nothing", got)
+	}
+	if r, err := GetAlertRule(dbx, a.ID); err != nil || r.Firing != 1 {
+		t.Errorf("rule = %+v, %v; want it firing for one repo", r, err)
+	}
+
+	// A new threshold resets it, so the condition that already holds fires.
+	a.Threshold = 120
+	if _, err := UpsertAlertRule(dbx, a); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := GetAlertRule(dbx, a.ID); err != nil || r.Firing != 0 {
+		t.Errorf("rule = %+v, %v; want its state cleared", r, err)
+	}
+	ts = start.Add(30 * time.Minute)
+	addSnapshot(t, dbx, repoID, ts, 170)
+	if got := evaluate(t, dbx, repoID, ts); len(got) != 1 {
+		t.Errorf("fired %v after a threshold change, want one firing", got)
+	}
+}
+
+func TestAlertValueWindows(t *testing.T) {
+	dbx := openTestDB(t)
+	repoID, err := EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One snapshot every 15 minutes, 10 pulls apart.
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 6 {
+		addSnapshot(t, dbx, repoID, start.Add(time.Duration(i)*15*time.Minute), 100+int64(i)*10)
+	}
+	cur := RepoSnapshot{TSUTC: start.Add(75 * time.Minute).Format(time.RFC3339), PullCount: 150}
+	ts := start.Add(75 * time.Minute)
+
+	for _, tc := range []struct {
+		rule AlertRule
+		want float64
+		ok   bool
+	}{
+		{AlertRule{Metric: MetricPullCount}, 150, true},
+		{AlertRule{Metric: MetricDelta}, 10, true},   // previous snapshot
+		{AlertRule{Metric: MetricPerHour}, 40, true}, // 10 in 15 minutes
+		{AlertRule{Metric: MetricDelta, WindowSeconds: 3600}, 40, true},
+		{AlertRule{Metric: MetricPerHour, WindowSeconds: 3600}, 40, true},
+		{AlertRule{Metric: MetricDelta, WindowSeconds: 4 * 3600}, 0, false}, // no snapshot that old
+	} {
+		tx, err := dbx.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok, err := alertValue(tx, tc.rule, repoID, cur, ts)
+		tx.Rollback()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("%s = %v, %t; want %v, %t", tc.rule.Condition(), got, ok, tc.want, tc.ok)
+		}
+	}
+}
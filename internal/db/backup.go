@@ -0,0 +1,51 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// BackupTo writes a consistent copy of the database to path with VACUUM
+// INTO while it stays online; writers wait until the copy is done. path
+// must not exist or be an empty file.
+func BackupTo(dbx *sql.DB, path string) error {
+	_, err := dbx.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+// CheckBackup verifies that the file at path is an intact pullpulse
+// database this binary can open, without changing it, and returns its
+// schema version. Older versions are fine; they are migrated on startup.
+func CheckBackup(path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+	dbx, err := sql.Open("sqlite3", "file:"+url.PathEscape(path)+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer dbx.Close()
+
+	var res string
+	if err := dbx.QueryRow(`PRAGMA integrity_check(1)`).Scan(&res); err != nil {
+		return 0, fmt.Errorf("not a readable SQLite database: %w", err)
+	}
+	if res != "ok" {
+		return 0, fmt.Errorf("integrity check failed: %s", res)
+	}
+
+	st, err := GetMigrationStatus(dbx)
+	if err != nil {
+		return 0, err
+	}
+	if st.Current == 0 {
+		return 0, errors.New("not a pullpulse database (no schema version)")
+	}
+	if st.Current > st.Latest {
+		return st.Current, fmt.Errorf("%w: backup is at version %d, this binary supports up to %d", ErrSchemaTooNew, st.Current, st.Latest)
+	}
+	return st.Current, nil
+}
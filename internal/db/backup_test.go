@@ -0,0 +1,68 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckBackup(t *testing.T) {
+	dbx := openTestDB(t)
+	st, err := GetMigrationStatus(dbx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	good := filepath.Join(dir, "good.sqlite")
+	if err := BackupTo(dbx, good); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := CheckBackup(good); err != nil || v != st.Latest {
+		t.Errorf("CheckBackup(good) = %d, %v; want version %d", v, err, st.Latest)
+	}
+
+	newer := filepath.Join(dir, "newer.sqlite")
+	if err := BackupTo(dbx, newer); err != nil {
+		t.Fatal(err)
+	}
+	execFile(t, newer, `INSERT INTO schema_migrations(version, name, applied_ts_utc) VALUES(?, 'from the future', ?)`,
+		st.Latest+1, time.Now().UTC().Format(time.RFC3339))
+	if _, err := CheckBackup(newer); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("CheckBackup(newer) = %v, want ErrSchemaTooNew", err)
+	}
+
+	other := filepath.Join(dir, "other.sqlite")
+	execFile(t, other, `CREATE TABLE notes (body TEXT)`)
+	if _, err := CheckBackup(other); err == nil {
+		t.Error("CheckBackup accepted a database without a pullpulse schema")
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.sqlite")
+	if err := os.WriteFile(corrupt, []byte("definitely not SQLite, just some text that is long enough"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckBackup(corrupt); err == nil {
+		t.Error("CheckBackup accepted a corrupt file")
+	}
+
+	if _, err := CheckBackup(filepath.Join(dir, "missing.sqlite")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("CheckBackup(missing) = %v, want os.ErrNotExist", err)
+	}
+}
+
+// execFile runs one statement against the SQLite file at path.
+func execFile(t *testing.T, path, query string, args ...any) {
+	t.Helper()
+	dbx, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbx.Close()
+	if _, err := dbx.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
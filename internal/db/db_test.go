@@ -0,0 +1,60 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestDB returns a migrated database in a temp dir, closed when the
+// test ends.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dbx, err := Open(filepath.Join(t.TempDir(), "pulls.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbx.Close() })
+	if err := Migrate(dbx); err != nil {
+		t.Fatal(err)
+	}
+	return dbx
+}
+
+// addSnapshot stores a snapshot of repoID at ts with its delta and rollups.
+func addSnapshot(t *testing.T, dbx *sql.DB, repoID int64, ts time.Time, pullCount int64) {
+	t.Helper()
+	if _, err := InsertSnapshotAndDelta(dbx, repoID, ts, pullCount, 0, "", false, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deltaChain lists a repo's deltas oldest first as "to_ts_utc +delta".
+func deltaChain(t *testing.T, dbx *sql.DB, repoID int64) []string {
+	t.Helper()
+	deltas, err := QueryRepoDeltas(dbx, repoID, ListOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]string, len(deltas))
+	for i, d := range deltas {
+		out[len(deltas)-1-i] = fmt.Sprintf("%s %+d", d.ToTSUTC, d.Delta)
+	}
+	return out
+}
+
+// allRollups returns a repo's hourly, daily and weekly rollups.
+func allRollups(t *testing.T, dbx *sql.DB, repoID int64) map[string][]RepoRollup {
+	t.Helper()
+	out := map[string][]RepoRollup{}
+	for _, p := range rollupPeriods {
+		r, err := QueryRepoRollups(dbx, repoID, p, ListOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[p] = r
+	}
+	return out
+}
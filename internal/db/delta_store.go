@@ -0,0 +1,171 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// deltaRow is a full repo_deltas row as derived from two snapshots.
+type deltaRow struct {
+	FromTSUTC string
+	ToTSUTC   string
+	FromPull  int64
+	ToPull    int64
+	Delta     int64
+	Seconds   int64
+	PerHour   float64
+}
+
+// computeDelta derives the delta between two snapshots. ok is false when the
+// timestamps can't be parsed or aren't increasing.
+func computeDelta(fromTs string, fromPull int64, toTs string, toPull int64) (deltaRow, bool) {
+	fromT, err := time.Parse(time.RFC3339, fromTs)
+	if err != nil {
+		return deltaRow{}, false
+	}
+	toT, err := time.Parse(time.RFC3339, toTs)
+	if err != nil {
+		return deltaRow{}, false
+	}
+	sec := int64(toT.Sub(fromT).Seconds())
+	if sec <= 0 {
+		return deltaRow{}, false
+	}
+
+	delta := toPull - fromPull
+	return deltaRow{
+		FromTSUTC: fromTs,
+		ToTSUTC:   toTs,
+		FromPull:  fromPull,
+		ToPull:    toPull,
+		Delta:     delta,
+		Seconds:   sec,
+		PerHour:   float64(delta) / (float64(sec) / 3600.0),
+	}, true
+}
+
+func insertDelta(tx *sql.Tx, repoID int64, d deltaRow) error {
+	_, err := tx.Exec(`INSERT OR IGNORE INTO repo_deltas(repo_id, from_ts_utc, to_ts_utc, from_pull_count, to_pull_count, delta, seconds, per_hour)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+		repoID, d.FromTSUTC, d.ToTSUTC, d.FromPull, d.ToPull, d.Delta, d.Seconds, d.PerHour)
+	return err
+}
+
+// DeltaRepair summarizes a RepairDeltas run.
+type DeltaRepair struct {
+	Repos   int // repos checked
+	Added   int // missing deltas inserted
+	Removed int // deltas that didn't match consecutive snapshots
+}
+
+// RepairDeltas makes repo_deltas match repo_snapshots again: for every repo,
+// each pair of consecutive snapshots gets exactly one delta. Missing deltas
+// (e.g. after a crash between the two inserts of older versions) are added,
+// deltas between non-consecutive snapshots are removed.
+func RepairDeltas(dbx *sql.DB) (DeltaRepair, error) {
+	var rep DeltaRepair
+	repos, err := ListKnownRepos(dbx)
+	if err != nil {
+		return rep, err
+	}
+	for _, r := range repos {
+		tx, err := dbx.Begin()
+		if err != nil {
+			return rep, err
+		}
+		added, removed, err := syncRepoDeltas(tx, r.ID, "")
+		if err != nil {
+			tx.Rollback()
+			return rep, err
+		}
+		if err := tx.Commit(); err != nil {
+			return rep, err
+		}
+		rep.Repos++
+		rep.Added += added
+		rep.Removed += removed
+	}
+	return rep, nil
+}
+
+// syncRepoDeltas recomputes the deltas of one repo whose to_ts_utc is at or
+// after since ("" = all) from the snapshots and fixes differences.
+func syncRepoDeltas(tx *sql.Tx, repoID int64, since string) (added, removed int, err error) {
+	type snap struct {
+		ts   string
+		pull int64
+	}
+
+	// Start one snapshot before since so the first delta in range is covered.
+	q := `SELECT ts_utc, pull_count FROM repo_snapshots WHERE repo_id=?`
+	args := []any{repoID}
+	if since != "" {
+		q += ` AND ts_utc >= COALESCE((SELECT MAX(ts_utc) FROM repo_snapshots WHERE repo_id=? AND ts_utc < ?), ?)`
+		args = append(args, repoID, since, since)
+	}
+	rows, err := tx.Query(q+` ORDER BY ts_utc`, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	var snaps []snap
+	for rows.Next() {
+		var s snap
+		if err := rows.Scan(&s.ts, &s.pull); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		snaps = append(snaps, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	want := map[[2]string]deltaRow{}
+	for i := 1; i < len(snaps); i++ {
+		if d, ok := computeDelta(snaps[i-1].ts, snaps[i-1].pull, snaps[i].ts, snaps[i].pull); ok {
+			want[[2]string{d.FromTSUTC, d.ToTSUTC}] = d
+		}
+	}
+
+	q = `SELECT id, from_ts_utc, to_ts_utc, from_pull_count, to_pull_count FROM repo_deltas WHERE repo_id=?`
+	args = []any{repoID}
+	if since != "" {
+		q += ` AND to_ts_utc >= ?`
+		args = append(args, since)
+	}
+	rows, err = tx.Query(q, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	var stale []int64
+	for rows.Next() {
+		var id, fromPull, toPull int64
+		var key [2]string
+		if err := rows.Scan(&id, &key[0], &key[1], &fromPull, &toPull); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		if d, ok := want[key]; ok && d.FromPull == fromPull && d.ToPull == toPull {
+			delete(want, key)
+			continue
+		}
+		stale = append(stale, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, id := range stale {
+		if _, err := tx.Exec(`DELETE FROM repo_deltas WHERE id=?`, id); err != nil {
+			return 0, 0, err
+		}
+	}
+	for _, d := range want {
+		if err := insertDelta(tx, repoID, d); err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(want), len(stale), nil
+}
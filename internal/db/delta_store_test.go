@@ -0,0 +1,131 @@
+package db
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestInsertSnapshotAndDeltaIsAtomic(t *testing.T) {
+	dbx := openTestDB(t)
+	repoID, err := EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addSnapshot(t, dbx, repoID, t0, 100)
+	before := allRollups(t, dbx, repoID)
+
+	if _, err := dbx.Exec(`CREATE TRIGGER fail_delta BEFORE INSERT ON repo_deltas BEGIN SELECT RAISE(ABORT, 'boom'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InsertSnapshotAndDelta(dbx, repoID, t0.Add(time.Hour), 150, 0, "", false, ""); err == nil {
+		t.Fatal("insert succeeded although the delta failed")
+	}
+
+	var n int
+	if err := dbx.QueryRow(`SELECT COUNT(*) FROM repo_snapshots WHERE repo_id=?`, repoID).Scan(&n); err != nil || n != 1 {
+		t.Errorf("snapshots = %d, %v; want the failed one rolled back", n, err)
+	}
+	if after := allRollups(t, dbx, repoID); !equalRollups(before, after) {
+		t.Errorf("rollups changed by a failed insert:\nbefore %+v\nafter  %+v", before, after)
+	}
+
+	if _, err := dbx.Exec(`DROP TRIGGER fail_delta`); err != nil {
+		t.Fatal(err)
+	}
+	addSnapshot(t, dbx, repoID, t0.Add(time.Hour), 150)
+	if got, want := deltaChain(t, dbx, repoID), []string{"2025-01-01T01:00:00Z +50"}; !slices.Equal(got, want) {
+		t.Errorf("deltas = %v, want %v", got, want)
+	}
+}
+
+func TestBackfilledSnapshotSplitsTheDelta(t *testing.T) {
+	dbx := openTestDB(t)
+	repoID, err := EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addSnapshot(t, dbx, repoID, t0, 100)
+	addSnapshot(t, dbx, repoID, t0.Add(2*time.Hour), 300)
+
+	res, err := InsertSnapshotAndDelta(dbx, repoID, t0.Add(time.Hour), 120, 0, "", false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Inserted || res.Prev == nil || res.Prev.TSUTC != "2025-01-01T00:00:00Z" {
+		t.Errorf("result = %+v, want inserted after the first snapshot", res)
+	}
+	want := []string{"2025-01-01T01:00:00Z +20", "2025-01-01T02:00:00Z +180"}
+	if got := deltaChain(t, dbx, repoID); !slices.Equal(got, want) {
+		t.Errorf("deltas = %v, want %v", got, want)
+	}
+
+	// The same timestamp again is ignored.
+	res, err = InsertSnapshotAndDelta(dbx, repoID, t0.Add(time.Hour), 999, 0, "", false, "")
+	if err != nil || res.Inserted {
+		t.Errorf("duplicate: %+v, %v; want not inserted", res, err)
+	}
+	if got := deltaChain(t, dbx, repoID); !slices.Equal(got, want) {
+		t.Errorf("deltas after duplicate = %v, want %v", got, want)
+	}
+}
+
+func TestRepairDeltas(t *testing.T) {
+	dbx := openTestDB(t)
+	a, err := EnsureRepo(dbx, "ns", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := EnsureRepo(dbx, "ns", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, pulls := range []int64{100, 110, 130, 160} {
+		addSnapshot(t, dbx, a, t0.Add(time.Duration(i)*time.Hour), pulls)
+		addSnapshot(t, dbx, b, t0.Add(time.Duration(i)*time.Hour), 2*pulls)
+	}
+	wantA, wantB := deltaChain(t, dbx, a), deltaChain(t, dbx, b)
+
+	// Break repo a's chain: one delta missing, one spanning two snapshots
+	// and one with a stale pull count.
+	for _, q := range []string{
+		`DELETE FROM repo_deltas WHERE repo_id=? AND to_ts_utc='2025-01-01T01:00:00Z'`,
+		`INSERT INTO repo_deltas(repo_id, from_ts_utc, to_ts_utc, from_pull_count, to_pull_count, delta, seconds, per_hour)
+			VALUES(?, '2025-01-01T00:00:00Z', '2025-01-01T02:00:00Z', 100, 130, 30, 7200, 15)`,
+		`UPDATE repo_deltas SET to_pull_count=170, delta=40 WHERE repo_id=? AND to_ts_utc='2025-01-01T03:00:00Z'`,
+	} {
+		if _, err := dbx.Exec(q, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rep, err := RepairDeltas(dbx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep != (DeltaRepair{Repos: 2, Added: 2, Removed: 2}) {
+		t.Errorf("repair = %+v, want 2 repos, 2 added, 2 removed", rep)
+	}
+	if got := deltaChain(t, dbx, a); !slices.Equal(got, wantA) {
+		t.Errorf("repo a deltas = %v, want %v", got, wantA)
+	}
+	if got := deltaChain(t, dbx, b); !slices.Equal(got, wantB) {
+		t.Errorf("repo b deltas = %v, want %v", got, wantB)
+	}
+
+	if rep, err := RepairDeltas(dbx); err != nil || rep.Added != 0 || rep.Removed != 0 {
+		t.Errorf("second repair = %+v, %v; want nothing to fix", rep, err)
+	}
+}
+
+// equalRollups compares two allRollups results.
+func equalRollups(a, b map[string][]RepoRollup) bool {
+	for _, p := range rollupPeriods {
+		if !slices.Equal(a[p], b[p]) {
+			return false
+		}
+	}
+	return true
+}
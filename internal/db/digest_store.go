@@ -0,0 +1,131 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Digest summarizes one closed day or week.
+type Digest struct {
+	Period   string         `json:"period"` // PeriodDay|PeriodWeek
+	StartUTC string         `json:"start_utc"`
+	EndUTC   string         `json:"end_utc"`
+	Pulls    int64          `json:"pulls"` // gained by all repos
+	Repos    []DigestRepo   `json:"repos"` // by pulls gained, descending
+	Failing  []DigestTarget `json:"failing_targets"`
+}
+
+type DigestRepo struct {
+	RepoID    int64  `json:"repo_id"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Pulls     int64  `json:"pulls"`      // gained in the period
+	PrevPulls int64  `json:"prev_pulls"` // gained in the period before
+	PullCount int64  `json:"pull_count"` // at the end of the period
+	Stars     int64  `json:"stars"`      // gained in the period
+}
+
+type DigestTarget struct {
+	TargetID   int64  `json:"target_id"`
+	Name       string `json:"name"`
+	Runs       int    `json:"runs"`
+	FailedRuns int    `json:"failed_runs"` // run error or failed repos
+	LastError  string `json:"last_error,omitempty"`
+}
+
+// PreviousBucket returns the start of the last bucket of period that ended
+// at or before t.
+func PreviousBucket(period string, t time.Time) time.Time {
+	return BucketStart(period, BucketStart(period, t).Add(-time.Second))
+}
+
+func bucketEnd(period string, start time.Time) time.Time {
+	if period == PeriodWeek {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// BuildDigest summarizes the day or week starting at start from the rollups
+// and the target run history.
+func BuildDigest(dbx *sql.DB, period string, start time.Time) (Digest, error) {
+	if period != PeriodDay && period != PeriodWeek {
+		return Digest{}, fmt.Errorf("digest period must be %q or %q", PeriodDay, PeriodWeek)
+	}
+	start = BucketStart(period, start)
+	end := bucketEnd(period, start)
+	prev := PreviousBucket(period, start)
+	d := Digest{
+		Period:   period,
+		StartUTC: start.Format(time.RFC3339),
+		EndUTC:   end.Format(time.RFC3339),
+	}
+
+	rows, err := dbx.Query(`SELECT r.id, r.namespace, r.name, cur.delta, COALESCE(p.delta,0), cur.last_pull_count, cur.star_delta
+		FROM `+rollupTables[period]+` cur
+		JOIN repos r ON r.id = cur.repo_id
+		LEFT JOIN `+rollupTables[period]+` p ON p.repo_id = cur.repo_id AND p.bucket_start_utc = ?
+		WHERE cur.bucket_start_utc = ?`,
+		prev.Format(time.RFC3339), d.StartUTC)
+	if err != nil {
+		return d, err
+	}
+	for rows.Next() {
+		var r DigestRepo
+		if err := rows.Scan(&r.RepoID, &r.Namespace, &r.Name, &r.Pulls, &r.PrevPulls, &r.PullCount, &r.Stars); err != nil {
+			rows.Close()
+			return d, err
+		}
+		d.Repos = append(d.Repos, r)
+		d.Pulls += r.Pulls
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return d, err
+	}
+	sort.SliceStable(d.Repos, func(i, j int) bool {
+		if d.Repos[i].Pulls != d.Repos[j].Pulls {
+			return d.Repos[i].Pulls > d.Repos[j].Pulls
+		}
+		return d.Repos[i].Namespace+"/"+d.Repos[i].Name < d.Repos[j].Namespace+"/"+d.Repos[j].Name
+	})
+
+	rows, err = dbx.Query(`SELECT t.id, t.name, COUNT(*),
+			SUM(CASE WHEN r.error IS NOT NULL OR r.repos_failed > 0 THEN 1 ELSE 0 END),
+			COALESCE((SELECT COALESCE(l.error, l.repos_failed || ' repos failed') FROM target_runs l
+				WHERE l.target_id = t.id AND l.started_ts_utc >= ? AND l.started_ts_utc < ?
+				AND (l.error IS NOT NULL OR l.repos_failed > 0) ORDER BY l.id DESC LIMIT 1), '')
+		FROM target_runs r JOIN targets t ON t.id = r.target_id
+		WHERE r.started_ts_utc >= ? AND r.started_ts_utc < ?
+		GROUP BY t.id HAVING SUM(CASE WHEN r.error IS NOT NULL OR r.repos_failed > 0 THEN 1 ELSE 0 END) > 0
+		ORDER BY t.name`,
+		d.StartUTC, d.EndUTC, d.StartUTC, d.EndUTC)
+	if err != nil {
+		return d, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t DigestTarget
+		if err := rows.Scan(&t.TargetID, &t.Name, &t.Runs, &t.FailedRuns, &t.LastError); err != nil {
+			return d, err
+		}
+		d.Failing = append(d.Failing, t)
+	}
+	return d, rows.Err()
+}
+
+// DigestSent reports whether the digest for the bucket was already mailed.
+func DigestSent(dbx *sql.DB, period string, start time.Time) (bool, error) {
+	var n int
+	err := dbx.QueryRow(`SELECT COUNT(*) FROM mail_digests WHERE period=? AND bucket_start_utc=?`,
+		period, start.UTC().Format(time.RFC3339)).Scan(&n)
+	return n > 0, err
+}
+
+func MarkDigestSent(dbx *sql.DB, period string, start, now time.Time) error {
+	_, err := dbx.Exec(`INSERT OR REPLACE INTO mail_digests(period, bucket_start_utc, sent_ts_utc) VALUES(?, ?, ?)`,
+		period, start.UTC().Format(time.RFC3339), now.UTC().Format(time.RFC3339))
+	return err
+}
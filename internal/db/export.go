@@ -0,0 +1,205 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Export datasets and the raw resolution.
+const (
+	DatasetSnapshots = "snapshots"
+	DatasetDeltas    = "deltas"
+	ResolutionRaw    = "raw"
+)
+
+// ExportQuery selects what to export. RepoID, then Namespace, narrow the
+// repos (neither = all). A Resolution other than ResolutionRaw exports the
+// rollup buckets of that period instead of individual rows; From/To then
+// filter on the bucket start.
+type ExportQuery struct {
+	Dataset    string
+	RepoID     int64
+	Namespace  string
+	From       time.Time
+	To         time.Time
+	Resolution string
+}
+
+type exportCol struct {
+	name string
+	expr string
+}
+
+// exportSpec describes how one dataset/resolution is read: its columns, the
+// time column filtered on and the unique key the export pages by.
+type exportSpec struct {
+	table string
+	ts    string
+	key   []string
+	cols  []exportCol
+}
+
+func (q ExportQuery) spec() (exportSpec, error) {
+	if q.Dataset != DatasetSnapshots && q.Dataset != DatasetDeltas {
+		return exportSpec{}, fmt.Errorf("dataset must be %q or %q", DatasetSnapshots, DatasetDeltas)
+	}
+	res := q.Resolution
+	if res == "" {
+		res = ResolutionRaw
+	}
+	switch {
+	case q.Dataset == DatasetSnapshots && res == ResolutionRaw:
+		return exportSpec{
+			table: "repo_snapshots",
+			ts:    "t.ts_utc",
+			key:   []string{"t.repo_id", "t.ts_utc"},
+			cols: []exportCol{
+				{"ts_utc", "t.ts_utc"},
+				{"pull_count", "t.pull_count"},
+				{"star_count", "t.star_count"},
+				{"last_updated", "t.last_updated"},
+				{"is_private", "t.is_private"},
+			},
+		}, nil
+	case q.Dataset == DatasetDeltas && res == ResolutionRaw:
+		return exportSpec{
+			table: "repo_deltas",
+			ts:    "t.to_ts_utc",
+			key:   []string{"t.repo_id", "t.to_ts_utc", "t.from_ts_utc"},
+			cols: []exportCol{
+				{"from_ts_utc", "t.from_ts_utc"},
+				{"to_ts_utc", "t.to_ts_utc"},
+				{"from_pull_count", "t.from_pull_count"},
+				{"to_pull_count", "t.to_pull_count"},
+				{"delta", "t.delta"},
+				{"seconds", "t.seconds"},
+				{"per_hour", "t.per_hour"},
+			},
+		}, nil
+	}
+
+	table, ok := rollupTables[res]
+	if !ok {
+		return exportSpec{}, fmt.Errorf("resolution must be %q, %q, %q or %q", ResolutionRaw, PeriodHour, PeriodDay, PeriodWeek)
+	}
+	s := exportSpec{
+		table: table,
+		ts:    "t.bucket_start_utc",
+		key:   []string{"t.repo_id", "t.bucket_start_utc"},
+	}
+	if q.Dataset == DatasetSnapshots {
+		// The last snapshot of each bucket.
+		s.cols = []exportCol{
+			{"bucket_start_utc", "t.bucket_start_utc"},
+			{"ts_utc", "t.last_ts_utc"},
+			{"pull_count", "t.last_pull_count"},
+			{"star_count", "t.last_star_count"},
+			{"samples", "t.samples"},
+		}
+	} else {
+		s.cols = []exportCol{
+			{"bucket_start_utc", "t.bucket_start_utc"},
+			{"first_ts_utc", "t.first_ts_utc"},
+			{"last_ts_utc", "t.last_ts_utc"},
+			{"delta", "t.delta"},
+			{"seconds", "t.seconds"},
+			{"per_hour", "t.per_hour"},
+			{"star_delta", "t.star_delta"},
+		}
+	}
+	return s, nil
+}
+
+// Validate reports an unknown dataset or resolution.
+func (q ExportQuery) Validate() error {
+	_, err := q.spec()
+	return err
+}
+
+// exportChunk is how many rows are read per query. Rows are read in chunks
+// so a slow consumer never holds the only DB connection for long.
+const exportChunk = 5000
+
+// Export calls fn with the column names and then once per row, ordered by
+// repo and time. Values are int64, float64, string or nil.
+func Export(dbx *sql.DB, q ExportQuery, header func(cols []string) error, fn func(row []any) error) (int, error) {
+	s, err := q.spec()
+	if err != nil {
+		return 0, err
+	}
+
+	names := []string{"repo_id", "namespace", "repo"}
+	exprs := []string{"t.repo_id", "r.namespace", "r.name"}
+	for _, c := range s.cols {
+		names = append(names, c.name)
+		exprs = append(exprs, c.expr)
+	}
+	if err := header(names); err != nil {
+		return 0, err
+	}
+
+	base := `SELECT ` + strings.Join(exprs, ", ") + `, ` + strings.Join(s.key, ", ") +
+		` FROM ` + s.table + ` t JOIN repos r ON r.id = t.repo_id WHERE 1=1`
+	var baseArgs []any
+	switch {
+	case q.RepoID != 0:
+		base += ` AND t.repo_id = ?`
+		baseArgs = append(baseArgs, q.RepoID)
+	case q.Namespace != "":
+		base += ` AND r.namespace = ?`
+		baseArgs = append(baseArgs, q.Namespace)
+	}
+	base, baseArgs = appendRange(base, baseArgs, s.ts, ListOpts{From: q.From, To: q.To})
+	order := ` ORDER BY ` + strings.Join(s.key, ", ") + ` LIMIT ?`
+
+	n := 0
+	var after []any // key of the last row read
+	for {
+		sqlq, args := base, append([]any{}, baseArgs...)
+		if after != nil {
+			marks := strings.TrimSuffix(strings.Repeat("?, ", len(after)), ", ")
+			sqlq += ` AND (` + strings.Join(s.key, ", ") + `) > (` + marks + `)`
+			args = append(args, after...)
+		}
+		rows, err := dbx.Query(sqlq+order, append(args, exportChunk)...)
+		if err != nil {
+			return n, err
+		}
+
+		var chunk [][]any
+		for rows.Next() {
+			vals := make([]any, len(names)+len(s.key))
+			ptrs := make([]any, len(vals))
+			for i := range vals {
+				ptrs[i] = &vals[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				rows.Close()
+				return n, err
+			}
+			for i, v := range vals {
+				if b, ok := v.([]byte); ok {
+					vals[i] = string(b)
+				}
+			}
+			chunk = append(chunk, vals)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return n, err
+		}
+
+		for _, vals := range chunk {
+			if err := fn(vals[:len(names)]); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if len(chunk) < exportChunk {
+			return n, nil
+		}
+		after = chunk[len(chunk)-1][len(names):]
+	}
+}
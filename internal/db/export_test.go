@@ -0,0 +1,89 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExportPagesAcrossChunks(t *testing.T) {
+	dbx := openTestDB(t)
+	// Two repos with snapshots at the same timestamps, so the chunk
+	// boundary falls inside the second repo and timestamps repeat.
+	const perRepo = exportChunk/2 + 500
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tx, err := dbx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		res, err := tx.Exec(`INSERT INTO repos(namespace, name) VALUES('ns', ?)`, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		for i := range perRepo {
+			ts := start.Add(time.Duration(i) * time.Minute).Format(time.RFC3339)
+			if _, err := tx.Exec(`INSERT INTO repo_snapshots(repo_id, ts_utc, pull_count) VALUES(?, ?, ?)`, id, ts, i); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		q    ExportQuery
+		want int
+	}{
+		{ExportQuery{Dataset: DatasetSnapshots}, 2 * perRepo},
+		{ExportQuery{Dataset: DatasetSnapshots, Namespace: "ns", From: start.Add(time.Minute)}, 2 * (perRepo - 1)},
+	} {
+		type key struct {
+			repo int64
+			ts   string
+		}
+		seen := map[key]bool{}
+		var last key
+		var cols []string
+		n, err := Export(dbx, tc.q, func(c []string) error {
+			cols = c
+			return nil
+		}, func(row []any) error {
+			k := key{row[0].(int64), row[3].(string)}
+			if seen[k] {
+				t.Fatalf("row %v exported twice", k)
+			}
+			if k.repo < last.repo || k.repo == last.repo && k.ts <= last.ts {
+				t.Fatalf("row %v after %v, want repo and time order", k, last)
+			}
+			seen[k], last = true, k
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != tc.want || len(seen) != tc.want {
+			t.Errorf("%+v: exported %d rows (%d distinct), want %d", tc.q, n, len(seen), tc.want)
+		}
+		if len(cols) != 8 || cols[3] != "ts_utc" {
+			t.Errorf("columns = %v", cols)
+		}
+	}
+}
+
+func TestExportQueryValidate(t *testing.T) {
+	for _, q := range []ExportQuery{
+		{Dataset: "pulls"},
+		{Dataset: DatasetDeltas, Resolution: "month"},
+	} {
+		if q.Validate() == nil {
+			t.Errorf("%+v is valid, want an error", q)
+		}
+	}
+	for _, res := range []string{"", ResolutionRaw, PeriodHour, PeriodDay, PeriodWeek} {
+		if err := (ExportQuery{Dataset: DatasetDeltas, Resolution: res}).Validate(); err != nil {
+			t.Errorf("resolution %q: %v", res, err)
+		}
+	}
+}
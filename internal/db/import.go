@@ -0,0 +1,109 @@
+package db
+
+import (
+	"cmp"
+	"database/sql"
+	"slices"
+	"time"
+)
+
+// SnapshotImport is one historical snapshot to import. Line is where it
+// came from, for reporting.
+type SnapshotImport struct {
+	Line      int
+	Namespace string
+	Repo      string
+	TS        time.Time
+	PullCount int64
+	StarCount *int64 // nil = unknown
+}
+
+// ImportResult summarizes an ImportSnapshots run.
+type ImportResult struct {
+	Repos    int   // repos the rows belong to
+	NewRepos int   // of which were created
+	Inserted int   // snapshots stored
+	Existing []int // lines skipped because their timestamp is already stored
+}
+
+// ImportSnapshots stores historical snapshots, one transaction for all
+// rows. Each repo is handled like a back-filled InsertSnapshotAndDelta, but
+// once per repo instead of once per row: the snapshots are inserted, then the
+// deltas and rollups from the oldest new snapshot on are recomputed in
+// timestamp order. With dryRun the transaction is rolled back, so the result
+// only reports what would happen.
+func ImportSnapshots(dbx *sql.DB, rows []SnapshotImport, dryRun bool) (ImportResult, error) {
+	var res ImportResult
+	rows = slices.Clone(rows)
+	slices.SortStableFunc(rows, func(a, b SnapshotImport) int {
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Repo, b.Repo), a.TS.Compare(b.TS))
+	})
+
+	tx, err := dbx.Begin()
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	for i := 0; i < len(rows); {
+		j := i
+		for j < len(rows) && rows[j].Namespace == rows[i].Namespace && rows[j].Repo == rows[i].Repo {
+			j++
+		}
+		if err := importRepo(tx, rows[i:j], &res); err != nil {
+			return res, err
+		}
+		i = j
+	}
+
+	if dryRun {
+		return res, nil
+	}
+	return res, tx.Commit()
+}
+
+// importRepo imports the rows of one repo, sorted by timestamp.
+func importRepo(tx *sql.Tx, rows []SnapshotImport, res *ImportResult) error {
+	ns, name := rows[0].Namespace, rows[0].Repo
+	created, err := tx.Exec(`INSERT OR IGNORE INTO repos(namespace, name) VALUES(?, ?)`, ns, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := created.RowsAffected(); n > 0 {
+		res.NewRepos++
+	}
+	var repoID int64
+	if err := tx.QueryRow(`SELECT id FROM repos WHERE namespace=? AND name=?`, ns, name).Scan(&repoID); err != nil {
+		return err
+	}
+	res.Repos++
+
+	var oldest time.Time
+	for _, r := range rows {
+		var star any
+		if r.StarCount != nil {
+			star = *r.StarCount
+		}
+		ins, err := tx.Exec(`INSERT OR IGNORE INTO repo_snapshots(repo_id, ts_utc, pull_count, star_count) VALUES(?, ?, ?, ?)`,
+			repoID, r.TS.UTC().Format(time.RFC3339), r.PullCount, star)
+		if err != nil {
+			return err
+		}
+		if n, _ := ins.RowsAffected(); n == 0 {
+			res.Existing = append(res.Existing, r.Line)
+			continue
+		}
+		if oldest.IsZero() {
+			oldest = r.TS
+		}
+		res.Inserted++
+	}
+	if oldest.IsZero() {
+		return nil
+	}
+
+	if _, _, err := syncRepoDeltas(tx, repoID, oldest.UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return rebuildRepoRollups(tx, repoID, oldest)
+}
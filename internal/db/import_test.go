@@ -0,0 +1,78 @@
+package db
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestImportSnapshotsBeforeExistingOnes(t *testing.T) {
+	dbx := openTestDB(t)
+	repoID, err := EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+	addSnapshot(t, dbx, repoID, day(10), 1000)
+	addSnapshot(t, dbx, repoID, day(11), 1100)
+
+	// Out of order, one before and one between the stored snapshots, one
+	// already stored, and a new repo.
+	rows := []SnapshotImport{
+		{Line: 1, Namespace: "ns", Repo: "r", TS: day(8), PullCount: 800},
+		{Line: 2, Namespace: "ns", Repo: "r", TS: day(5), PullCount: 500},
+		{Line: 3, Namespace: "ns", Repo: "r", TS: day(10), PullCount: 999},
+		{Line: 4, Namespace: "ns", Repo: "new", TS: day(1), PullCount: 1},
+	}
+	res, err := ImportSnapshots(dbx, rows, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Repos != 2 || res.NewRepos != 1 || res.Inserted != 3 || !slices.Equal(res.Existing, []int{3}) {
+		t.Errorf("result = %+v, want 2 repos (1 new), 3 inserted, line 3 existing", res)
+	}
+
+	want := []string{
+		"2025-01-08T00:00:00Z +300",
+		"2025-01-10T00:00:00Z +200",
+		"2025-01-11T00:00:00Z +100",
+	}
+	if got := deltaChain(t, dbx, repoID); !slices.Equal(got, want) {
+		t.Errorf("deltas = %v, want %v", got, want)
+	}
+
+	// The rollups match a rebuild from scratch.
+	got := allRollups(t, dbx, repoID)
+	if _, err := RebuildRollups(dbx); err != nil {
+		t.Fatal(err)
+	}
+	if want := allRollups(t, dbx, repoID); !reflect.DeepEqual(got, want) {
+		t.Errorf("rollups after import = %+v, want %+v", got, want)
+	}
+	if days := got[PeriodDay]; len(days) != 4 || days[0].FirstPullCount != 500 {
+		t.Errorf("daily rollups = %+v, want 4 days starting at 500 pulls", days)
+	}
+}
+
+func TestImportSnapshotsDryRun(t *testing.T) {
+	dbx := openTestDB(t)
+	rows := []SnapshotImport{
+		{Line: 1, Namespace: "ns", Repo: "r", TS: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), PullCount: 1},
+		{Line: 2, Namespace: "ns", Repo: "r", TS: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), PullCount: 2},
+	}
+	res, err := ImportSnapshots(dbx, rows, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Inserted != 2 || res.NewRepos != 1 {
+		t.Errorf("result = %+v, want 2 snapshots into 1 new repo", res)
+	}
+	repos, err := ListKnownRepos(dbx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 0 {
+		t.Errorf("dry run stored repos %+v", repos)
+	}
+}
@@ -0,0 +1,382 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSchemaTooNew means the database was migrated by a newer pullpulse.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// Migration is one numbered schema change. Its statements (and Backfill, if
+// set) run in a single transaction together with the schema_migrations
+// bookkeeping.
+type Migration struct {
+	Version  int
+	Name     string
+	Stmts    []string
+	Backfill func(tx *sql.Tx) error // derives data for the new schema
+}
+
+// migrations must only ever be appended to. Versions 1 and 2 predate
+// versioning and use IF NOT EXISTS so existing installs adopt them as-is.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Stmts: []string{
+			`CREATE TABLE IF NOT EXISTS targets (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				mode TEXT NOT NULL CHECK(mode IN ('user','repos')),
+				namespace TEXT NOT NULL,
+				repos_csv TEXT,
+				interval_seconds INTEGER NOT NULL,
+				enabled INTEGER NOT NULL DEFAULT 1,
+				last_run_ts_utc TEXT,
+				last_error TEXT
+			);`,
+
+			`CREATE TABLE IF NOT EXISTS repos (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				namespace TEXT NOT NULL,
+				name TEXT NOT NULL,
+				UNIQUE(namespace, name)
+			);`,
+
+			`CREATE TABLE IF NOT EXISTS repo_snapshots (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				repo_id INTEGER NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+				ts_utc TEXT NOT NULL,
+				pull_count INTEGER NOT NULL,
+				star_count INTEGER,
+				last_updated TEXT,
+				is_private INTEGER,
+				raw_json TEXT,
+				UNIQUE(repo_id, ts_utc)
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_repo_snapshots_repo_ts ON repo_snapshots(repo_id, ts_utc);`,
+
+			`CREATE TABLE IF NOT EXISTS repo_deltas (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				repo_id INTEGER NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+				from_ts_utc TEXT NOT NULL,
+				to_ts_utc TEXT NOT NULL,
+				from_pull_count INTEGER NOT NULL,
+				to_pull_count INTEGER NOT NULL,
+				delta INTEGER NOT NULL,
+				seconds INTEGER NOT NULL,
+				per_hour REAL NOT NULL,
+				UNIQUE(repo_id, from_ts_utc, to_ts_utc)
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_repo_deltas_repo_to ON repo_deltas(repo_id, to_ts_utc);`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "target run history",
+		Stmts: []string{
+			`CREATE TABLE IF NOT EXISTS target_runs (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				target_id INTEGER NOT NULL REFERENCES targets(id) ON DELETE CASCADE,
+				trigger TEXT NOT NULL,
+				started_ts_utc TEXT NOT NULL,
+				finished_ts_utc TEXT NOT NULL,
+				duration_ms INTEGER NOT NULL,
+				repos_ok INTEGER NOT NULL,
+				repos_failed INTEGER NOT NULL,
+				repos_skipped INTEGER NOT NULL,
+				error TEXT
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_target_runs_target ON target_runs(target_id, id);`,
+
+			`CREATE TABLE IF NOT EXISTS target_run_repos (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				run_id INTEGER NOT NULL REFERENCES target_runs(id) ON DELETE CASCADE,
+				repo TEXT NOT NULL,
+				status TEXT NOT NULL CHECK(status IN ('ok','failed','skipped')),
+				pull_count INTEGER,
+				error TEXT
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_target_run_repos_run ON target_run_repos(run_id);`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "repo rollups",
+		Stmts: []string{
+			`CREATE TABLE repo_rollups_hourly (
+				repo_id INTEGER NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+				bucket_start_utc TEXT NOT NULL,
+				first_ts_utc TEXT NOT NULL,
+				last_ts_utc TEXT NOT NULL,
+				first_pull_count INTEGER NOT NULL,
+				last_pull_count INTEGER NOT NULL,
+				delta INTEGER NOT NULL,
+				seconds INTEGER NOT NULL,
+				per_hour REAL NOT NULL,
+				first_star_count INTEGER NOT NULL,
+				last_star_count INTEGER NOT NULL,
+				star_delta INTEGER NOT NULL,
+				samples INTEGER NOT NULL,
+				PRIMARY KEY(repo_id, bucket_start_utc)
+			);`,
+
+			`CREATE TABLE repo_rollups_daily (
+				repo_id INTEGER NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+				bucket_start_utc TEXT NOT NULL,
+				first_ts_utc TEXT NOT NULL,
+				last_ts_utc TEXT NOT NULL,
+				first_pull_count INTEGER NOT NULL,
+				last_pull_count INTEGER NOT NULL,
+				delta INTEGER NOT NULL,
+				seconds INTEGER NOT NULL,
+				per_hour REAL NOT NULL,
+				first_star_count INTEGER NOT NULL,
+				last_star_count INTEGER NOT NULL,
+				star_delta INTEGER NOT NULL,
+				samples INTEGER NOT NULL,
+				PRIMARY KEY(repo_id, bucket_start_utc)
+			);`,
+
+			`CREATE TABLE repo_rollups_weekly (
+				repo_id INTEGER NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+				bucket_start_utc TEXT NOT NULL,
+				first_ts_utc TEXT NOT NULL,
+				last_ts_utc TEXT NOT NULL,
+				first_pull_count INTEGER NOT NULL,
+				last_pull_count INTEGER NOT NULL,
+				delta INTEGER NOT NULL,
+				seconds INTEGER NOT NULL,
+				per_hour REAL NOT NULL,
+				first_star_count INTEGER NOT NULL,
+				last_star_count INTEGER NOT NULL,
+				star_delta INTEGER NOT NULL,
+				samples INTEGER NOT NULL,
+				PRIMARY KEY(repo_id, bucket_start_utc)
+			);`,
+		},
+		Backfill: func(tx *sql.Tx) error {
+			_, err := rebuildRollups(tx, time.Time{})
+			return err
+		},
+	},
+	{
+		Version: 4,
+		Name:    "webhooks and gone repos",
+		Stmts: []string{
+			`ALTER TABLE repos ADD COLUMN gone_ts_utc TEXT;`,
+
+			`CREATE TABLE webhooks (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				url TEXT NOT NULL,
+				secret TEXT,
+				events_csv TEXT,
+				enabled INTEGER NOT NULL DEFAULT 1
+			);`,
+
+			`CREATE TABLE webhook_deliveries (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+				event_id TEXT NOT NULL,
+				event_type TEXT NOT NULL,
+				payload TEXT NOT NULL,
+				status TEXT NOT NULL CHECK(status IN ('pending','ok','failed')),
+				attempts INTEGER NOT NULL DEFAULT 0,
+				next_attempt_ts_utc TEXT,
+				response_code INTEGER,
+				error TEXT,
+				created_ts_utc TEXT NOT NULL,
+				finished_ts_utc TEXT
+			);`,
+			`CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_ts_utc);`,
+			`CREATE INDEX idx_webhook_deliveries_hook ON webhook_deliveries(webhook_id, id);`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "alert rules",
+		Stmts: []string{
+			`CREATE TABLE alert_rules (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				namespace TEXT NOT NULL,
+				repo TEXT,
+				metric TEXT NOT NULL CHECK(metric IN ('pull_count','per_hour','delta','star_count')),
+				op TEXT NOT NULL CHECK(op IN ('>','>=','<','<=','==','!=')),
+				threshold REAL NOT NULL,
+				window_seconds INTEGER NOT NULL DEFAULT 0,
+				cooldown_seconds INTEGER NOT NULL DEFAULT 0,
+				enabled INTEGER NOT NULL DEFAULT 1
+			);`,
+
+			`CREATE TABLE alert_state (
+				rule_id INTEGER NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+				repo_id INTEGER NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+				firing INTEGER NOT NULL,
+				value REAL NOT NULL,
+				evaluated_ts_utc TEXT NOT NULL,
+				last_fired_ts_utc TEXT,
+				PRIMARY KEY(rule_id, repo_id)
+			);`,
+
+			`CREATE TABLE alert_firings (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				rule_id INTEGER NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+				repo_id INTEGER NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+				ts_utc TEXT NOT NULL,
+				value REAL NOT NULL,
+				threshold REAL NOT NULL
+			);`,
+			`CREATE INDEX idx_alert_firings_rule ON alert_firings(rule_id, id);`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "mail digests",
+		Stmts: []string{
+			`CREATE TABLE mail_digests (
+				period TEXT NOT NULL CHECK(period IN ('day','week')),
+				bucket_start_utc TEXT NOT NULL,
+				sent_ts_utc TEXT NOT NULL,
+				PRIMARY KEY(period, bucket_start_utc)
+			);`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "webhook payload formats",
+		Stmts: []string{
+			`ALTER TABLE webhooks ADD COLUMN format TEXT NOT NULL DEFAULT 'json';`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "config file targets",
+		Stmts: []string{
+			`ALTER TABLE targets ADD COLUMN from_file INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE targets ADD COLUMN read_only INTEGER NOT NULL DEFAULT 0;`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "config file target keys",
+		Stmts: []string{
+			`ALTER TABLE targets ADD COLUMN file_key TEXT;`,
+			`UPDATE targets SET file_key=name WHERE from_file=1;`,
+		},
+	},
+}
+
+// LatestVersion is the schema version this binary migrates to.
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+type AppliedMigration struct {
+	Version    int
+	Name       string
+	AppliedUTC string
+}
+
+type MigrationStatus struct {
+	Current int
+	Latest  int
+	Applied []AppliedMigration
+	Pending []Migration
+}
+
+func Migrate(db *sql.DB) error {
+	// WAL can't be switched inside a transaction.
+	if _, err := db.Exec(`PRAGMA journal_mode=WAL;`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_ts_utc TEXT NOT NULL
+	);`); err != nil {
+		return err
+	}
+
+	st, err := GetMigrationStatus(db)
+	if err != nil {
+		return err
+	}
+	if st.Current > st.Latest {
+		return fmt.Errorf("%w: database is at version %d, this binary supports up to %d", ErrSchemaTooNew, st.Current, st.Latest)
+	}
+
+	for _, m := range st.Pending {
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range m.Stmts {
+		if _, err := tx.Exec(s); err != nil {
+			return err
+		}
+	}
+	if m.Backfill != nil {
+		if err := m.Backfill(tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations(version, name, applied_ts_utc) VALUES(?, ?, ?)`,
+		m.Version, m.Name, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetMigrationStatus reports applied and pending migrations without changing
+// anything. A database without schema_migrations is at version 0.
+func GetMigrationStatus(db *sql.DB) (MigrationStatus, error) {
+	st := MigrationStatus{Latest: LatestVersion()}
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='schema_migrations'`).Scan(&n); err != nil {
+		return st, err
+	}
+
+	applied := map[int]bool{}
+	if n > 0 {
+		rows, err := db.Query(`SELECT version, name, applied_ts_utc FROM schema_migrations ORDER BY version`)
+		if err != nil {
+			return st, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var a AppliedMigration
+			if err := rows.Scan(&a.Version, &a.Name, &a.AppliedUTC); err != nil {
+				return st, err
+			}
+			st.Applied = append(st.Applied, a)
+			applied[a.Version] = true
+			st.Current = max(st.Current, a.Version)
+		}
+		if err := rows.Err(); err != nil {
+			return st, err
+		}
+	}
+
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			st.Pending = append(st.Pending, m)
+		}
+	}
+	return st, nil
+}
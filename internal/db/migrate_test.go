@@ -0,0 +1,85 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	dbx := openTestDB(t)
+
+	st, err := GetMigrationStatus(dbx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Current != LatestVersion() || len(st.Pending) != 0 || len(st.Applied) != len(migrations) {
+		t.Fatalf("status = current %d, %d applied, %d pending; want all %d applied", st.Current, len(st.Applied), len(st.Pending), LatestVersion())
+	}
+	for i, a := range st.Applied {
+		if a.Version != migrations[i].Version || a.Name != migrations[i].Name || a.AppliedUTC == "" {
+			t.Errorf("applied[%d] = %+v", i, a)
+		}
+	}
+
+	// Running again changes nothing.
+	if err := Migrate(dbx); err != nil {
+		t.Fatal(err)
+	}
+	again, err := GetMigrationStatus(dbx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(again.Applied, st.Applied) || len(again.Pending) != 0 {
+		t.Errorf("second run changed the status: %+v", again.Applied)
+	}
+}
+
+func TestMigrateRollsBackAFailingMigration(t *testing.T) {
+	dbx := openTestDB(t)
+
+	orig := migrations
+	t.Cleanup(func() { migrations = orig })
+	next := LatestVersion() + 1
+	migrations = append(slices.Clip(orig), Migration{
+		Version: next,
+		Name:    "broken",
+		Stmts: []string{
+			`CREATE TABLE half_done (x INTEGER);`,
+			`INSERT INTO no_such_table VALUES(1);`,
+		},
+	})
+
+	if err := Migrate(dbx); err == nil {
+		t.Fatal("Migrate succeeded with a failing statement")
+	}
+	st, err := GetMigrationStatus(dbx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Current != next-1 || len(st.Pending) != 1 || st.Pending[0].Version != next {
+		t.Errorf("status = current %d, pending %v; want %d still pending", st.Current, st.Pending, next)
+	}
+	var n int
+	if err := dbx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name='half_done'`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("half_done tables = %d, %v; want the first statement rolled back", n, err)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	dbx := openTestDB(t)
+	if _, err := dbx.Exec(`INSERT INTO schema_migrations(version, name, applied_ts_utc) VALUES(?, 'from the future', '2030-01-01T00:00:00Z')`, LatestVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(dbx); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("err = %v, want ErrSchemaTooNew", err)
+	}
+	st, err := GetMigrationStatus(dbx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Current != LatestVersion()+1 || st.Latest != LatestVersion() {
+		t.Errorf("status = current %d, latest %d", st.Current, st.Latest)
+	}
+}
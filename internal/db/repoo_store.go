@@ -2,28 +2,63 @@ package db
 
 import (
 	"database/sql"
+	"strings"
 	"time"
 )
 
 type Repo struct {
-	ID        int64
-	Namespace string
-	Name      string
+	ID        int64  `json:"id"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	GoneUTC   string `json:"gone_ts_utc,omitempty"` // set while the repo is missing on Docker Hub
 }
 
 type RepoSnapshot struct {
-	TSUTC      string
-	PullCount  int64
-	StarCount  int64
-	LastUpdate string
+	TSUTC      string `json:"ts_utc"`
+	PullCount  int64  `json:"pull_count"`
+	StarCount  int64  `json:"star_count"`
+	LastUpdate string `json:"last_updated"`
 }
 
 type RepoDelta struct {
-	FromTSUTC string
-	ToTSUTC   string
-	Delta     int64
-	Seconds   int64
-	PerHour   float64
+	FromTSUTC string  `json:"from_ts_utc"`
+	ToTSUTC   string  `json:"to_ts_utc"`
+	Delta     int64   `json:"delta"`
+	Seconds   int64   `json:"seconds"`
+	PerHour   float64 `json:"per_hour"`
+}
+
+// SeriesPoint is one (timestamp, value) pair of a chart series.
+type SeriesPoint struct {
+	TSUTC string  `json:"t"`
+	Value float64 `json:"v"`
+}
+
+// ListOpts narrows list queries. Zero From/To means unbounded,
+// Limit <= 0 means no limit.
+type ListOpts struct {
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// ParseTime parses an RFC3339 timestamp or a date (YYYY-MM-DD) as a
+// From/To bound; "" is the zero time. With endOfDay a date yields its last
+// second, so an inclusive upper bound covers the whole day.
+func ParseTime(v string, endOfDay bool) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil || !endOfDay {
+		return t, err
+	}
+	return t.Add(24*time.Hour - time.Second), nil
 }
 
 func EnsureRepo(dbx *sql.DB, namespace, name string) (int64, error) {
@@ -38,48 +73,82 @@ func EnsureRepo(dbx *sql.DB, namespace, name string) (int64, error) {
 	return id, nil
 }
 
-func InsertSnapshotAndDelta(dbx *sql.DB, repoID int64, ts time.Time, pullCount, starCount int64, lastUpdated string, isPrivate bool, rawJSON string) error {
+// SnapshotResult tells the caller what InsertSnapshotAndDelta stored.
+type SnapshotResult struct {
+	Inserted bool          // false if a snapshot with the same timestamp existed
+	Prev     *RepoSnapshot // the snapshot before the new one, if any
+}
+
+// InsertSnapshotAndDelta stores a snapshot, the delta to the previous one and
+// the updated rollups in a single transaction.
+func InsertSnapshotAndDelta(dbx *sql.DB, repoID int64, ts time.Time, pullCount, starCount int64, lastUpdated string, isPrivate bool, rawJSON string) (SnapshotResult, error) {
+	tx, err := dbx.Begin()
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := insertSnapshotAndDelta(tx, repoID, ts, pullCount, starCount, lastUpdated, isPrivate, rawJSON)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	return res, tx.Commit()
+}
+
+func insertSnapshotAndDelta(tx *sql.Tx, repoID int64, ts time.Time, pullCount, starCount int64, lastUpdated string, isPrivate bool, rawJSON string) (SnapshotResult, error) {
+	var out SnapshotResult
 	tsUTC := ts.UTC().Format(time.RFC3339)
 
-	var lastTs string
-	var lastPull int64
-	err := dbx.QueryRow(`SELECT ts_utc, pull_count FROM repo_snapshots WHERE repo_id=? ORDER BY ts_utc DESC LIMIT 1`, repoID).
-		Scan(&lastTs, &lastPull)
+	var prev RepoSnapshot
+	err := tx.QueryRow(`SELECT ts_utc, pull_count, COALESCE(star_count,0), COALESCE(last_updated,'') FROM repo_snapshots
+		WHERE repo_id=? AND ts_utc < ? ORDER BY ts_utc DESC LIMIT 1`, repoID, tsUTC).
+		Scan(&prev.TSUTC, &prev.PullCount, &prev.StarCount, &prev.LastUpdate)
+	if err != nil && err != sql.ErrNoRows {
+		return out, err
+	}
+	if err == nil {
+		out.Prev = &prev
+	}
 
-	_, errIns := dbx.Exec(`INSERT OR IGNORE INTO repo_snapshots(repo_id, ts_utc, pull_count, star_count, last_updated, is_private, raw_json)
+	res, errIns := tx.Exec(`INSERT OR IGNORE INTO repo_snapshots(repo_id, ts_utc, pull_count, star_count, last_updated, is_private, raw_json)
 		VALUES(?, ?, ?, ?, ?, ?, ?)`,
 		repoID, tsUTC, pullCount, starCount, lastUpdated, boolToInt(isPrivate), rawJSON)
 	if errIns != nil {
-		return errIns
+		return out, errIns
 	}
-
-	if err == sql.ErrNoRows {
-		return nil
-	}
-	if err != nil {
-		return err
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Same timestamp already stored.
+		return out, nil
 	}
+	out.Inserted = true
 
-	fromT, perr := time.Parse(time.RFC3339, lastTs)
-	if perr != nil {
-		return nil
+	var newer int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM repo_snapshots WHERE repo_id=? AND ts_utc > ?`, repoID, tsUTC).Scan(&newer); err != nil {
+		return out, err
 	}
-	sec := int64(ts.UTC().Sub(fromT).Seconds())
-	if sec <= 0 {
-		return nil
+	if newer > 0 {
+		// Back-filled snapshot: the following delta and buckets change too.
+		if _, _, err := syncRepoDeltas(tx, repoID, tsUTC); err != nil {
+			return out, err
+		}
+		return out, rebuildRepoRollups(tx, repoID, ts)
 	}
 
-	delta := pullCount - lastPull
-	perHour := float64(delta) / (float64(sec) / 3600.0)
+	cur := rollupSample{ts: tsUTC, pull: pullCount, star: starCount}
+	if out.Prev == nil {
+		return out, addToRollups(tx, repoID, nil, cur)
+	}
 
-	_, err = dbx.Exec(`INSERT OR IGNORE INTO repo_deltas(repo_id, from_ts_utc, to_ts_utc, from_pull_count, to_pull_count, delta, seconds, per_hour)
-		VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
-		repoID, lastTs, tsUTC, lastPull, pullCount, delta, sec, perHour)
-	return err
+	if d, ok := computeDelta(prev.TSUTC, prev.PullCount, tsUTC, pullCount); ok {
+		if err := insertDelta(tx, repoID, d); err != nil {
+			return out, err
+		}
+	}
+	return out, addToRollups(tx, repoID, &rollupSample{ts: prev.TSUTC, pull: prev.PullCount, star: prev.StarCount}, cur)
 }
 
 func ListKnownRepos(dbx *sql.DB) ([]Repo, error) {
-	rows, err := dbx.Query(`SELECT id, namespace, name FROM repos ORDER BY id DESC`)
+	rows, err := dbx.Query(`SELECT id, namespace, name, COALESCE(gone_ts_utc,'') FROM repos ORDER BY id DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -87,7 +156,7 @@ func ListKnownRepos(dbx *sql.DB) ([]Repo, error) {
 	var out []Repo
 	for rows.Next() {
 		var r Repo
-		if err := rows.Scan(&r.ID, &r.Namespace, &r.Name); err != nil {
+		if err := rows.Scan(&r.ID, &r.Namespace, &r.Name, &r.GoneUTC); err != nil {
 			return nil, err
 		}
 		out = append(out, r)
@@ -95,9 +164,84 @@ func ListKnownRepos(dbx *sql.DB) ([]Repo, error) {
 	return out, nil
 }
 
+func GetRepo(dbx *sql.DB, id int64) (Repo, error) {
+	var r Repo
+	err := dbx.QueryRow(`SELECT id, namespace, name, COALESCE(gone_ts_utc,'') FROM repos WHERE id=?`, id).Scan(&r.ID, &r.Namespace, &r.Name, &r.GoneUTC)
+	if err != nil {
+		return Repo{}, err
+	}
+	return r, nil
+}
+
+// FindRepo looks a repo up by name; sql.ErrNoRows if it was never seen.
+func FindRepo(dbx *sql.DB, namespace, name string) (Repo, error) {
+	var r Repo
+	err := dbx.QueryRow(`SELECT id, namespace, name, COALESCE(gone_ts_utc,'') FROM repos WHERE namespace=? AND name=?`, namespace, name).
+		Scan(&r.ID, &r.Namespace, &r.Name, &r.GoneUTC)
+	if err != nil {
+		return Repo{}, err
+	}
+	return r, nil
+}
+
+// SetRepoGone marks a repo as missing on Docker Hub (gone=true) or present
+// again. It reports whether the state changed.
+func SetRepoGone(dbx *sql.DB, id int64, gone bool, ts time.Time) (bool, error) {
+	var res sql.Result
+	var err error
+	if gone {
+		res, err = dbx.Exec(`UPDATE repos SET gone_ts_utc=? WHERE id=? AND gone_ts_utc IS NULL`, ts.UTC().Format(time.RFC3339), id)
+	} else {
+		res, err = dbx.Exec(`UPDATE repos SET gone_ts_utc=NULL WHERE id=? AND gone_ts_utc IS NOT NULL`, id)
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// QueryRepos lists repos, optionally restricted to one namespace.
+func QueryRepos(dbx *sql.DB, namespace string, opts ListOpts) ([]Repo, error) {
+	q := `SELECT id, namespace, name, COALESCE(gone_ts_utc,'') FROM repos`
+	var args []any
+	if namespace != "" {
+		q += ` WHERE namespace=?`
+		args = append(args, namespace)
+	}
+	q += ` ORDER BY id DESC`
+	q, args = appendLimit(q, args, opts)
+
+	rows, err := dbx.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Repo
+	for rows.Next() {
+		var r Repo
+		if err := rows.Scan(&r.ID, &r.Namespace, &r.Name, &r.GoneUTC); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
 func ListRepoSnapshots(dbx *sql.DB, repoID int64, limit int) ([]RepoSnapshot, error) {
-	rows, err := dbx.Query(`SELECT ts_utc, pull_count, COALESCE(star_count,0), COALESCE(last_updated,'')
-		FROM repo_snapshots WHERE repo_id=? ORDER BY ts_utc DESC LIMIT ?`, repoID, limit)
+	return QueryRepoSnapshots(dbx, repoID, ListOpts{Limit: limit})
+}
+
+// QueryRepoSnapshots returns snapshots newest first, filtered by ts_utc.
+func QueryRepoSnapshots(dbx *sql.DB, repoID int64, opts ListOpts) ([]RepoSnapshot, error) {
+	q := `SELECT ts_utc, pull_count, COALESCE(star_count,0), COALESCE(last_updated,'')
+		FROM repo_snapshots WHERE repo_id=?`
+	args := []any{repoID}
+	q, args = appendRange(q, args, "ts_utc", opts)
+	q += ` ORDER BY ts_utc DESC`
+	q, args = appendLimit(q, args, opts)
+
+	rows, err := dbx.Query(q, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -114,8 +258,19 @@ func ListRepoSnapshots(dbx *sql.DB, repoID int64, limit int) ([]RepoSnapshot, er
 }
 
 func ListRepoDeltas(dbx *sql.DB, repoID int64, limit int) ([]RepoDelta, error) {
-	rows, err := dbx.Query(`SELECT from_ts_utc, to_ts_utc, delta, seconds, per_hour
-		FROM repo_deltas WHERE repo_id=? ORDER BY to_ts_utc DESC LIMIT ?`, repoID, limit)
+	return QueryRepoDeltas(dbx, repoID, ListOpts{Limit: limit})
+}
+
+// QueryRepoDeltas returns deltas newest first, filtered by to_ts_utc.
+func QueryRepoDeltas(dbx *sql.DB, repoID int64, opts ListOpts) ([]RepoDelta, error) {
+	q := `SELECT from_ts_utc, to_ts_utc, delta, seconds, per_hour
+		FROM repo_deltas WHERE repo_id=?`
+	args := []any{repoID}
+	q, args = appendRange(q, args, "to_ts_utc", opts)
+	q += ` ORDER BY to_ts_utc DESC`
+	q, args = appendLimit(q, args, opts)
+
+	rows, err := dbx.Query(q, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -131,9 +286,105 @@ func ListRepoDeltas(dbx *sql.DB, repoID int64, limit int) ([]RepoDelta, error) {
 	return out, nil
 }
 
+// PullCountSeries returns pull_count over time, oldest first, starting at from
+// (zero = everything).
+func PullCountSeries(dbx *sql.DB, repoID int64, from time.Time) ([]SeriesPoint, error) {
+	q := `SELECT ts_utc, pull_count FROM repo_snapshots WHERE repo_id=?`
+	args := []any{repoID}
+	q, args = appendRange(q, args, "ts_utc", ListOpts{From: from})
+	return querySeries(dbx, q+` ORDER BY ts_utc ASC`, args)
+}
+
+// PerHourSeries returns the per-hour rate of each delta, oldest first,
+// keyed by the delta's end timestamp.
+func PerHourSeries(dbx *sql.DB, repoID int64, from time.Time) ([]SeriesPoint, error) {
+	q := `SELECT to_ts_utc, per_hour FROM repo_deltas WHERE repo_id=?`
+	args := []any{repoID}
+	q, args = appendRange(q, args, "to_ts_utc", ListOpts{From: from})
+	return querySeries(dbx, q+` ORDER BY to_ts_utc ASC`, args)
+}
+
+func querySeries(dbx *sql.DB, q string, args []any) ([]SeriesPoint, error) {
+	rows, err := dbx.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []SeriesPoint{}
+	for rows.Next() {
+		var p SeriesPoint
+		if err := rows.Scan(&p.TSUTC, &p.Value); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// appendRange adds ts filters; timestamps are stored as RFC3339 UTC
+// strings, so lexical comparison matches chronological order.
+func appendRange(q string, args []any, col string, opts ListOpts) (string, []any) {
+	if !opts.From.IsZero() {
+		q += ` AND ` + col + ` >= ?`
+		args = append(args, opts.From.UTC().Format(time.RFC3339))
+	}
+	if !opts.To.IsZero() {
+		q += ` AND ` + col + ` <= ?`
+		args = append(args, opts.To.UTC().Format(time.RFC3339))
+	}
+	return q, args
+}
+
+func appendLimit(q string, args []any, opts ListOpts) (string, []any) {
+	if opts.Limit <= 0 && opts.Offset <= 0 {
+		return q, args
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	q += ` LIMIT ? OFFSET ?`
+	return q, append(args, limit, opts.Offset)
+}
+
 func boolToInt(b bool) int {
 	if b {
 		return 1
 	}
 	return 0
-}
\ No newline at end of file
+}
+
+// RepoLatest is the newest snapshot of a repo and the rate of its newest delta.
+type RepoLatest struct {
+	Repo
+	TSUTC      string
+	PullCount  int64
+	StarCount  int64
+	PerHour    float64
+	HasPerHour bool // false until the repo has two snapshots
+}
+
+// ListRepoLatest returns the newest state of every repo with snapshots.
+func ListRepoLatest(dbx *sql.DB) ([]RepoLatest, error) {
+	rows, err := dbx.Query(`SELECT r.id, r.namespace, r.name, s.ts_utc, s.pull_count, COALESCE(s.star_count,0),
+		(SELECT per_hour FROM repo_deltas d WHERE d.repo_id=r.id ORDER BY d.to_ts_utc DESC LIMIT 1)
+		FROM repos r
+		JOIN repo_snapshots s ON s.repo_id=r.id
+			AND s.ts_utc=(SELECT MAX(ts_utc) FROM repo_snapshots WHERE repo_id=r.id)
+		ORDER BY r.namespace, r.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []RepoLatest
+	for rows.Next() {
+		var l RepoLatest
+		var perHour sql.NullFloat64
+		if err := rows.Scan(&l.ID, &l.Namespace, &l.Name, &l.TSUTC, &l.PullCount, &l.StarCount, &perHour); err != nil {
+			return nil, err
+		}
+		l.PerHour, l.HasPerHour = perHour.Float64, perHour.Valid
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
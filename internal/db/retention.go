@@ -0,0 +1,151 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RetentionPolicy controls how much snapshot history is kept. Zero durations
+// disable the corresponding step.
+type RetentionPolicy struct {
+	Full    time.Duration // keep every snapshot this long, then one per hour
+	Hourly  time.Duration // keep hourly snapshots this long, then one per day
+	RawJSON time.Duration // drop raw_json of snapshots older than this
+}
+
+func (p RetentionPolicy) Enabled() bool {
+	return p.Full > 0 || p.Hourly > 0 || p.RawJSON > 0
+}
+
+// Validate rejects an hourly window shorter than the full one: thinning to
+// one snapshot per day would then reach into the range meant to keep every
+// snapshot, and deleted snapshots cannot be recovered.
+func (p RetentionPolicy) Validate() error {
+	if p.Hourly > 0 && p.Hourly < p.Full {
+		return fmt.Errorf("hourly retention (%s) must not be shorter than full retention (%s)", p.Hourly, p.Full)
+	}
+	return nil
+}
+
+// CompactionResult summarizes a Compact run.
+type CompactionResult struct {
+	Repos            int
+	SnapshotsDeleted int64
+	DeltasAdded      int
+	DeltasRemoved    int
+	RawJSONCleared   int64
+}
+
+// Compact applies p as of now: older snapshots are thinned to the last one of
+// each hour or day and the deltas of the affected range are recomputed, so
+// repo_deltas always connects consecutive retained snapshots. Rollups are
+// left alone; they keep the full-resolution aggregates. Each repo is
+// compacted in its own transaction.
+func Compact(dbx *sql.DB, p RetentionPolicy, now time.Time) (CompactionResult, error) {
+	var res CompactionResult
+	if err := p.Validate(); err != nil {
+		return res, err
+	}
+
+	// Bucket-aligned cutoffs, so a bucket is never thinned half-way.
+	var hourCut, dayCut string
+	if p.Hourly > 0 {
+		dayCut = BucketStart(PeriodDay, now.Add(-p.Hourly)).Format(time.RFC3339)
+	}
+	if p.Full > 0 {
+		hourCut = BucketStart(PeriodHour, now.Add(-p.Full)).Format(time.RFC3339)
+	} else {
+		// Full resolution until the hourly cutoff.
+		hourCut = dayCut
+	}
+
+	if hourCut != "" || dayCut != "" {
+		repos, err := ListKnownRepos(dbx)
+		if err != nil {
+			return res, err
+		}
+		for _, r := range repos {
+			tx, err := dbx.Begin()
+			if err != nil {
+				return res, err
+			}
+			deleted, added, removed, err := compactRepo(tx, r.ID, hourCut, dayCut)
+			if err != nil {
+				tx.Rollback()
+				return res, err
+			}
+			if err := tx.Commit(); err != nil {
+				return res, err
+			}
+			res.Repos++
+			res.SnapshotsDeleted += deleted
+			res.DeltasAdded += added
+			res.DeltasRemoved += removed
+		}
+	}
+
+	if p.RawJSON > 0 {
+		r, err := dbx.Exec(`UPDATE repo_snapshots SET raw_json=NULL WHERE ts_utc < ? AND raw_json IS NOT NULL`,
+			now.Add(-p.RawJSON).UTC().Format(time.RFC3339))
+		if err != nil {
+			return res, err
+		}
+		res.RawJSONCleared, _ = r.RowsAffected()
+	}
+	return res, nil
+}
+
+// compactRepo keeps the last snapshot per day before dayCut and per hour
+// between dayCut and hourCut ("" = no such range), then resyncs the deltas
+// from the first deleted snapshot on.
+func compactRepo(tx *sql.Tx, repoID int64, hourCut, dayCut string) (deleted int64, added, removed int, err error) {
+	var first sql.NullString
+
+	thin := func(from, to string, bucketLen int) error {
+		if to == "" || from >= to {
+			return nil
+		}
+		var f sql.NullString
+		if err := tx.QueryRow(`SELECT MIN(ts_utc) FROM repo_snapshots WHERE repo_id=? AND ts_utc >= ? AND ts_utc < ?
+			AND ts_utc NOT IN (SELECT MAX(ts_utc) FROM repo_snapshots WHERE repo_id=? AND ts_utc >= ? AND ts_utc < ?
+				GROUP BY substr(ts_utc, 1, ?))`,
+			repoID, from, to, repoID, from, to, bucketLen).Scan(&f); err != nil {
+			return err
+		}
+		if !f.Valid {
+			return nil
+		}
+		if !first.Valid || f.String < first.String {
+			first = f
+		}
+
+		r, err := tx.Exec(`DELETE FROM repo_snapshots WHERE repo_id=? AND ts_utc >= ? AND ts_utc < ?
+			AND ts_utc NOT IN (SELECT MAX(ts_utc) FROM repo_snapshots WHERE repo_id=? AND ts_utc >= ? AND ts_utc < ?
+				GROUP BY substr(ts_utc, 1, ?))`,
+			repoID, from, to, repoID, from, to, bucketLen)
+		if err != nil {
+			return err
+		}
+		n, _ := r.RowsAffected()
+		deleted += n
+		return nil
+	}
+
+	// RFC3339 UTC prefixes: "2006-01-02" is the day, "2006-01-02T15" the hour.
+	if err := thin("", dayCut, len("2006-01-02")); err != nil {
+		return 0, 0, 0, err
+	}
+	if err := thin(dayCut, hourCut, len("2006-01-02T15")); err != nil {
+		return 0, 0, 0, err
+	}
+	if !first.Valid {
+		return 0, 0, 0, nil
+	}
+
+	added, removed, err = syncRepoDeltas(tx, repoID, first.String)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return deleted, added, removed, nil
+}
@@ -0,0 +1,183 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRetentionValidate(t *testing.T) {
+	day := 24 * time.Hour
+	for _, tc := range []struct {
+		p  RetentionPolicy
+		ok bool
+	}{
+		{RetentionPolicy{}, true},
+		{RetentionPolicy{Full: 30 * day}, true},
+		{RetentionPolicy{Hourly: 7 * day}, true},
+		{RetentionPolicy{Full: 7 * day, Hourly: 30 * day}, true},
+		{RetentionPolicy{Full: 7 * day, Hourly: 7 * day}, true},
+		{RetentionPolicy{Full: 30 * day, Hourly: 7 * day}, false},
+	} {
+		if err := tc.p.Validate(); (err == nil) != tc.ok {
+			t.Errorf("Validate(%+v) = %v, want ok=%t", tc.p, err, tc.ok)
+		}
+	}
+}
+
+func TestCompactRefusesInvalidPolicy(t *testing.T) {
+	dbx, err := Open(filepath.Join(t.TempDir(), "pulls.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbx.Close()
+	if err := Migrate(dbx); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	repoID, err := EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Four snapshots a day for the last 20 days.
+	for i := range 80 {
+		ts := now.Add(-time.Duration(i) * 6 * time.Hour)
+		if _, err := InsertSnapshotAndDelta(dbx, repoID, ts, int64(1000-i), 0, "", false, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := RetentionPolicy{Full: 30 * 24 * time.Hour, Hourly: 7 * 24 * time.Hour}
+	if _, err := Compact(dbx, p, now); err == nil {
+		t.Fatal("Compact accepted hourly retention shorter than full retention")
+	}
+	snaps, err := ListRepoSnapshots(dbx, repoID, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 80 {
+		t.Errorf("%d snapshots left, want all 80", len(snaps))
+	}
+}
+
+func TestCompactThinsOldSnapshots(t *testing.T) {
+	dbx := openTestDB(t)
+	repoID, err := EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 3, 20, 12, 10, 0, 0, time.UTC)
+
+	// A snapshot every 20 minutes for the last ten days.
+	var all []string
+	pullAt := map[string]int64{}
+	var pulls int64 = 1000
+	for ts := now.Add(-10 * 24 * time.Hour); !ts.After(now); ts = ts.Add(20 * time.Minute) {
+		pulls += int64(len(all)%5 + 1)
+		if _, err := InsertSnapshotAndDelta(dbx, repoID, ts, pulls, 0, "", false, `{"pull_count":1}`); err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, ts.Format(time.RFC3339))
+		pullAt[all[len(all)-1]] = pulls
+	}
+	rollups := allRollups(t, dbx, repoID)
+
+	p := RetentionPolicy{Full: 2 * 24 * time.Hour, Hourly: 5 * 24 * time.Hour, RawJSON: 24 * time.Hour}
+	res, err := Compact(dbx, p, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Before the day cutoff (2025-03-15) the last snapshot of each day
+	// survives, before the hour cutoff (2025-03-18T12) the last of each hour.
+	const dayCut, hourCut = "2025-03-15T00:00:00Z", "2025-03-18T12:00:00Z"
+	var want []string
+	for i, ts := range all {
+		switch {
+		case ts >= hourCut:
+		case ts >= dayCut:
+			if i+1 < len(all) && all[i+1][:13] == ts[:13] && all[i+1] < hourCut {
+				continue
+			}
+		default:
+			if all[i+1][:10] == ts[:10] && all[i+1] < dayCut {
+				continue
+			}
+		}
+		want = append(want, ts)
+	}
+	got := snapshotTimes(t, dbx, repoID)
+	if !slices.Equal(got, want) {
+		t.Fatalf("kept %d snapshots, want %d:\ngot  %v\nwant %v", len(got), len(want), got, want)
+	}
+	if res.Repos != 1 || res.SnapshotsDeleted != int64(len(all)-len(want)) {
+		t.Errorf("result = %+v, want %d snapshots deleted", res, len(all)-len(want))
+	}
+
+	// Deltas connect the kept snapshots and still add up to the change
+	// since the oldest one kept.
+	chain := deltaChain(t, dbx, repoID)
+	if len(chain) != len(want)-1 {
+		t.Fatalf("%d deltas for %d snapshots", len(chain), len(want))
+	}
+	var sum int64
+	for i, d := range chain {
+		var to string
+		var delta int64
+		if _, err := fmt.Sscanf(d, "%s %d", &to, &delta); err != nil {
+			t.Fatal(err)
+		}
+		if to != want[i+1] {
+			t.Errorf("delta %d ends at %s, want %s", i, to, want[i+1])
+		}
+		sum += delta
+	}
+	if total := pullAt[want[len(want)-1]] - pullAt[want[0]]; sum != total {
+		t.Errorf("deltas sum to %d, want %d", sum, total)
+	}
+	if !equalRollups(allRollups(t, dbx, repoID), rollups) {
+		t.Error("Compact changed the rollups")
+	}
+
+	// raw_json is only kept for the last day.
+	var withRaw, recent int
+	if err := dbx.QueryRow(`SELECT COUNT(raw_json), COUNT(*) FILTER (WHERE ts_utc >= ?) FROM repo_snapshots WHERE repo_id=?`,
+		now.Add(-24*time.Hour).Format(time.RFC3339), repoID).Scan(&withRaw, &recent); err != nil {
+		t.Fatal(err)
+	}
+	if withRaw != recent || res.RawJSONCleared != int64(len(want)-recent) {
+		t.Errorf("%d snapshots with raw_json, %d cleared; want the %d of the last day", withRaw, res.RawJSONCleared, recent)
+	}
+
+	// A second run has nothing left to do.
+	res, err = Compact(dbx, p, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SnapshotsDeleted != 0 || res.DeltasAdded != 0 || res.DeltasRemoved != 0 || res.RawJSONCleared != 0 {
+		t.Errorf("second run = %+v, want no changes", res)
+	}
+}
+
+// snapshotTimes lists a repo's snapshot timestamps oldest first.
+func snapshotTimes(t *testing.T, dbx *sql.DB, repoID int64) []string {
+	t.Helper()
+	rows, err := dbx.Query(`SELECT ts_utc FROM repo_snapshots WHERE repo_id=? ORDER BY ts_utc`, repoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var ts string
+		if err := rows.Scan(&ts); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, ts)
+	}
+	return out
+}
@@ -0,0 +1,274 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Rollup periods.
+const (
+	PeriodHour = "hour"
+	PeriodDay  = "day"
+	PeriodWeek = "week"
+)
+
+// rollupPeriods lists the periods in the order rollups are maintained.
+var rollupPeriods = []string{PeriodHour, PeriodDay, PeriodWeek}
+
+var rollupTables = map[string]string{
+	PeriodHour: "repo_rollups_hourly",
+	PeriodDay:  "repo_rollups_daily",
+	PeriodWeek: "repo_rollups_weekly",
+}
+
+// RepoRollup aggregates the snapshots of one repo within a bucket. Delta,
+// Seconds and StarDelta sum the changes since each previous snapshot, so a
+// change spanning a bucket boundary counts towards the bucket it ends in and
+// the deltas of consecutive buckets add up to the total.
+type RepoRollup struct {
+	BucketStartUTC string  `json:"bucket_start_utc"`
+	FirstTSUTC     string  `json:"first_ts_utc"`
+	LastTSUTC      string  `json:"last_ts_utc"`
+	FirstPullCount int64   `json:"first_pull_count"`
+	LastPullCount  int64   `json:"last_pull_count"`
+	Delta          int64   `json:"delta"`
+	Seconds        int64   `json:"seconds"`
+	PerHour        float64 `json:"per_hour"`
+	FirstStarCount int64   `json:"first_star_count"`
+	LastStarCount  int64   `json:"last_star_count"`
+	StarDelta      int64   `json:"star_delta"`
+	Samples        int64   `json:"samples"`
+}
+
+// ValidPeriod reports whether p is one of PeriodHour, PeriodDay, PeriodWeek.
+func ValidPeriod(p string) bool {
+	_, ok := rollupTables[p]
+	return ok
+}
+
+// BucketStart returns the start of the UTC bucket containing t. Weeks start
+// on Monday (ISO 8601).
+func BucketStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	switch period {
+	case PeriodHour:
+		return t.Truncate(time.Hour)
+	case PeriodDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case PeriodWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+	return t
+}
+
+// rollupSample is the part of a snapshot the rollups are built from.
+type rollupSample struct {
+	ts   string
+	pull int64
+	star int64
+}
+
+// addToRollups folds cur into the hourly, daily and weekly buckets it falls
+// in. prev is the snapshot before cur, or nil for a repo's first snapshot.
+func addToRollups(tx *sql.Tx, repoID int64, prev *rollupSample, cur rollupSample) error {
+	t, err := time.Parse(time.RFC3339, cur.ts)
+	if err != nil {
+		return err
+	}
+
+	var delta, sec, starDelta int64
+	if prev != nil {
+		if d, ok := computeDelta(prev.ts, prev.pull, cur.ts, cur.pull); ok {
+			delta, sec = d.Delta, d.Seconds
+			starDelta = cur.star - prev.star
+		}
+	}
+
+	for _, p := range rollupPeriods {
+		bucket := BucketStart(p, t).Format(time.RFC3339)
+		// Column names on the right of SET refer to the stored row.
+		_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s(repo_id, bucket_start_utc, first_ts_utc, last_ts_utc,
+			first_pull_count, last_pull_count, delta, seconds, per_hour,
+			first_star_count, last_star_count, star_delta, samples)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
+			ON CONFLICT(repo_id, bucket_start_utc) DO UPDATE SET
+				first_pull_count = CASE WHEN excluded.first_ts_utc < first_ts_utc THEN excluded.first_pull_count ELSE first_pull_count END,
+				first_star_count = CASE WHEN excluded.first_ts_utc < first_ts_utc THEN excluded.first_star_count ELSE first_star_count END,
+				first_ts_utc = MIN(first_ts_utc, excluded.first_ts_utc),
+				last_pull_count = CASE WHEN excluded.last_ts_utc > last_ts_utc THEN excluded.last_pull_count ELSE last_pull_count END,
+				last_star_count = CASE WHEN excluded.last_ts_utc > last_ts_utc THEN excluded.last_star_count ELSE last_star_count END,
+				last_ts_utc = MAX(last_ts_utc, excluded.last_ts_utc),
+				delta = delta + excluded.delta,
+				seconds = seconds + excluded.seconds,
+				per_hour = CASE WHEN seconds + excluded.seconds > 0
+					THEN (delta + excluded.delta) * 3600.0 / (seconds + excluded.seconds) ELSE 0 END,
+				star_delta = star_delta + excluded.star_delta,
+				samples = samples + 1`, rollupTables[p]),
+			repoID, bucket, cur.ts, cur.ts, cur.pull, cur.pull, delta, sec, perHour(delta, sec),
+			cur.star, cur.star, starDelta)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func perHour(delta, sec int64) float64 {
+	if sec <= 0 {
+		return 0
+	}
+	return float64(delta) * 3600 / float64(sec)
+}
+
+// RebuildRollups recomputes all rollups from repo_snapshots and returns the
+// number of repos processed.
+func RebuildRollups(dbx *sql.DB) (int, error) {
+	tx, err := dbx.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n, err := rebuildRollups(tx, time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// rebuildRollups recomputes the rollups of every repo from the week
+// containing since onwards (zero since = everything).
+func rebuildRollups(tx *sql.Tx, since time.Time) (int, error) {
+	rows, err := tx.Query(`SELECT id FROM repos ORDER BY id`)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err := rebuildRepoRollups(tx, id, since); err != nil {
+			return 0, fmt.Errorf("repo %d: %w", id, err)
+		}
+	}
+	return len(ids), nil
+}
+
+// rebuildRepoRollups recomputes one repo's rollups from the week containing
+// since onwards. Starting at a week boundary also realigns the hourly and
+// daily buckets, since a week starts on an hour and day boundary.
+func rebuildRepoRollups(tx *sql.Tx, repoID int64, since time.Time) error {
+	var from string
+	if !since.IsZero() {
+		from = BucketStart(PeriodWeek, since).Format(time.RFC3339)
+	}
+
+	for _, p := range rollupPeriods {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE repo_id=? AND bucket_start_utc >= ?`, rollupTables[p]),
+			repoID, from); err != nil {
+			return err
+		}
+	}
+
+	// Include the last snapshot before from so the first bucket gets its delta.
+	rows, err := tx.Query(`SELECT ts_utc, pull_count, COALESCE(star_count,0) FROM repo_snapshots
+		WHERE repo_id=? AND ts_utc >= COALESCE((SELECT MAX(ts_utc) FROM repo_snapshots WHERE repo_id=? AND ts_utc < ?), ?)
+		ORDER BY ts_utc`, repoID, repoID, from, from)
+	if err != nil {
+		return err
+	}
+	var samples []rollupSample
+	for rows.Next() {
+		var s rollupSample
+		if err := rows.Scan(&s.ts, &s.pull, &s.star); err != nil {
+			rows.Close()
+			return err
+		}
+		samples = append(samples, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range samples {
+		if samples[i].ts < from {
+			continue
+		}
+		var prev *rollupSample
+		if i > 0 {
+			prev = &samples[i-1]
+		}
+		if err := addToRollups(tx, repoID, prev, samples[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueryRepoRollups returns a repo's buckets of the given period oldest first,
+// filtered by bucket_start_utc.
+func QueryRepoRollups(dbx *sql.DB, repoID int64, period string, opts ListOpts) ([]RepoRollup, error) {
+	table, ok := rollupTables[period]
+	if !ok {
+		return nil, fmt.Errorf("unknown rollup period %q", period)
+	}
+	q := `SELECT bucket_start_utc, first_ts_utc, last_ts_utc, first_pull_count, last_pull_count,
+		delta, seconds, per_hour, first_star_count, last_star_count, star_delta, samples
+		FROM ` + table + ` WHERE repo_id=?`
+	args := []any{repoID}
+	q, args = appendRange(q, args, "bucket_start_utc", opts)
+	q += ` ORDER BY bucket_start_utc ASC`
+	q, args = appendLimit(q, args, opts)
+
+	rows, err := dbx.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []RepoRollup{}
+	for rows.Next() {
+		var r RepoRollup
+		if err := rows.Scan(&r.BucketStartUTC, &r.FirstTSUTC, &r.LastTSUTC, &r.FirstPullCount, &r.LastPullCount,
+			&r.Delta, &r.Seconds, &r.PerHour, &r.FirstStarCount, &r.LastStarCount, &r.StarDelta, &r.Samples); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// RollupSeries returns the last pull count and the average per-hour rate of
+// each bucket since from, oldest first, keyed by the bucket's last snapshot.
+func RollupSeries(dbx *sql.DB, repoID int64, period string, from time.Time) (pulls, rate []SeriesPoint, err error) {
+	var opts ListOpts
+	if !from.IsZero() {
+		opts.From = BucketStart(period, from)
+	}
+	buckets, err := QueryRepoRollups(dbx, repoID, period, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	pulls = make([]SeriesPoint, 0, len(buckets))
+	rate = make([]SeriesPoint, 0, len(buckets))
+	for _, b := range buckets {
+		pulls = append(pulls, SeriesPoint{TSUTC: b.LastTSUTC, Value: float64(b.LastPullCount)})
+		if b.Seconds > 0 {
+			rate = append(rate, SeriesPoint{TSUTC: b.LastTSUTC, Value: b.PerHour})
+		}
+	}
+	return pulls, rate, nil
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketStart(t *testing.T) {
+	plus2 := time.FixedZone("UTC+2", 2*60*60)
+	for _, tc := range []struct {
+		period string
+		t      time.Time
+		want   string
+	}{
+		{PeriodHour, time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC), "2024-12-31T23:00:00Z"},
+		{PeriodDay, time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC), "2024-12-31T00:00:00Z"},
+		{PeriodDay, time.Date(2025, 1, 1, 1, 0, 0, 0, plus2), "2024-12-31T00:00:00Z"},
+		// ISO weeks start on Monday, and the first week of 2025 on 2024-12-30.
+		{PeriodWeek, time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC), "2024-12-30T00:00:00Z"},
+		{PeriodWeek, time.Date(2025, 1, 5, 23, 59, 59, 0, time.UTC), "2024-12-30T00:00:00Z"},
+		{PeriodWeek, time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC), "2025-01-06T00:00:00Z"},
+		{PeriodWeek, time.Date(2025, 1, 6, 1, 30, 0, 0, plus2), "2024-12-30T00:00:00Z"},
+		{PeriodWeek, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), "2025-12-29T00:00:00Z"},
+		{PeriodWeek, time.Date(2024, 12, 29, 12, 0, 0, 0, time.UTC), "2024-12-23T00:00:00Z"},
+	} {
+		if got := BucketStart(tc.period, tc.t).Format(time.RFC3339); got != tc.want {
+			t.Errorf("BucketStart(%s, %s) = %s, want %s", tc.period, tc.t.Format(time.RFC3339), got, tc.want)
+		}
+	}
+}
+
+// rollupSeries is snapshot times and pull counts spanning a year end and a
+// Sunday to Monday week boundary at uneven intervals.
+func rollupSeries() (ts []time.Time, pulls []int64) {
+	t := time.Date(2024, 12, 28, 5, 17, 0, 0, time.UTC)
+	var p int64 = 1000
+	for i := range 60 {
+		ts = append(ts, t)
+		pulls = append(pulls, p)
+		t = t.Add(time.Duration(3+i%7) * time.Hour).Add(time.Duration(i) * time.Minute)
+		p += int64(10 + i*i%37)
+	}
+	return ts, pulls
+}
+
+func TestIncrementalRollupsMatchRebuild(t *testing.T) {
+	dbx := openTestDB(t)
+	repoID, err := EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, pulls := rollupSeries()
+	for i := range ts {
+		addSnapshot(t, dbx, repoID, ts[i], pulls[i])
+	}
+	incremental := allRollups(t, dbx, repoID)
+
+	if n, err := RebuildRollups(dbx); err != nil || n != 1 {
+		t.Fatalf("RebuildRollups = %d, %v", n, err)
+	}
+	rebuilt := allRollups(t, dbx, repoID)
+	if !equalRollups(incremental, rebuilt) {
+		t.Errorf("incremental rollups differ from a rebuild:\nincremental %+v\nrebuilt     %+v", incremental, rebuilt)
+	}
+
+	total := pulls[len(pulls)-1] - pulls[0]
+	for _, p := range rollupPeriods {
+		var sum, samples int64
+		for _, r := range rebuilt[p] {
+			sum += r.Delta
+			samples += r.Samples
+		}
+		if sum != total || samples != int64(len(ts)) {
+			t.Errorf("%s buckets sum to %d over %d samples, want %d over %d", p, sum, samples, total, len(ts))
+		}
+	}
+	if weeks := rebuilt[PeriodWeek]; len(weeks) != 3 || weeks[0].BucketStartUTC != "2024-12-23T00:00:00Z" || weeks[2].BucketStartUTC != "2025-01-06T00:00:00Z" {
+		t.Errorf("weekly buckets = %+v", weeks)
+	}
+}
+
+func TestRollupsAfterBackfill(t *testing.T) {
+	dbx := openTestDB(t)
+	inOrder, err := EnsureRepo(dbx, "ns", "in-order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backfilled, err := EnsureRepo(dbx, "ns", "backfilled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, pulls := rollupSeries()
+	for i := range ts {
+		addSnapshot(t, dbx, inOrder, ts[i], pulls[i])
+	}
+	// Every third snapshot first, then the rest newest first, so most
+	// inserts land before existing snapshots, some in earlier weeks.
+	for i := 0; i < len(ts); i += 3 {
+		addSnapshot(t, dbx, backfilled, ts[i], pulls[i])
+	}
+	for i := len(ts) - 1; i >= 0; i-- {
+		if i%3 != 0 {
+			addSnapshot(t, dbx, backfilled, ts[i], pulls[i])
+		}
+	}
+
+	want := allRollups(t, dbx, inOrder)
+	if got := allRollups(t, dbx, backfilled); !equalRollups(got, want) {
+		t.Errorf("rollups after backfill differ from in-order inserts:\ngot  %+v\nwant %+v", got, want)
+	}
+	if _, err := RebuildRollups(dbx); err != nil {
+		t.Fatal(err)
+	}
+	if got := allRollups(t, dbx, backfilled); !equalRollups(got, want) {
+		t.Errorf("rebuild changed the backfilled rollups:\ngot  %+v\nwant %+v", got, want)
+	}
+}
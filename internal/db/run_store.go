@@ -0,0 +1,179 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// Repo outcomes within a target run.
+const (
+	RepoStatusOK      = "ok"
+	RepoStatusFailed  = "failed"
+	RepoStatusSkipped = "skipped"
+)
+
+// Run triggers.
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// keepRunsPerTarget bounds target_runs so per-repo rows of large user-mode
+// targets don't grow forever.
+const keepRunsPerTarget = 500
+
+type TargetRun struct {
+	ID           int64           `json:"id"`
+	TargetID     int64           `json:"target_id"`
+	Trigger      string          `json:"trigger"`
+	StartedUTC   string          `json:"started_ts_utc"`
+	FinishedUTC  string          `json:"finished_ts_utc"`
+	DurationMS   int64           `json:"duration_ms"`
+	ReposOK      int             `json:"repos_ok"`
+	ReposFailed  int             `json:"repos_failed"`
+	ReposSkipped int             `json:"repos_skipped"`
+	Error        string          `json:"error,omitempty"`
+	Repos        []TargetRunRepo `json:"repos"`
+}
+
+type TargetRunRepo struct {
+	Repo      string `json:"repo"`
+	Status    string `json:"status"` // ok|failed|skipped
+	PullCount int64  `json:"pull_count,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Count fills ReposOK/ReposFailed/ReposSkipped from Repos.
+func (r *TargetRun) Count() {
+	r.ReposOK, r.ReposFailed, r.ReposSkipped = 0, 0, 0
+	for _, rr := range r.Repos {
+		switch rr.Status {
+		case RepoStatusOK:
+			r.ReposOK++
+		case RepoStatusFailed:
+			r.ReposFailed++
+		case RepoStatusSkipped:
+			r.ReposSkipped++
+		}
+	}
+}
+
+// InsertTargetRun stores a run with its per-repo rows and prunes old runs of
+// the same target.
+func InsertTargetRun(dbx *sql.DB, run TargetRun) (int64, error) {
+	tx, err := dbx.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO target_runs(target_id, trigger, started_ts_utc, finished_ts_utc, duration_ms,
+		repos_ok, repos_failed, repos_skipped, error)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.TargetID, run.Trigger, run.StartedUTC, run.FinishedUTC, run.DurationMS,
+		run.ReposOK, run.ReposFailed, run.ReposSkipped, nullIfEmpty(run.Error))
+	if err != nil {
+		return 0, err
+	}
+	runID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, rr := range run.Repos {
+		if _, err := tx.Exec(`INSERT INTO target_run_repos(run_id, repo, status, pull_count, error) VALUES(?, ?, ?, ?, ?)`,
+			runID, rr.Repo, rr.Status, rr.PullCount, nullIfEmpty(rr.Error)); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM target_runs WHERE target_id=? AND id NOT IN (
+			SELECT id FROM target_runs WHERE target_id=? ORDER BY id DESC LIMIT ?)`,
+		run.TargetID, run.TargetID, keepRunsPerTarget); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return runID, nil
+}
+
+// QueryTargetRuns returns runs of a target newest first, including their
+// per-repo rows. From/To filter on started_ts_utc.
+func QueryTargetRuns(dbx *sql.DB, targetID int64, opts ListOpts) ([]TargetRun, error) {
+	q := `SELECT id, target_id, trigger, started_ts_utc, finished_ts_utc, duration_ms,
+		repos_ok, repos_failed, repos_skipped, COALESCE(error,'')
+		FROM target_runs WHERE target_id=?`
+	args := []any{targetID}
+	q, args = appendRange(q, args, "started_ts_utc", opts)
+	q += ` ORDER BY id DESC`
+	q, args = appendLimit(q, args, opts)
+
+	rows, err := dbx.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	var out []TargetRun
+	for rows.Next() {
+		var r TargetRun
+		if err := rows.Scan(&r.ID, &r.TargetID, &r.Trigger, &r.StartedUTC, &r.FinishedUTC, &r.DurationMS,
+			&r.ReposOK, &r.ReposFailed, &r.ReposSkipped, &r.Error); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Separate pass: with a single connection we can't query while rows is open.
+	for i := range out {
+		repos, err := listTargetRunRepos(dbx, out[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		out[i].Repos = repos
+	}
+	return out, nil
+}
+
+func listTargetRunRepos(dbx *sql.DB, runID int64) ([]TargetRunRepo, error) {
+	rows, err := dbx.Query(`SELECT repo, status, COALESCE(pull_count,0), COALESCE(error,'')
+		FROM target_run_repos WHERE run_id=? ORDER BY status <> 'failed', repo`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []TargetRunRepo{}
+	for rows.Next() {
+		var rr TargetRunRepo
+		if err := rows.Scan(&rr.Repo, &rr.Status, &rr.PullCount, &rr.Error); err != nil {
+			return nil, err
+		}
+		out = append(out, rr)
+	}
+	return out, rows.Err()
+}
+
+// LastSuccessfulRuns maps target IDs to the finish time of their newest run
+// without a run error or failed repos.
+func LastSuccessfulRuns(dbx *sql.DB) (map[int64]string, error) {
+	rows, err := dbx.Query(`SELECT target_id, MAX(finished_ts_utc) FROM target_runs
+		WHERE error IS NULL AND repos_failed = 0 GROUP BY target_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var ts string
+		if err := rows.Scan(&id, &ts); err != nil {
+			return nil, err
+		}
+		out[id] = ts
+	}
+	return out, rows.Err()
+}
@@ -2,27 +2,40 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"strings"
 	"time"
 )
 
 type Target struct {
-	ID              int64
-	Name            string
-	Mode            string // user|repos
-	Namespace       string
-	ReposCSV        string
-	IntervalSeconds int64
-	Enabled         bool
-	LastRunUTC      string
-	LastError       string
+	ID              int64  `json:"id"`
+	Name            string `json:"name"`
+	Mode            string `json:"mode"` // user|repos
+	Namespace       string `json:"namespace"`
+	ReposCSV        string `json:"repos_csv"`
+	IntervalSeconds int64  `json:"interval_seconds"`
+	Enabled         bool   `json:"enabled"`
+	LastRunUTC      string `json:"last_run_ts_utc"`
+	LastError       string `json:"last_error"`
+	FromFile        bool   `json:"from_file"` // managed by the config file
+	ReadOnly        bool   `json:"read_only"` // FromFile and locked against edits
 }
 
+// ErrTargetReadOnly is returned when changing a target the config file
+// manages in read-only mode.
+var ErrTargetReadOnly = errors.New("target is managed by the config file and read-only")
+
 func (t Target) ReposList() []string {
-	if strings.TrimSpace(t.ReposCSV) == "" {
+	return splitCSV(t.ReposCSV)
+}
+
+// splitCSV splits a comma separated column into its trimmed, non-empty
+// values; nil for an empty column.
+func splitCSV(s string) []string {
+	if strings.TrimSpace(s) == "" {
 		return nil
 	}
-	parts := strings.Split(t.ReposCSV, ",")
+	parts := strings.Split(s, ",")
 	out := make([]string, 0, len(parts))
 	for _, p := range parts {
 		p = strings.TrimSpace(p)
@@ -33,10 +46,37 @@ func (t Target) ReposList() []string {
 	return out
 }
 
+// Validate checks the fields a caller controls before UpsertTarget.
+func (t Target) Validate() error {
+	if strings.TrimSpace(t.Name) == "" {
+		return errors.New("name is required")
+	}
+	if t.Mode != "user" && t.Mode != "repos" {
+		return errors.New("mode must be 'user' or 'repos'")
+	}
+	if strings.TrimSpace(t.Namespace) == "" {
+		return errors.New("namespace is required")
+	}
+	if t.Mode == "repos" && len(t.ReposList()) == 0 {
+		return errors.New("repos_csv is required for repos mode")
+	}
+	if t.IntervalSeconds < 0 {
+		return errors.New("interval_seconds must not be negative")
+	}
+	return nil
+}
+
 func ListTargets(db *sql.DB) ([]Target, error) {
-	rows, err := db.Query(`SELECT id, name, mode, namespace, COALESCE(repos_csv,''), interval_seconds, enabled,
-		COALESCE(last_run_ts_utc,''), COALESCE(last_error,'')
-		FROM targets ORDER BY id DESC`)
+	return QueryTargets(db, ListOpts{})
+}
+
+// QueryTargets returns targets newest first. Only Limit and Offset of opts
+// apply.
+func QueryTargets(db *sql.DB, opts ListOpts) ([]Target, error) {
+	q, args := appendLimit(`SELECT id, name, mode, namespace, COALESCE(repos_csv,''), interval_seconds, enabled,
+		COALESCE(last_run_ts_utc,''), COALESCE(last_error,''), from_file, read_only
+		FROM targets ORDER BY id DESC`, nil, opts)
+	rows, err := db.Query(q, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -45,11 +85,14 @@ func ListTargets(db *sql.DB) ([]Target, error) {
 	var out []Target
 	for rows.Next() {
 		var t Target
-		var enabled int
-		if err := rows.Scan(&t.ID, &t.Name, &t.Mode, &t.Namespace, &t.ReposCSV, &t.IntervalSeconds, &enabled, &t.LastRunUTC, &t.LastError); err != nil {
+		var enabled, fromFile, readOnly int
+		if err := rows.Scan(&t.ID, &t.Name, &t.Mode, &t.Namespace, &t.ReposCSV, &t.IntervalSeconds, &enabled,
+			&t.LastRunUTC, &t.LastError, &fromFile, &readOnly); err != nil {
 			return nil, err
 		}
 		t.Enabled = enabled == 1
+		t.FromFile = fromFile == 1
+		t.ReadOnly = readOnly == 1
 		out = append(out, t)
 	}
 	return out, nil
@@ -57,15 +100,18 @@ func ListTargets(db *sql.DB) ([]Target, error) {
 
 func GetTarget(db *sql.DB, id int64) (Target, error) {
 	var t Target
-	var enabled int
+	var enabled, fromFile, readOnly int
 	err := db.QueryRow(`SELECT id, name, mode, namespace, COALESCE(repos_csv,''), interval_seconds, enabled,
-		COALESCE(last_run_ts_utc,''), COALESCE(last_error,'')
+		COALESCE(last_run_ts_utc,''), COALESCE(last_error,''), from_file, read_only
 		FROM targets WHERE id=?`, id).
-		Scan(&t.ID, &t.Name, &t.Mode, &t.Namespace, &t.ReposCSV, &t.IntervalSeconds, &enabled, &t.LastRunUTC, &t.LastError)
+		Scan(&t.ID, &t.Name, &t.Mode, &t.Namespace, &t.ReposCSV, &t.IntervalSeconds, &enabled,
+			&t.LastRunUTC, &t.LastError, &fromFile, &readOnly)
 	if err != nil {
 		return Target{}, err
 	}
 	t.Enabled = enabled == 1
+	t.FromFile = fromFile == 1
+	t.ReadOnly = readOnly == 1
 	return t, nil
 }
 
@@ -82,6 +128,13 @@ func UpsertTarget(db *sql.DB, t Target) (int64, error) {
 		}
 		return res.LastInsertId()
 	}
+	var readOnly int
+	if err := db.QueryRow(`SELECT read_only FROM targets WHERE id=?`, t.ID).Scan(&readOnly); err != nil {
+		return 0, err
+	}
+	if readOnly == 1 {
+		return 0, ErrTargetReadOnly
+	}
 	_, err := db.Exec(`UPDATE targets SET name=?, mode=?, namespace=?, repos_csv=?, interval_seconds=?, enabled=? WHERE id=?`,
 		t.Name, t.Mode, t.Namespace, nullIfEmpty(t.ReposCSV), t.IntervalSeconds, boolToInt(t.Enabled), t.ID)
 	if err != nil {
@@ -90,6 +143,108 @@ func UpsertTarget(db *sql.DB, t Target) (int64, error) {
 	return t.ID, nil
 }
 
+// Tracks reports whether r is polled by this target.
+func (t Target) Tracks(r Repo) bool {
+	if r.Namespace != t.Namespace {
+		return false
+	}
+	if t.Mode == "user" {
+		return true
+	}
+	for _, name := range t.ReposList() {
+		if name == r.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteTarget removes a target. With purgeRepos it also deletes the repos
+// (and, by cascade, their snapshots and deltas) that no remaining target
+// tracks. Returns the number of purged repos, or sql.ErrNoRows if the target
+// did not exist. Read-only targets return ErrTargetReadOnly.
+func DeleteTarget(db *sql.DB, id int64, purgeRepos bool) (int, error) {
+	t, err := GetTarget(db, id)
+	if err != nil {
+		return 0, err
+	}
+	if t.ReadOnly {
+		return 0, ErrTargetReadOnly
+	}
+
+	var orphans []int64
+	if purgeRepos {
+		targets, err := ListTargets(db)
+		if err != nil {
+			return 0, err
+		}
+		repos, err := ListKnownRepos(db)
+		if err != nil {
+			return 0, err
+		}
+		for _, r := range repos {
+			if !t.Tracks(r) {
+				continue
+			}
+			shared := false
+			for _, other := range targets {
+				if other.ID != t.ID && other.Tracks(r) {
+					shared = true
+					break
+				}
+			}
+			if !shared {
+				orphans = append(orphans, r.ID)
+			}
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM targets WHERE id=?`, id); err != nil {
+		return 0, err
+	}
+	for _, repoID := range orphans {
+		if _, err := tx.Exec(`DELETE FROM repos WHERE id=?`, repoID); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(orphans), nil
+}
+
+// SetTargetsEnabled enables or disables all given targets and returns how
+// many rows changed. Nothing changes if any of them is read-only.
+func SetTargetsEnabled(db *sql.DB, ids []int64, enabled bool) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	args := make([]any, 0, len(ids)+1)
+	args = append(args, boolToInt(enabled))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	var locked int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM targets WHERE read_only=1 AND id IN (`+placeholders+`)`, args[1:]...).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if locked > 0 {
+		return 0, ErrTargetReadOnly
+	}
+	res, err := db.Exec(`UPDATE targets SET enabled=? WHERE id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 func UpdateTargetRun(db *sql.DB, id int64, runAtUTC string, errStr string) {
 	_, _ = db.Exec(`UPDATE targets SET last_run_ts_utc=?, last_error=? WHERE id=?`, runAtUTC, nullIfEmpty(errStr), id)
 }
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeleteTargetPurgesOrphanRepos(t *testing.T) {
+	dbx := openTestDB(t)
+	user, err := UpsertTarget(dbx, Target{Name: "ns", Mode: "user", Namespace: "ns", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A disabled target still keeps its repos.
+	if _, err := UpsertTarget(dbx, Target{Name: "b", Mode: "repos", Namespace: "ns", ReposCSV: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	other, err := UpsertTarget(dbx, Target{Name: "other", Mode: "user", Namespace: "other", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]int64{}
+	for _, name := range []string{"ns/a", "ns/b", "other/x"} {
+		ns, repo, _ := strings.Cut(name, "/")
+		id, err := EnsureRepo(dbx, ns, repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = id
+		addSnapshot(t, dbx, id, t0, 1)
+		addSnapshot(t, dbx, id, t0.Add(time.Hour), 2)
+	}
+
+	// Without purge the repos stay.
+	if n, err := DeleteTarget(dbx, other, false); err != nil || n != 0 {
+		t.Fatalf("DeleteTarget(other) = %d, %v", n, err)
+	}
+	n, err := DeleteTarget(dbx, user, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("purged %d repos, want only ns/a", n)
+	}
+	var names []string
+	repos, err := ListKnownRepos(dbx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range repos {
+		names = append(names, r.Namespace+"/"+r.Name)
+	}
+	slices.Sort(names)
+	if want := []string{"ns/b", "other/x"}; !slices.Equal(names, want) {
+		t.Errorf("repos = %v, want %v", names, want)
+	}
+	for name, id := range ids {
+		var snaps, deltas int
+		if err := dbx.QueryRow(`SELECT (SELECT COUNT(*) FROM repo_snapshots WHERE repo_id=?), (SELECT COUNT(*) FROM repo_deltas WHERE repo_id=?)`,
+			id, id).Scan(&snaps, &deltas); err != nil {
+			t.Fatal(err)
+		}
+		want := 2
+		if name == "ns/a" {
+			want = 0
+		}
+		if snaps != want || deltas != want/2 {
+			t.Errorf("%s: %d snapshots, %d deltas left", name, snaps, deltas)
+		}
+	}
+
+	if _, err := DeleteTarget(dbx, user, true); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting again: %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestDeleteReadOnlyTargetKeepsRepos(t *testing.T) {
+	dbx := openTestDB(t)
+	if _, err := ReconcileTargets(dbx, []Target{fileTarget("ns")}, true); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := ListTargets(dbx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnsureRepo(dbx, "ns", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DeleteTarget(dbx, targets[0].ID, true); !errors.Is(err, ErrTargetReadOnly) {
+		t.Fatalf("DeleteTarget: %v, want ErrTargetReadOnly", err)
+	}
+	if _, err := GetTarget(dbx, targets[0].ID); err != nil {
+		t.Errorf("target gone after a rejected delete: %v", err)
+	}
+	if _, err := FindRepo(dbx, "ns", "a"); err != nil {
+		t.Errorf("repo purged by a rejected delete: %v", err)
+	}
+}
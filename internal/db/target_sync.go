@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ReconcileResult counts what ReconcileTargets changed.
+type ReconcileResult struct {
+	Added     int
+	Updated   int
+	Adopted   int // existing UI targets the file now manages
+	Removed   int
+	Unchanged int
+}
+
+func (r ReconcileResult) Summary() string {
+	return fmt.Sprintf("%d added, %d updated, %d adopted, %d removed, %d unchanged",
+		r.Added, r.Updated, r.Adopted, r.Removed, r.Unchanged)
+}
+
+// ReconcileTargets makes the config file targets the desired state of the
+// targets table. File targets are matched by the name they have in the file
+// (file_key), so a rename in the UI is reverted rather than treated as a new
+// target. Matching targets are updated in place, so their runs and schedule
+// survive; a target created in the UI under the same name is adopted. File
+// targets that are no longer listed are deleted, keeping their repos and
+// snapshots. Targets created elsewhere are left alone. With readOnly the file
+// targets are locked against UI, API and CLI edits.
+func ReconcileTargets(dbx *sql.DB, want []Target, readOnly bool) (ReconcileResult, error) {
+	var res ReconcileResult
+	have, err := ListTargets(dbx)
+	if err != nil {
+		return res, err
+	}
+	keys, err := targetFileKeys(dbx)
+	if err != nil {
+		return res, err
+	}
+
+	byKey := map[string]Target{}  // file targets
+	byName := map[string]Target{} // other targets, for adoption
+	for _, t := range have {
+		if t.FromFile {
+			byKey[keys[t.ID]] = t
+			continue
+		}
+		// ListTargets is newest first; the oldest wins.
+		byName[t.Name] = t
+	}
+
+	tx, err := dbx.Begin()
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	kept := map[int64]bool{}
+	for _, t := range want {
+		if t.IntervalSeconds <= 0 {
+			t.IntervalSeconds = int64((15 * time.Minute).Seconds())
+		}
+		cur, ok := byKey[t.Name]
+		if !ok {
+			cur, ok = byName[t.Name]
+		}
+		if !ok {
+			if _, err := tx.Exec(`INSERT INTO targets(name, mode, namespace, repos_csv, interval_seconds, enabled, from_file, read_only, file_key)
+				VALUES(?, ?, ?, ?, ?, ?, 1, ?, ?)`,
+				t.Name, t.Mode, t.Namespace, nullIfEmpty(t.ReposCSV), t.IntervalSeconds, boolToInt(t.Enabled), boolToInt(readOnly), t.Name); err != nil {
+				return res, fmt.Errorf("target %q: %w", t.Name, err)
+			}
+			res.Added++
+			continue
+		}
+		kept[cur.ID] = true
+
+		switch {
+		case !cur.FromFile:
+			res.Adopted++
+		case cur.Name != t.Name || cur.Mode != t.Mode || cur.Namespace != t.Namespace || cur.ReposCSV != t.ReposCSV ||
+			cur.IntervalSeconds != t.IntervalSeconds || cur.Enabled != t.Enabled || cur.ReadOnly != readOnly:
+			res.Updated++
+		default:
+			res.Unchanged++
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE targets SET name=?, mode=?, namespace=?, repos_csv=?, interval_seconds=?, enabled=?, from_file=1, read_only=?, file_key=? WHERE id=?`,
+			t.Name, t.Mode, t.Namespace, nullIfEmpty(t.ReposCSV), t.IntervalSeconds, boolToInt(t.Enabled), boolToInt(readOnly), t.Name, cur.ID); err != nil {
+			return res, fmt.Errorf("target %q: %w", t.Name, err)
+		}
+	}
+
+	for _, t := range have {
+		if !t.FromFile || kept[t.ID] {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM targets WHERE id=?`, t.ID); err != nil {
+			return res, fmt.Errorf("target %q: %w", t.Name, err)
+		}
+		res.Removed++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// targetFileKeys maps the IDs of file targets to their name in the file.
+func targetFileKeys(dbx *sql.DB) (map[int64]string, error) {
+	rows, err := dbx.Query(`SELECT id, COALESCE(file_key, name) FROM targets WHERE from_file=1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			return nil, err
+		}
+		out[id] = key
+	}
+	return out, rows.Err()
+}
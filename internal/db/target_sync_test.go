@@ -0,0 +1,122 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func fileTarget(name string) Target {
+	return Target{Name: name, Mode: "user", Namespace: name, IntervalSeconds: 3600, Enabled: true}
+}
+
+func TestReconcileTargets(t *testing.T) {
+	dbx := openTestDB(t)
+	uiID, err := UpsertTarget(dbx, Target{Name: "b", Mode: "user", Namespace: "old", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := UpsertTarget(dbx, Target{Name: "mine", Mode: "user", Namespace: "mine", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ReconcileTargets(dbx, []Target{fileTarget("a"), fileTarget("b")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Added != 1 || res.Adopted != 1 {
+		t.Fatalf("first reconcile = %+v, want 1 added, 1 adopted", res)
+	}
+	b, err := GetTarget(dbx, uiID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.FromFile || b.Namespace != "b" {
+		t.Errorf("adopted target = %+v, want the file's settings", b)
+	}
+
+	res, err = ReconcileTargets(dbx, []Target{fileTarget("a")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Removed != 1 || res.Unchanged != 1 {
+		t.Fatalf("second reconcile = %+v, want 1 removed, 1 unchanged", res)
+	}
+	if _, err := GetTarget(dbx, uiID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("dropped file target still exists: %v", err)
+	}
+	if _, err := GetTarget(dbx, otherID); err != nil {
+		t.Errorf("UI target was touched: %v", err)
+	}
+}
+
+func TestReconcileRevertsUIRename(t *testing.T) {
+	dbx := openTestDB(t)
+	want := []Target{fileTarget("a")}
+	if _, err := ReconcileTargets(dbx, want, false); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := ListTargets(dbx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := targets[0]
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := InsertTargetRun(dbx, TargetRun{TargetID: a.ID, Trigger: TriggerManual, StartedUTC: now, FinishedUTC: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Name = "renamed"
+	if _, err := UpsertTarget(dbx, a); err != nil {
+		t.Fatal(err)
+	}
+	res, err := ReconcileTargets(dbx, want, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Updated != 1 || res.Added != 0 || res.Removed != 0 {
+		t.Fatalf("reconcile = %+v, want the renamed target updated", res)
+	}
+	got, err := GetTarget(dbx, a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "a" {
+		t.Errorf("name = %q, want the file's %q back", got.Name, "a")
+	}
+	runs, err := QueryTargetRuns(dbx, a.ID, ListOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 {
+		t.Errorf("%d runs left, want the run history kept", len(runs))
+	}
+}
+
+func TestReadOnlyTargets(t *testing.T) {
+	dbx := openTestDB(t)
+	if _, err := ReconcileTargets(dbx, []Target{fileTarget("a")}, true); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := ListTargets(dbx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := targets[0]
+	if !a.ReadOnly {
+		t.Fatalf("target = %+v, want read-only", a)
+	}
+
+	a.Name = "renamed"
+	if _, err := UpsertTarget(dbx, a); !errors.Is(err, ErrTargetReadOnly) {
+		t.Errorf("UpsertTarget: %v, want ErrTargetReadOnly", err)
+	}
+	if _, err := DeleteTarget(dbx, a.ID, false); !errors.Is(err, ErrTargetReadOnly) {
+		t.Errorf("DeleteTarget: %v, want ErrTargetReadOnly", err)
+	}
+	if _, err := SetTargetsEnabled(dbx, []int64{a.ID}, false); !errors.Is(err, ErrTargetReadOnly) {
+		t.Errorf("SetTargetsEnabled: %v, want ErrTargetReadOnly", err)
+	}
+}
@@ -0,0 +1,267 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Delivery states.
+const (
+	DeliveryPending = "pending"
+	DeliveryOK      = "ok"
+	DeliveryFailed  = "failed"
+)
+
+// Webhook payload formats.
+const (
+	FormatJSON    = "json" // the event itself, signed
+	FormatSlack   = "slack"
+	FormatDiscord = "discord"
+	FormatTeams   = "teams"
+	FormatMatrix  = "matrix"
+)
+
+// WebhookFormats lists the payload formats with a short description each,
+// in display order.
+var WebhookFormats = []struct {
+	Format      string
+	Description string
+}{
+	{FormatJSON, "Generic JSON event (signed with the secret)"},
+	{FormatSlack, "Slack incoming webhook"},
+	{FormatDiscord, "Discord webhook"},
+	{FormatTeams, "Microsoft Teams (Workflows webhook, Adaptive Card)"},
+	{FormatMatrix, "Matrix room (send endpoint; secret = access token)"},
+}
+
+// keepDeliveriesPerWebhook bounds the delivery log of each webhook.
+const keepDeliveriesPerWebhook = 1000
+
+type Webhook struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Secret    string `json:"-"`
+	HasSecret bool   `json:"has_secret"`
+	EventsCSV string `json:"events_csv"` // empty = all events
+	Format    string `json:"format"`
+	Enabled   bool   `json:"enabled"`
+}
+
+// EventList returns the subscribed event types; nil means all.
+func (w Webhook) EventList() []string {
+	return splitCSV(w.EventsCSV)
+}
+
+// Wants reports whether the webhook subscribes to events of type typ.
+func (w Webhook) Wants(typ string) bool {
+	list := w.EventList()
+	if len(list) == 0 {
+		return true
+	}
+	for _, t := range list {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the fields a caller controls before UpsertWebhook.
+func (w Webhook) Validate() error {
+	if strings.TrimSpace(w.Name) == "" {
+		return errors.New("name is required")
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http(s) URL")
+	}
+	known := false
+	for _, f := range WebhookFormats {
+		known = known || f.Format == w.Format
+	}
+	if !known {
+		return errors.New("unknown format " + strconv.Quote(w.Format))
+	}
+	if w.Format == FormatMatrix && w.Secret == "" {
+		return errors.New("matrix webhooks need the room's access token as secret")
+	}
+	return nil
+}
+
+const webhookCols = `id, name, url, COALESCE(secret,''), COALESCE(events_csv,''), format, enabled`
+
+func scanWebhook(sc interface{ Scan(...any) error }) (Webhook, error) {
+	var w Webhook
+	var enabled int
+	if err := sc.Scan(&w.ID, &w.Name, &w.URL, &w.Secret, &w.EventsCSV, &w.Format, &enabled); err != nil {
+		return Webhook{}, err
+	}
+	w.Enabled = enabled == 1
+	w.HasSecret = w.Secret != ""
+	return w, nil
+}
+
+func ListWebhooks(dbx *sql.DB) ([]Webhook, error) {
+	rows, err := dbx.Query(`SELECT ` + webhookCols + ` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+func GetWebhook(dbx *sql.DB, id int64) (Webhook, error) {
+	return scanWebhook(dbx.QueryRow(`SELECT `+webhookCols+` FROM webhooks WHERE id=?`, id))
+}
+
+func UpsertWebhook(dbx *sql.DB, w Webhook) (int64, error) {
+	if w.ID == 0 {
+		res, err := dbx.Exec(`INSERT INTO webhooks(name, url, secret, events_csv, format, enabled) VALUES(?, ?, ?, ?, ?, ?)`,
+			w.Name, w.URL, nullIfEmpty(w.Secret), nullIfEmpty(w.EventsCSV), w.Format, boolToInt(w.Enabled))
+		if err != nil {
+			return 0, err
+		}
+		return res.LastInsertId()
+	}
+	_, err := dbx.Exec(`UPDATE webhooks SET name=?, url=?, secret=?, events_csv=?, format=?, enabled=? WHERE id=?`,
+		w.Name, w.URL, nullIfEmpty(w.Secret), nullIfEmpty(w.EventsCSV), w.Format, boolToInt(w.Enabled), w.ID)
+	return w.ID, err
+}
+
+// DeleteWebhook removes a webhook and its delivery log.
+func DeleteWebhook(dbx *sql.DB, id int64) error {
+	res, err := dbx.Exec(`DELETE FROM webhooks WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+type WebhookDelivery struct {
+	ID            int64  `json:"id"`
+	WebhookID     int64  `json:"webhook_id"`
+	EventID       string `json:"event_id"`
+	EventType     string `json:"event_type"`
+	Payload       string `json:"payload"`
+	Status        string `json:"status"` // pending|ok|failed
+	Attempts      int    `json:"attempts"`
+	NextAttemptTS string `json:"next_attempt_ts_utc,omitempty"`
+	ResponseCode  int    `json:"response_code,omitempty"`
+	Error         string `json:"error,omitempty"`
+	CreatedUTC    string `json:"created_ts_utc"`
+	FinishedUTC   string `json:"finished_ts_utc,omitempty"`
+}
+
+// EnqueueWebhookDeliveries queues payload for every enabled webhook that
+// subscribes to eventType and returns how many were queued. A non-zero
+// webhookID restricts delivery to that webhook, regardless of its
+// subscriptions (used for test pings).
+func EnqueueWebhookDeliveries(dbx *sql.DB, webhookID int64, eventID, eventType, payload string) (int, error) {
+	hooks, err := ListWebhooks(dbx)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := dbx.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	n := 0
+	for _, w := range hooks {
+		if webhookID != 0 {
+			if w.ID != webhookID {
+				continue
+			}
+		} else if !w.Enabled || !w.Wants(eventType) {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO webhook_deliveries(webhook_id, event_id, event_type, payload, status, next_attempt_ts_utc, created_ts_utc)
+			VALUES(?, ?, ?, ?, ?, ?, ?)`, w.ID, eventID, eventType, payload, DeliveryPending, now, now); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id=? AND id NOT IN (
+				SELECT id FROM webhook_deliveries WHERE webhook_id=? ORDER BY id DESC LIMIT ?)`,
+			w.ID, w.ID, keepDeliveriesPerWebhook); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if webhookID != 0 && n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return n, tx.Commit()
+}
+
+const deliveryCols = `id, webhook_id, event_id, event_type, payload, status, attempts, COALESCE(next_attempt_ts_utc,''),
+	COALESCE(response_code,0), COALESCE(error,''), created_ts_utc, COALESCE(finished_ts_utc,'')`
+
+func scanDeliveries(rows *sql.Rows) ([]WebhookDelivery, error) {
+	defer rows.Close()
+	out := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptTS,
+			&d.ResponseCode, &d.Error, &d.CreatedUTC, &d.FinishedUTC); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// DueWebhookDeliveries returns pending deliveries whose next attempt is due,
+// oldest first.
+func DueWebhookDeliveries(dbx *sql.DB, now time.Time, limit int) ([]WebhookDelivery, error) {
+	rows, err := dbx.Query(`SELECT `+deliveryCols+` FROM webhook_deliveries
+		WHERE status=? AND next_attempt_ts_utc <= ? ORDER BY id LIMIT ?`,
+		DeliveryPending, now.UTC().Format(time.RFC3339), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+// QueryWebhookDeliveries returns a webhook's delivery log newest first,
+// filtered by created_ts_utc.
+func QueryWebhookDeliveries(dbx *sql.DB, webhookID int64, opts ListOpts) ([]WebhookDelivery, error) {
+	q := `SELECT ` + deliveryCols + ` FROM webhook_deliveries WHERE webhook_id=?`
+	args := []any{webhookID}
+	q, args = appendRange(q, args, "created_ts_utc", opts)
+	q += ` ORDER BY id DESC`
+	q, args = appendLimit(q, args, opts)
+
+	rows, err := dbx.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt. d.Status,
+// d.Attempts, d.NextAttemptTS, d.ResponseCode, d.Error and d.FinishedUTC are
+// written.
+func UpdateWebhookDelivery(dbx *sql.DB, d WebhookDelivery) error {
+	_, err := dbx.Exec(`UPDATE webhook_deliveries SET status=?, attempts=?, next_attempt_ts_utc=?, response_code=?, error=?, finished_ts_utc=?
+		WHERE id=?`,
+		d.Status, d.Attempts, nullIfEmpty(d.NextAttemptTS), d.ResponseCode, nullIfEmpty(d.Error), nullIfEmpty(d.FinishedUTC), d.ID)
+	return err
+}
@@ -3,27 +3,49 @@ package dockerhub
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
+// DefaultBaseURL is the public Docker Hub API host.
+const DefaultBaseURL = "https://hub.docker.com"
+
 type ClientConfig struct {
+	BaseURL     string // defaults to DefaultBaseURL
 	HTTPTimeout time.Duration
 	UserAgent   string
 	Token       string // optional bearer token
+	Retry       RetryPolicy
+
+	// RequestsPerMinute is the budget shared by all callers; 0 = unlimited.
+	// Hub's X-RateLimit-* headers are honored either way.
+	RequestsPerMinute int
 }
 
 type Client struct {
-	cfg ClientConfig
-	hc  *http.Client
+	cfg     ClientConfig
+	hc      *http.Client
+	limiter *limiter
+	stats   stats
 }
 
 func NewClient(cfg ClientConfig) *Client {
+	cfg.BaseURL = strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultBaseURL
+	}
+	cfg.Retry = cfg.Retry.withDefaults()
 	return &Client{
-		cfg: cfg,
-		hc:  &http.Client{Timeout: cfg.HTTPTimeout},
+		cfg:     cfg,
+		hc:      &http.Client{Timeout: cfg.HTTPTimeout},
+		limiter: newLimiter(cfg.RequestsPerMinute),
+		stats:   stats{s: Stats{QuotaRemaining: -1}, byStatus: map[int]int64{}},
 	}
 }
 
@@ -36,30 +58,25 @@ type RepoInfo struct {
 	IsPrivate   bool   `json:"is_private"`
 }
 
-func (c *Client) GetRepo(ctx context.Context, namespace, repo string) (RepoInfo, string, error) {
-	url := fmt.Sprintf("https://hub.docker.com/v2/repositories/%s/%s/", namespace, repo)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return RepoInfo{}, "", err
-	}
-	req.Header.Set("User-Agent", c.cfg.UserAgent)
-	if c.cfg.Token != "" {
-		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
-	}
+// StatusError is returned for non-200 responses that were not retried or
+// still failed after the last attempt.
+type StatusError struct {
+	Code int
+	Body string
+}
 
-	resp, err := c.hc.Do(req)
-	if err != nil {
-		return RepoInfo{}, "", err
+func (e *StatusError) Error() string {
+	if e.Code == http.StatusTooManyRequests {
+		return "docker hub rate limited (429)"
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
+	return fmt.Sprintf("docker hub status %d", e.Code)
+}
 
-	if resp.StatusCode == 429 {
-		return RepoInfo{}, string(body), fmt.Errorf("docker hub rate limited (429)")
-	}
-	if resp.StatusCode != 200 {
-		return RepoInfo{}, string(body), fmt.Errorf("docker hub status %d", resp.StatusCode)
+func (c *Client) GetRepo(ctx context.Context, namespace, repo string) (RepoInfo, string, error) {
+	u := fmt.Sprintf("%s/v2/repositories/%s/%s/", c.cfg.BaseURL, url.PathEscape(namespace), url.PathEscape(repo))
+	body, err := c.get(ctx, u)
+	if err != nil {
+		return RepoInfo{}, string(body), err
 	}
 
 	var info RepoInfo
@@ -71,32 +88,14 @@ func (c *Client) GetRepo(ctx context.Context, namespace, repo string) (RepoInfo,
 
 func (c *Client) ListRepos(ctx context.Context, namespace string) ([]string, error) {
 	// paginated
-	url := fmt.Sprintf("https://hub.docker.com/v2/repositories/%s/?page_size=100", namespace)
+	u := fmt.Sprintf("%s/v2/repositories/%s/?page_size=100", c.cfg.BaseURL, url.PathEscape(namespace))
 	var out []string
 
-	for url != "" {
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-		if err != nil {
-			return nil, err
-		}
-		req.Header.Set("User-Agent", c.cfg.UserAgent)
-		if c.cfg.Token != "" {
-			req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
-		}
-
-		resp, err := c.hc.Do(req)
+	for u != "" {
+		body, err := c.get(ctx, u)
 		if err != nil {
 			return nil, err
 		}
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-
-		if resp.StatusCode == 429 {
-			return nil, fmt.Errorf("docker hub rate limited (429)")
-		}
-		if resp.StatusCode != 200 {
-			return nil, fmt.Errorf("docker hub status %d", resp.StatusCode)
-		}
 
 		var parsed struct {
 			Next    *string `json:"next"`
@@ -113,10 +112,94 @@ func (c *Client) ListRepos(ctx context.Context, namespace string) ([]string, err
 			}
 		}
 		if parsed.Next == nil || *parsed.Next == "" {
-			url = ""
+			u = ""
 		} else {
-			url = *parsed.Next
+			u = *parsed.Next
 		}
 	}
 	return out, nil
-}
\ No newline at end of file
+}
+
+// get performs a GET with the client's retry policy and returns the body of
+// the 200 response. On failure the last response body (if any) is returned
+// alongside the error.
+func (c *Client) get(ctx context.Context, u string) ([]byte, error) {
+	policy := c.cfg.Retry
+	for attempt := 1; ; attempt++ {
+		body, hdr, err := c.do(ctx, u)
+		if err == nil {
+			c.stats.success()
+			return body, nil
+		}
+
+		if cancelled(ctx, err) {
+			// Cancelled or timed out: the error is ours, not Hub's, so it
+			// is neither retried nor counted against Hub.
+			return body, err
+		}
+
+		wait, retry := policy.next(attempt, hdr, err)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			retry = false
+		}
+		if !retry {
+			c.stats.failure(err)
+			return body, err
+		}
+
+		c.stats.retry()
+		log.Printf("dockerhub: GET %s: attempt %d/%d failed: %v; retrying in %s",
+			u, attempt, policy.MaxAttempts, err, wait.Round(time.Millisecond))
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return body, err
+		case <-t.C:
+		}
+	}
+}
+
+// cancelled reports whether err comes from ctx being cancelled or running out
+// of time rather than from Hub. Timeouts of the HTTP client itself still
+// count as Hub trouble.
+func cancelled(ctx context.Context, err error) bool {
+	return ctx.Err() != nil || errors.Is(err, context.Canceled)
+}
+
+// do waits for the rate limiter and performs a single request attempt.
+func (c *Client) do(ctx context.Context, u string) ([]byte, http.Header, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", c.cfg.UserAgent)
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+
+	resp, err := c.hc.Do(req)
+	c.stats.request()
+	if err != nil {
+		if !cancelled(ctx, err) {
+			c.stats.networkError()
+		}
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	c.stats.status(resp.StatusCode)
+	c.stats.quota(resp.Header)
+	c.limiter.Observe(resp.Header, time.Now())
+
+	if resp.StatusCode != 200 {
+		return body, resp.Header, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	return body, resp.Header, nil
+}
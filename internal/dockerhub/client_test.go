@@ -0,0 +1,210 @@
+package dockerhub_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"dockerhub-pull-watcher/internal/dockerhub"
+	"dockerhub-pull-watcher/internal/dockerhub/dockerhubtest"
+)
+
+// newClient returns a client for hub that retries quickly.
+func newClient(hub *dockerhubtest.Server, maxDelay time.Duration) *dockerhub.Client {
+	return dockerhub.NewClient(dockerhub.ClientConfig{
+		BaseURL:     hub.URL(),
+		HTTPTimeout: 5 * time.Second,
+		Retry: dockerhub.RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+			MaxDelay:    maxDelay,
+		},
+	})
+}
+
+func TestGetRepo(t *testing.T) {
+	hub := dockerhubtest.NewServer()
+	defer hub.Close()
+	hub.AddRepo("floibach", "pullpulse", dockerhubtest.Repo{PullCounts: []int64{10, 42}, StarCount: 3})
+	c := newClient(hub, time.Second)
+
+	for _, want := range []int64{10, 42, 42} {
+		info, raw, err := c.GetRepo(context.Background(), "floibach", "pullpulse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.PullCount != want || info.StarCount != 3 || info.Namespace != "floibach" || info.Name != "pullpulse" {
+			t.Errorf("GetRepo = %+v, want pull_count %d", info, want)
+		}
+		if raw == "" {
+			t.Error("raw body is empty")
+		}
+	}
+}
+
+func TestListReposPaginates(t *testing.T) {
+	hub := dockerhubtest.NewServer()
+	defer hub.Close()
+	for _, name := range []string{"e", "c", "a", "d", "b"} {
+		hub.AddRepo("ns", name, dockerhubtest.Repo{})
+	}
+	hub.AddRepo("other", "x", dockerhubtest.Repo{})
+	hub.SetPageSize(2)
+	c := newClient(hub, time.Second)
+
+	names, err := c.ListRepos(context.Background(), "ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "c", "d", "e"}; !slices.Equal(names, want) {
+		t.Errorf("ListRepos = %v, want %v", names, want)
+	}
+	if n := hub.Requests(); n != 3 {
+		t.Errorf("requests = %d, want 3 pages", n)
+	}
+}
+
+func TestNotFoundIsNotRetried(t *testing.T) {
+	hub := dockerhubtest.NewServer()
+	defer hub.Close()
+	c := newClient(hub, time.Second)
+
+	_, _, err := c.GetRepo(context.Background(), "floibach", "missing")
+	var se *dockerhub.StatusError
+	if !errors.As(err, &se) || se.Code != http.StatusNotFound {
+		t.Fatalf("err = %v, want status 404", err)
+	}
+	if n := hub.Requests(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+	if st := c.Stats(); st.Retries != 0 || st.Failures != 1 {
+		t.Errorf("stats = %+v, want 0 retries, 1 failure", st)
+	}
+}
+
+func TestRetriesServerErrors(t *testing.T) {
+	hub := dockerhubtest.NewServer()
+	defer hub.Close()
+	hub.AddRepo("ns", "r", dockerhubtest.Repo{PullCounts: []int64{7}})
+	hub.Fail(dockerhubtest.Fault{Count: 2, Status: http.StatusBadGateway})
+	c := newClient(hub, time.Second)
+
+	info, _, err := c.GetRepo(context.Background(), "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PullCount != 7 {
+		t.Errorf("pull_count = %d, want 7", info.PullCount)
+	}
+	if n := hub.Requests(); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+	if st := c.Stats(); st.Retries != 2 || st.ByStatus[http.StatusBadGateway] != 2 {
+		t.Errorf("stats = %+v, want 2 retries of 502", st)
+	}
+}
+
+func TestGivesUpAfterMaxAttempts(t *testing.T) {
+	hub := dockerhubtest.NewServer()
+	defer hub.Close()
+	hub.AddRepo("ns", "r", dockerhubtest.Repo{})
+	hub.Fail(dockerhubtest.Fault{Count: 5, Status: http.StatusServiceUnavailable})
+	c := newClient(hub, time.Second)
+
+	_, _, err := c.GetRepo(context.Background(), "ns", "r")
+	var se *dockerhub.StatusError
+	if !errors.As(err, &se) || se.Code != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want status 503", err)
+	}
+	if n := hub.Requests(); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+}
+
+func TestZeroCountFaultIsIgnored(t *testing.T) {
+	hub := dockerhubtest.NewServer()
+	defer hub.Close()
+	hub.AddRepo("ns", "r", dockerhubtest.Repo{})
+	hub.Fail(dockerhubtest.Fault{Count: 0, Status: http.StatusInternalServerError})
+	c := newClient(hub, time.Second)
+
+	if _, _, err := c.GetRepo(context.Background(), "ns", "r"); err != nil {
+		t.Fatal(err)
+	}
+	if n := hub.Requests(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestRateLimitWaitsForRetryAfter(t *testing.T) {
+	hub := dockerhubtest.NewServer()
+	defer hub.Close()
+	hub.AddRepo("ns", "r", dockerhubtest.Repo{PullCounts: []int64{5}})
+	hub.RateLimit(1, time.Second)
+	c := newClient(hub, 2*time.Second)
+
+	start := time.Now()
+	if _, _, err := c.GetRepo(context.Background(), "ns", "r"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("retried after %s, want at least the 1s Retry-After", d)
+	}
+	if st := c.Stats(); st.RateLimited != 1 || st.Retries != 1 {
+		t.Errorf("stats = %+v, want 1 rate limited, 1 retry", st)
+	}
+}
+
+func TestRateLimitBeyondMaxDelayIsNotRetried(t *testing.T) {
+	hub := dockerhubtest.NewServer()
+	defer hub.Close()
+	hub.AddRepo("ns", "r", dockerhubtest.Repo{})
+	hub.RateLimit(1, time.Minute)
+	c := newClient(hub, time.Second)
+
+	_, _, err := c.GetRepo(context.Background(), "ns", "r")
+	var se *dockerhub.StatusError
+	if !errors.As(err, &se) || se.Code != http.StatusTooManyRequests {
+		t.Fatalf("err = %v, want status 429", err)
+	}
+	if n := hub.Requests(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestCancelledContextIsNotRetried(t *testing.T) {
+	hub := dockerhubtest.NewServer()
+	defer hub.Close()
+	hub.AddRepo("ns", "r", dockerhubtest.Repo{})
+	c := newClient(hub, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := c.GetRepo(ctx, "ns", "r")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if st := c.Stats(); st.Retries != 0 || st.Failures != 0 || st.ConsecutiveFailures != 0 || st.NetworkErrors != 0 {
+		t.Errorf("stats = %+v, want no retries and no Hub failures", st)
+	}
+}
+
+func TestCancelDuringBackoffIsNotAFailure(t *testing.T) {
+	hub := dockerhubtest.NewServer()
+	defer hub.Close()
+	hub.AddRepo("ns", "r", dockerhubtest.Repo{})
+	hub.RateLimit(1, time.Second)
+	c := newClient(hub, 2*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, _, err := c.GetRepo(ctx, "ns", "r"); err == nil {
+		t.Fatal("GetRepo succeeded after the context was cancelled")
+	}
+	if st := c.Stats(); st.Failures != 0 || st.ConsecutiveFailures != 0 || st.LastError != "" {
+		t.Errorf("stats = %+v, want no Hub failures", st)
+	}
+}
@@ -0,0 +1,255 @@
+// Package dockerhubtest provides an in-process fake of the Docker Hub
+// repositories API for hermetic tests.
+//
+//	hub := dockerhubtest.NewServer()
+//	defer hub.Close()
+//	hub.AddRepo("floibach", "pullpulse", dockerhubtest.Repo{PullCounts: []int64{10, 15, 42}})
+//	c := dockerhub.NewClient(dockerhub.ClientConfig{BaseURL: hub.URL()})
+package dockerhubtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Repo is the scripted state of one fake repository.
+type Repo struct {
+	// PullCounts are returned one per GET; the last value repeats.
+	PullCounts  []int64
+	StarCount   int64
+	LastUpdated string
+	IsPrivate   bool
+}
+
+// Fault makes the next Count requests fail with Status. Headers are added
+// to each failing response (e.g. Retry-After).
+type Fault struct {
+	Count   int
+	Status  int
+	Headers map[string]string
+}
+
+type Server struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	repos    map[string]*repoState // "namespace/name"
+	pageSize int
+	faults   []Fault
+	requests int
+
+	quotaLimit     int // 0 = no X-RateLimit headers
+	quotaRemaining int
+	quotaReset     time.Time
+}
+
+type repoState struct {
+	Repo
+	gets int
+}
+
+// NewServer starts a fake Hub. Close it when done.
+func NewServer() *Server {
+	s := &Server{repos: map[string]*repoState{}}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// URL is the base URL to put into dockerhub.ClientConfig.BaseURL.
+func (s *Server) URL() string { return s.srv.URL }
+
+func (s *Server) Close() { s.srv.Close() }
+
+// AddRepo registers or replaces a repository.
+func (s *Server) AddRepo(namespace, name string, r Repo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repos[namespace+"/"+name] = &repoState{Repo: r}
+}
+
+// RemoveRepo makes the repository answer 404 from now on.
+func (s *Server) RemoveRepo(namespace, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.repos, namespace+"/"+name)
+}
+
+// SetPageSize caps the page size of the list endpoint, regardless of the
+// page_size the client asks for. Zero means no cap.
+func (s *Server) SetPageSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pageSize = n
+}
+
+// Fail queues a fault; queued faults are consumed in order. A fault with
+// Count <= 0 fails nothing and is ignored.
+func (s *Server) Fail(f Fault) {
+	if f.Count <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, f)
+}
+
+// RateLimit makes the next n requests answer 429 with the given Retry-After.
+func (s *Server) RateLimit(n int, retryAfter time.Duration) {
+	s.Fail(Fault{
+		Count:   n,
+		Status:  http.StatusTooManyRequests,
+		Headers: map[string]string{"Retry-After": strconv.Itoa(int(retryAfter.Seconds()))},
+	})
+}
+
+// SetQuota makes every response carry X-RateLimit-Limit/-Remaining/-Reset
+// headers. Remaining counts down per request; at zero requests answer 429
+// until reset.
+func (s *Server) SetQuota(limit, remaining int, reset time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The header only carries whole seconds.
+	s.quotaLimit, s.quotaRemaining, s.quotaReset = limit, remaining, reset.Truncate(time.Second)
+}
+
+// Requests returns the number of requests served so far, including faults.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+
+	if s.quotaLimit > 0 {
+		if !time.Now().Before(s.quotaReset) {
+			s.quotaRemaining = s.quotaLimit
+		}
+		if s.quotaRemaining > 0 {
+			s.quotaRemaining--
+		} else {
+			s.setQuotaHeaders(w)
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		s.setQuotaHeaders(w)
+	}
+
+	if len(s.faults) > 0 {
+		f := &s.faults[0]
+		f.Count--
+		if f.Count <= 0 {
+			s.faults = s.faults[1:]
+		}
+		for k, v := range f.Headers {
+			w.Header().Set(k, v)
+		}
+		http.Error(w, http.StatusText(f.Status), f.Status)
+		return
+	}
+
+	// /v2/repositories/{namespace}/ or /v2/repositories/{namespace}/{name}/
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v2/repositories/"), "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, "/v2/repositories/"):
+		http.NotFound(w, r)
+	case len(parts) == 1 && parts[0] != "":
+		s.list(w, r, parts[0])
+	case len(parts) == 2:
+		s.get(w, r, parts[0], parts[1])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) setQuotaHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(s.quotaLimit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(s.quotaRemaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(s.quotaReset.Unix(), 10))
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, namespace, name string) {
+	st, ok := s.repos[namespace+"/"+name]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "object not found"})
+		return
+	}
+
+	var pulls int64
+	if n := len(st.PullCounts); n > 0 {
+		pulls = st.PullCounts[min(st.gets, n-1)]
+	}
+	st.gets++
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"namespace":    namespace,
+		"name":         name,
+		"pull_count":   pulls,
+		"star_count":   st.StarCount,
+		"last_updated": st.LastUpdated,
+		"is_private":   st.IsPrivate,
+	})
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, namespace string) {
+	var names []string
+	for key := range s.repos {
+		if ns, name, _ := strings.Cut(key, "/"); ns == namespace {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "object not found"})
+		return
+	}
+	sort.Strings(names)
+
+	size, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if size <= 0 {
+		size = 10
+	}
+	if s.pageSize > 0 && size > s.pageSize {
+		size = s.pageSize
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page <= 0 {
+		page = 1
+	}
+
+	start := min((page-1)*size, len(names))
+	end := min(start+size, len(names))
+
+	results := make([]map[string]string, 0, end-start)
+	for _, n := range names[start:end] {
+		results = append(results, map[string]string{"name": n, "namespace": namespace})
+	}
+
+	var next *string
+	if end < len(names) {
+		u := fmt.Sprintf("%s/v2/repositories/%s/?page=%d&page_size=%d", s.srv.URL, namespace, page+1, size)
+		next = &u
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"count":   len(names),
+		"next":    next,
+		"results": results,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
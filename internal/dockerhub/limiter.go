@@ -0,0 +1,243 @@
+package dockerhub
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type rateKey struct{}
+
+// WithRateKey tags requests made with ctx so the client's limiter can share
+// its budget fairly between keys (the watcher uses one key per target).
+func WithRateKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, rateKey{}, key)
+}
+
+func rateKeyFrom(ctx context.Context) string {
+	k, _ := ctx.Value(rateKey{}).(string)
+	return k
+}
+
+// lowQuotaShare is the fraction of Hub's X-RateLimit-Limit below which the
+// limiter starts spreading the remaining requests until the reset.
+const lowQuotaShare = 0.1
+
+// limiter is a token bucket shared by all requests of a client. Waiting
+// requests are granted round-robin by rate key, so one big target cannot
+// starve the others. On top of the configured budget it follows Hub's
+// X-RateLimit-* headers: when the quota is nearly used up the remaining
+// requests are spread until the reset, when it is exhausted it pauses.
+// The dispatcher goroutine only runs while requests are queued, so an idle
+// or abandoned client holds no goroutine.
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second; 0 = no configured budget
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	hubRate     float64 // spread rate derived from Hub headers; 0 = none
+	hubUntil    time.Time
+	pausedUntil time.Time
+
+	queues      map[string][]*waiter
+	order       []string // keys with waiters, in round-robin order
+	wake        chan struct{}
+	dispatching bool // a dispatch goroutine is running
+}
+
+type waiter struct {
+	ready chan struct{}
+}
+
+func newLimiter(requestsPerMinute int) *limiter {
+	l := &limiter{
+		queues: map[string][]*waiter{},
+		wake:   make(chan struct{}, 1),
+		last:   time.Now(),
+	}
+	if requestsPerMinute > 0 {
+		l.rate = float64(requestsPerMinute) / 60
+		// Allow a small burst so a few quick polls don't queue needlessly.
+		l.burst = max(1, float64(requestsPerMinute)/10)
+		l.tokens = l.burst
+	}
+	return l
+}
+
+// Wait blocks until the request may be sent or ctx is done.
+func (l *limiter) Wait(ctx context.Context) error {
+	key := rateKeyFrom(ctx)
+	w := &waiter{ready: make(chan struct{})}
+
+	l.mu.Lock()
+	if len(l.queues[key]) == 0 {
+		l.order = append(l.order, key)
+	}
+	l.queues[key] = append(l.queues[key], w)
+	if !l.dispatching {
+		l.dispatching = true
+		go l.dispatch()
+	}
+	l.mu.Unlock()
+	l.signal()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-w.ready:
+			// Granted concurrently; the token is spent either way.
+			return ctx.Err()
+		default:
+		}
+		l.remove(key, w)
+		l.signal() // let an idle dispatcher notice and exit
+		return ctx.Err()
+	}
+}
+
+// Observe adapts to Hub's rate limit headers of a response.
+func (l *limiter) Observe(hdr http.Header, now time.Time) {
+	remaining, ok := headerInt(hdr, "X-RateLimit-Remaining")
+	if !ok {
+		return
+	}
+	resetUnix, ok := headerInt(hdr, "X-RateLimit-Reset")
+	if !ok {
+		return
+	}
+	reset := time.Unix(resetUnix, 0)
+	if !reset.After(now) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if remaining <= 0 {
+		l.pausedUntil = reset
+		return
+	}
+
+	threshold := int64(20)
+	if limit, ok := headerInt(hdr, "X-RateLimit-Limit"); ok && limit > 0 {
+		threshold = max(1, int64(float64(limit)*lowQuotaShare))
+	}
+	if remaining < threshold {
+		l.hubRate = float64(remaining) / reset.Sub(now).Seconds()
+		l.hubUntil = reset
+	} else {
+		l.hubRate = 0
+	}
+}
+
+func (l *limiter) signal() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch hands out tokens to queued waiters, one key at a time, and
+// returns once no one is waiting; Wait starts it again.
+func (l *limiter) dispatch() {
+	for {
+		l.mu.Lock()
+		if len(l.order) == 0 {
+			l.dispatching = false
+			l.mu.Unlock()
+			return
+		}
+		wait := l.reserve(time.Now())
+		if wait > 0 {
+			l.mu.Unlock()
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-l.wake:
+				t.Stop()
+			}
+			continue
+		}
+
+		key := l.order[0]
+		w := l.queues[key][0]
+		l.queues[key] = l.queues[key][1:]
+		l.order = l.order[1:]
+		if len(l.queues[key]) > 0 {
+			l.order = append(l.order, key)
+		} else {
+			delete(l.queues, key)
+		}
+		close(w.ready)
+		l.mu.Unlock()
+	}
+}
+
+// reserve takes a token and returns 0, or returns how long to wait for the
+// next one. Callers hold l.mu.
+func (l *limiter) reserve(now time.Time) time.Duration {
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+
+	rate, burst := l.rate, l.burst
+	if l.hubRate > 0 && now.Before(l.hubUntil) {
+		if rate == 0 || l.hubRate < rate {
+			rate, burst = l.hubRate, 1
+		}
+	}
+	if rate == 0 {
+		l.last = now
+		return 0
+	}
+
+	l.tokens = min(burst, l.tokens+now.Sub(l.last).Seconds()*rate)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / rate * float64(time.Second))
+}
+
+// remove drops w from its key's queue. Callers hold l.mu.
+func (l *limiter) remove(key string, w *waiter) {
+	q := l.queues[key]
+	for i := range q {
+		if q[i] == w {
+			q = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	if len(q) > 0 {
+		l.queues[key] = q
+		return
+	}
+	delete(l.queues, key)
+	for i, k := range l.order {
+		if k == key {
+			l.order = append(l.order[:i], l.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// headerInt parses headers like "180" or "180;w=21600".
+func headerInt(hdr http.Header, name string) (int64, bool) {
+	v := strings.TrimSpace(hdr.Get(name))
+	if v == "" {
+		return 0, false
+	}
+	v, _, _ = strings.Cut(v, ";")
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	return n, err == nil
+}
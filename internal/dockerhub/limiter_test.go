@@ -0,0 +1,167 @@
+package dockerhub
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func (l *limiter) isDispatching() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dispatching
+}
+
+func TestNewClientStartsNoGoroutine(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for range 50 {
+		NewClient(ClientConfig{RequestsPerMinute: 60})
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("goroutines: %d before, %d after creating 50 clients", before, after)
+	}
+}
+
+func TestLimiterDispatcherExitsWhenIdle(t *testing.T) {
+	l := newLimiter(6000)
+	for range 3 {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for l.isDispatching() {
+		if time.Now().After(deadline) {
+			t.Fatal("dispatcher still running with an empty queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// And starts again for the next request.
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimiterSpacesRequests(t *testing.T) {
+	// 600/min with a burst of 60: the 61st and 62nd request wait ~100ms each.
+	l := newLimiter(600)
+	start := time.Now()
+	for range 62 {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("62 requests took %s, want about 200ms", d)
+	}
+}
+
+func TestLimiterWaitHonorsContext(t *testing.T) {
+	l := newLimiter(1) // burst 1, then one per minute
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for l.isDispatching() {
+		if time.Now().After(deadline) {
+			t.Fatal("dispatcher still running after the only waiter gave up")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// queued reports how many requests wait under key.
+func (l *limiter) queued(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.queues[key])
+}
+
+func TestLimiterAlternatesKeys(t *testing.T) {
+	l := newLimiter(6000) // one request per 10ms once the burst is gone
+	l.mu.Lock()
+	l.burst, l.tokens = 1, 0
+	l.pausedUntil = time.Now().Add(time.Hour) // hold grants until all are queued
+	l.mu.Unlock()
+
+	var mu sync.Mutex
+	var got []string
+	var wg sync.WaitGroup
+	enqueue := func(key string) {
+		n := l.queued(key)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.Wait(WithRateKey(context.Background(), key)); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			got = append(got, key)
+			mu.Unlock()
+		}()
+		for l.queued(key) == n {
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+	// A big target queues first, a small one behind it.
+	for range 4 {
+		enqueue("big")
+	}
+	for range 2 {
+		enqueue("small")
+	}
+	l.mu.Lock()
+	l.pausedUntil = time.Time{}
+	l.mu.Unlock()
+	l.signal()
+	wg.Wait()
+
+	if want := []string{"big", "small", "big", "small", "big", "big"}; !slices.Equal(got, want) {
+		t.Errorf("grant order = %v, want %v", got, want)
+	}
+}
+
+func TestLimiterSpreadsLowQuota(t *testing.T) {
+	quota := func(remaining int) http.Header {
+		hdr := http.Header{}
+		hdr.Set("X-RateLimit-Limit", "100")
+		hdr.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		hdr.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(2*time.Second).Unix(), 10))
+		return hdr
+	}
+	waitN := func(l *limiter, n int) time.Duration {
+		start := time.Now()
+		for range n {
+			if err := l.Wait(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return time.Since(start)
+	}
+
+	// Plenty of quota left: no configured budget, so no waiting.
+	l := newLimiter(0)
+	l.Observe(quota(50), time.Now())
+	if d := waitN(l, 3); d > 100*time.Millisecond {
+		t.Errorf("3 requests with 50 of 100 left took %s, want no wait", d)
+	}
+
+	// Below lowQuotaShare: 5 requests left for at most 2s, one per 200-400ms.
+	l = newLimiter(0)
+	l.Observe(quota(5), time.Now())
+	if d := waitN(l, 3); d < 500*time.Millisecond {
+		t.Errorf("3 requests with 5 of 100 left took %s, want them spread until the reset", d)
+	}
+}
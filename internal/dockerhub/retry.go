@@ -0,0 +1,88 @@
+package dockerhub
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how failed Hub requests are retried. 429 responses
+// wait for Retry-After / X-RateLimit-Reset when present; 5xx responses and
+// network errors use jittered exponential backoff.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts including the first; default 3
+	BaseDelay   time.Duration // first backoff step; default 500ms
+	MaxDelay    time.Duration // cap for a single wait; default 30s
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 500 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 30 * time.Second
+	}
+	return p
+}
+
+// next decides whether attempt (1-based) should be retried after err and how
+// long to wait first. A server-requested wait longer than MaxDelay is not
+// retried; the next poll will pick the repo up again.
+func (p RetryPolicy) next(attempt int, hdr http.Header, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		switch {
+		case se.Code == http.StatusTooManyRequests, se.Code == http.StatusServiceUnavailable:
+			if wait, ok := serverWait(hdr, time.Now()); ok {
+				// Never retry faster than backoff, even if the reset already passed.
+				return max(wait, p.backoff(attempt)), wait <= p.MaxDelay
+			}
+		case se.Code >= 500:
+		default:
+			return 0, false
+		}
+	}
+	return p.backoff(attempt), true
+}
+
+// backoff is exponential in attempt with equal jitter, capped at MaxDelay.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// serverWait reads Retry-After (seconds or HTTP date) or, failing that,
+// X-RateLimit-Reset (unix seconds).
+func serverWait(hdr http.Header, now time.Time) (time.Duration, bool) {
+	if hdr == nil {
+		return 0, false
+	}
+	if v := strings.TrimSpace(hdr.Get("Retry-After")); v != "" {
+		if sec, err := strconv.Atoi(v); err == nil {
+			return max(time.Duration(sec)*time.Second, 0), true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return max(t.Sub(now), 0), true
+		}
+	}
+	if v := strings.TrimSpace(hdr.Get("X-RateLimit-Reset")); v != "" {
+		if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return max(time.Unix(sec, 0).Sub(now), 0), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,107 @@
+package dockerhub
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of the client's request counters since startup.
+type Stats struct {
+	Requests      int64         // HTTP attempts, including retries
+	Retries       int64         // attempts that were retried
+	RateLimited   int64         // 429 responses
+	NetworkErrors int64         // attempts without an HTTP response
+	Failures      int64         // calls that failed after all attempts
+	ByStatus      map[int]int64 // responses per HTTP status code
+
+	QuotaRemaining int64 // last X-RateLimit-Remaining from Hub; -1 = unknown
+	QuotaResetAt   time.Time
+
+	ConsecutiveFailures int64 // failed calls since the last successful one
+	LastError           string
+	LastErrorAt         time.Time
+	LastSuccessAt       time.Time
+}
+
+type stats struct {
+	mu sync.Mutex
+	s  Stats
+
+	byStatus map[int]int64
+}
+
+// Stats returns a copy of the current counters.
+func (c *Client) Stats() Stats {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	out := c.stats.s
+	out.ByStatus = make(map[int]int64, len(c.stats.byStatus))
+	for k, v := range c.stats.byStatus {
+		out.ByStatus[k] = v
+	}
+	return out
+}
+
+func (st *stats) request() {
+	st.mu.Lock()
+	st.s.Requests++
+	st.mu.Unlock()
+}
+
+func (st *stats) status(code int) {
+	st.mu.Lock()
+	st.byStatus[code]++
+	if code == http.StatusTooManyRequests {
+		st.s.RateLimited++
+	}
+	st.mu.Unlock()
+}
+
+func (st *stats) quota(hdr http.Header) {
+	remaining, ok := headerInt(hdr, "X-RateLimit-Remaining")
+	if !ok {
+		return
+	}
+	st.mu.Lock()
+	st.s.QuotaRemaining = remaining
+	if reset, ok := headerInt(hdr, "X-RateLimit-Reset"); ok {
+		st.s.QuotaResetAt = time.Unix(reset, 0)
+	}
+	st.mu.Unlock()
+}
+
+func (st *stats) networkError() {
+	st.mu.Lock()
+	st.s.NetworkErrors++
+	st.mu.Unlock()
+}
+
+func (st *stats) retry() {
+	st.mu.Lock()
+	st.s.Retries++
+	st.mu.Unlock()
+}
+
+func (st *stats) success() {
+	st.mu.Lock()
+	st.s.ConsecutiveFailures = 0
+	st.s.LastSuccessAt = time.Now()
+	st.mu.Unlock()
+}
+
+// failure records a call that gave up. 404s are answers, not Hub trouble, so
+// they don't count towards ConsecutiveFailures.
+func (st *stats) failure(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.s.Failures++
+	var se *StatusError
+	if errors.As(err, &se) && se.Code == http.StatusNotFound {
+		return
+	}
+	st.s.ConsecutiveFailures++
+	st.s.LastError = err.Error()
+	st.s.LastErrorAt = time.Now()
+}
@@ -0,0 +1,98 @@
+// Package events defines what the watcher reports to notifiers (webhooks,
+// alerts, mail). Events are plain JSON-serializable values.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Event types.
+const (
+	TypeRunFailed      = "target.run_failed"   // run error or failed repos
+	TypeRepoDiscovered = "repo.discovered"     // new (or reappeared) repo
+	TypeRepoGone       = "repo.gone"           // repo no longer on Docker Hub
+	TypeImagePushed    = "repo.pushed"         // last_updated changed
+	TypeNegativeDelta  = "repo.negative_delta" // pull count went down
+	TypeAlertFired     = "alert.fired"         // an alert rule's condition started to hold
+	TypePing           = "ping"                // test delivery
+)
+
+// Types lists the event types a subscriber can choose from, with a short
+// description each, in display order.
+var Types = []struct {
+	Type        string
+	Description string
+}{
+	{TypeRunFailed, "Target run failed or had failed repos"},
+	{TypeRepoDiscovered, "New repo discovered (user mode) or repo reappeared"},
+	{TypeRepoGone, "Repo disappeared from Docker Hub"},
+	{TypeImagePushed, "Image pushed (last_updated changed)"},
+	{TypeNegativeDelta, "Pull count decreased"},
+	{TypeAlertFired, "Alert rule fired"},
+}
+
+// KnownType reports whether t is one of Types (or TypePing).
+func KnownType(t string) bool {
+	if t == TypePing {
+		return true
+	}
+	for _, k := range Types {
+		if k.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+type Event struct {
+	ID      string         `json:"id"`
+	Type    string         `json:"type"`
+	TSUTC   string         `json:"ts_utc"`
+	Summary string         `json:"summary"` // one line for humans
+	Target  *Target        `json:"target,omitempty"`
+	Repo    *Repo          `json:"repo,omitempty"`
+	Data    map[string]any `json:"data,omitempty"`
+}
+
+type Target struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+type Repo struct {
+	ID        int64  `json:"id"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	PullCount *int64 `json:"pull_count,omitempty"` // when the event stems from a snapshot
+	Delta     *int64 `json:"delta,omitempty"`      // since the previous snapshot
+}
+
+// New returns an event with a fresh ID and the current time.
+func New(typ, summary string) Event {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return Event{
+		ID:      hex.EncodeToString(b[:]),
+		Type:    typ,
+		TSUTC:   time.Now().UTC().Format(time.RFC3339),
+		Summary: summary,
+	}
+}
+
+// Sink receives events. Emit must not block for long; sinks queue and
+// deliver asynchronously.
+type Sink interface {
+	Emit(Event)
+}
+
+// Multi fans an event out to several sinks.
+type Multi []Sink
+
+func (m Multi) Emit(e Event) {
+	for _, s := range m {
+		s.Emit(e)
+	}
+}
@@ -0,0 +1,147 @@
+// Package export writes snapshots, deltas and rollups as CSV, NDJSON or a
+// JSON array, for the export API and CLI.
+package export
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// Output formats.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatJSON   = "json"
+)
+
+// ValidFormat reports whether f is one of the output formats.
+func ValidFormat(f string) bool {
+	return f == FormatCSV || f == FormatNDJSON || f == FormatJSON
+}
+
+// ContentType is the MIME type of a format.
+func ContentType(f string) string {
+	switch f {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
+
+// Write streams the rows selected by q to w and returns how many were
+// written. JSON and NDJSON rows are objects keyed by column name.
+func Write(dbx *sql.DB, w io.Writer, format string, q db.ExportQuery) (int, error) {
+	if !ValidFormat(format) {
+		return 0, fmt.Errorf("format must be %q, %q or %q", FormatCSV, FormatNDJSON, FormatJSON)
+	}
+	bw := bufio.NewWriterSize(w, 64<<10)
+
+	var (
+		cols   []string
+		cw     *csv.Writer
+		record []string
+		first  = true
+	)
+	header := func(c []string) error {
+		cols = c
+		switch format {
+		case FormatCSV:
+			cw = csv.NewWriter(bw)
+			record = make([]string, len(cols))
+			return cw.Write(cols)
+		case FormatJSON:
+			_, err := bw.WriteString("[")
+			return err
+		}
+		return nil
+	}
+	row := func(vals []any) error {
+		if format == FormatCSV {
+			for i, v := range vals {
+				record[i] = csvValue(v)
+			}
+			return cw.Write(record)
+		}
+
+		if format == FormatJSON {
+			sep := ",\n"
+			if first {
+				sep = "\n"
+			}
+			if _, err := bw.WriteString(sep); err != nil {
+				return err
+			}
+		}
+		first = false
+		if err := writeObject(bw, cols, vals); err != nil {
+			return err
+		}
+		if format == FormatNDJSON {
+			return bw.WriteByte('\n')
+		}
+		return nil
+	}
+
+	n, err := db.Export(dbx, q, header, row)
+	if err != nil {
+		return n, err
+	}
+	switch format {
+	case FormatCSV:
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return n, err
+		}
+	case FormatJSON:
+		end := "\n]\n"
+		if first {
+			end = "]\n"
+		}
+		if _, err := bw.WriteString(end); err != nil {
+			return n, err
+		}
+	}
+	return n, bw.Flush()
+}
+
+func csvValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// writeObject writes one row as a JSON object with the columns in order.
+func writeObject(w *bufio.Writer, cols []string, vals []any) error {
+	w.WriteByte('{')
+	for i, c := range cols {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		k, _ := json.Marshal(c)
+		w.Write(k)
+		w.WriteByte(':')
+		v, err := json.Marshal(vals[i])
+		if err != nil {
+			return err
+		}
+		w.Write(v)
+	}
+	return w.WriteByte('}')
+}
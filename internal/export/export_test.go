@@ -0,0 +1,127 @@
+package export_test
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/export"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dbx, err := db.Open(filepath.Join(t.TempDir(), "pulls.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbx.Close() })
+	if err := db.Migrate(dbx); err != nil {
+		t.Fatal(err)
+	}
+	return dbx
+}
+
+// seed stores three snapshots of ns/r, the first with a name that needs
+// CSV quoting.
+func seed(t *testing.T, dbx *sql.DB) {
+	t.Helper()
+	id, err := db.EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, pulls := range []int64{100, 150, 225} {
+		if _, err := db.InsertSnapshotAndDelta(dbx, id, start.Add(time.Duration(i)*time.Hour), pulls, 1, `"quoted", updated`, false, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	dbx := openDB(t)
+	seed(t, dbx)
+
+	var buf bytes.Buffer
+	n, err := export.Write(dbx, &buf, export.FormatCSV, db.ExportQuery{Dataset: db.DatasetSnapshots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || len(records) != 4 {
+		t.Fatalf("n = %d, %d records, want 3 rows and a header", n, len(records))
+	}
+	if records[0][3] != "ts_utc" || records[1][3] != "2025-01-01T00:00:00Z" || records[3][4] != "225" {
+		t.Errorf("records = %q", records)
+	}
+	if records[1][6] != `"quoted", updated` {
+		t.Errorf("last_updated = %q", records[1][6])
+	}
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	dbx := openDB(t)
+	seed(t, dbx)
+
+	var buf bytes.Buffer
+	n, err := export.Write(dbx, &buf, export.FormatNDJSON, db.ExportQuery{Dataset: db.DatasetDeltas})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deltas []int64
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var row map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		deltas = append(deltas, int64(row["delta"].(float64)))
+	}
+	if n != 2 || len(deltas) != 2 || deltas[0] != 50 || deltas[1] != 75 {
+		t.Errorf("n = %d, deltas = %v; want [50 75]", n, deltas)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	dbx := openDB(t)
+
+	var buf bytes.Buffer
+	if _, err := export.Write(dbx, &buf, export.FormatJSON, db.ExportQuery{Dataset: db.DatasetSnapshots}); err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil || rows == nil || len(rows) != 0 {
+		t.Errorf("empty export = %q (%v), want []", buf.String(), err)
+	}
+
+	seed(t, dbx)
+	buf.Reset()
+	q := db.ExportQuery{Dataset: db.DatasetSnapshots, Resolution: db.PeriodDay}
+	if _, err := export.Write(dbx, &buf, export.FormatJSON, q); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatalf("%q: %v", buf.String(), err)
+	}
+	if len(rows) != 1 || rows[0]["pull_count"] != float64(225) || rows[0]["samples"] != float64(3) {
+		t.Errorf("rows = %v", rows)
+	}
+}
+
+func TestWriteRejectsUnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := export.Write(nil, &buf, "xml", db.ExportQuery{Dataset: db.DatasetSnapshots}); err == nil {
+		t.Error("xml accepted")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("wrote %q for an unknown format", buf.String())
+	}
+}
@@ -0,0 +1,235 @@
+// Package importer reads historical pull counts from CSV and stores them as
+// snapshots, for the import page and CLI.
+//
+// Rows are namespace,repo,timestamp,pull_count[,star_count]. A header row
+// starting with "namespace" and lines starting with # are skipped.
+// Timestamps are RFC3339, "YYYY-MM-DD HH:MM:SS" or a plain date (both UTC),
+// or Unix seconds.
+package importer
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// Issue kinds.
+const (
+	KindInvalid    = "invalid"
+	KindDuplicate  = "duplicate"
+	KindOutOfOrder = "out of order"
+)
+
+// maxIssues caps how many issues a report lists; the counts stay exact.
+const maxIssues = 200
+
+// Issue is a problem with one CSV line.
+type Issue struct {
+	Line    int
+	Kind    string
+	Message string
+}
+
+// Report is the outcome of an import. Invalid rows abort the import;
+// duplicates are skipped and out-of-order rows are imported in timestamp
+// order.
+type Report struct {
+	DryRun     bool
+	Rows       int // data rows read
+	Repos      int
+	NewRepos   int
+	Inserted   int
+	Invalid    int
+	Duplicates int
+	OutOfOrder int
+	Issues     []Issue // the first maxIssues
+}
+
+func (r *Report) add(line int, kind, format string, args ...any) {
+	switch kind {
+	case KindInvalid:
+		r.Invalid++
+	case KindDuplicate:
+		r.Duplicates++
+	case KindOutOfOrder:
+		r.OutOfOrder++
+	}
+	if len(r.Issues) < maxIssues {
+		r.Issues = append(r.Issues, Issue{Line: line, Kind: kind, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// MoreIssues is how many issues were counted but not listed.
+func (r Report) MoreIssues() int {
+	return r.Invalid + r.Duplicates + r.OutOfOrder - len(r.Issues)
+}
+
+// Summary is a one-line description of the report.
+func (r Report) Summary() string {
+	verb := "imported"
+	if r.DryRun {
+		verb = "would be imported"
+	}
+	if r.Invalid > 0 {
+		return fmt.Sprintf("%d rows, %d invalid: nothing imported", r.Rows, r.Invalid)
+	}
+	return fmt.Sprintf("%d rows, %d snapshots %s into %d repos (%d new), %d duplicates, %d out of order",
+		r.Rows, r.Inserted, verb, r.Repos, r.NewRepos, r.Duplicates, r.OutOfOrder)
+}
+
+// ErrInvalid is returned when the CSV has invalid rows; nothing is imported.
+var ErrInvalid = errors.New("invalid rows, nothing imported")
+
+// Import reads CSV from src and stores its rows. With dryRun everything is
+// checked, including against the database, but nothing is stored.
+func Import(dbx *sql.DB, src io.Reader, dryRun bool) (Report, error) {
+	rep := Report{DryRun: dryRun}
+	rows, err := parse(src, &rep)
+	if err != nil {
+		return rep, err
+	}
+	if rep.Invalid > 0 {
+		return rep, ErrInvalid
+	}
+
+	res, err := db.ImportSnapshots(dbx, rows, dryRun)
+	if err != nil {
+		return rep, err
+	}
+	rep.Repos, rep.NewRepos, rep.Inserted = res.Repos, res.NewRepos, res.Inserted
+	for _, line := range res.Existing {
+		rep.add(line, KindDuplicate, "a snapshot with this timestamp is already stored")
+	}
+	return rep, nil
+}
+
+// parse reads and validates all rows. Duplicates within the file are
+// dropped (the first one wins) and out-of-order rows are reported.
+func parse(src io.Reader, rep *Report) ([]db.SnapshotImport, error) {
+	cr := csv.NewReader(src)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+	cr.ReuseRecord = true
+
+	type seen struct {
+		line int
+		ts   time.Time
+	}
+	first := map[string]int{} // repo + ts -> line
+	last := map[string]seen{} // repo -> previous row
+
+	var rows []db.SnapshotImport
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var pe *csv.ParseError
+			if errors.As(err, &pe) {
+				rep.Rows++
+				rep.add(pe.Line, KindInvalid, "%v", pe.Err)
+				continue
+			}
+			return nil, err
+		}
+		if rep.Rows == 0 && rep.Invalid == 0 && strings.EqualFold(strings.TrimSpace(rec[0]), "namespace") {
+			continue // header
+		}
+		rep.Rows++
+		line, _ := cr.FieldPos(0)
+
+		r, err := parseRecord(rec)
+		if err != nil {
+			rep.add(line, KindInvalid, "%v", err)
+			continue
+		}
+		r.Line = line
+		repo := r.Namespace + "/" + r.Repo
+
+		key := repo + " " + r.TS.Format(time.RFC3339)
+		if l, ok := first[key]; ok {
+			rep.add(line, KindDuplicate, "%s at %s already on line %d", repo, r.TS.Format(time.RFC3339), l)
+			continue
+		}
+		first[key] = line
+
+		if prev, ok := last[repo]; ok && r.TS.Before(prev.ts) {
+			rep.add(line, KindOutOfOrder, "%s at %s is older than line %d (%s)", repo, r.TS.Format(time.RFC3339), prev.line, prev.ts.Format(time.RFC3339))
+		}
+		if prev, ok := last[repo]; !ok || r.TS.After(prev.ts) {
+			last[repo] = seen{line, r.TS}
+		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
+
+func parseRecord(rec []string) (db.SnapshotImport, error) {
+	var r db.SnapshotImport
+	if len(rec) != 4 && len(rec) != 5 {
+		return r, fmt.Errorf("want 4 or 5 fields (namespace,repo,timestamp,pull_count[,star_count]), got %d", len(rec))
+	}
+	for i := range rec {
+		rec[i] = strings.TrimSpace(rec[i])
+	}
+
+	r.Namespace, r.Repo = rec[0], rec[1]
+	if r.Namespace == "" || r.Repo == "" {
+		return r, errors.New("namespace and repo are required")
+	}
+	if strings.Contains(r.Namespace, "/") || strings.Contains(r.Repo, "/") {
+		return r, errors.New("namespace and repo must not contain /")
+	}
+
+	ts, err := parseTime(rec[2])
+	if err != nil {
+		return r, fmt.Errorf("invalid timestamp %q", rec[2])
+	}
+	if ts.After(time.Now().Add(time.Minute)) {
+		return r, fmt.Errorf("timestamp %s is in the future", ts.Format(time.RFC3339))
+	}
+	r.TS = ts
+
+	if r.PullCount, err = parseCount(rec[3]); err != nil {
+		return r, fmt.Errorf("invalid pull_count %q", rec[3])
+	}
+	if len(rec) == 5 && rec[4] != "" {
+		n, err := parseCount(rec[4])
+		if err != nil {
+			return r, fmt.Errorf("invalid star_count %q", rec[4])
+		}
+		r.StarCount = &n
+	}
+	return r, nil
+}
+
+// parseTime returns t in UTC, truncated to the second like stored
+// timestamps.
+func parseTime(v string) (time.Time, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(n, 0).UTC(), nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateTime, time.DateOnly} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.UTC().Truncate(time.Second), nil
+		}
+	}
+	return time.Time{}, errors.New("unknown format")
+}
+
+func parseCount(v string) (int64, error) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("not a non-negative integer")
+	}
+	return n, nil
+}
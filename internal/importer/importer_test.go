@@ -0,0 +1,114 @@
+package importer_test
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/importer"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dbx, err := db.Open(filepath.Join(t.TempDir(), "pulls.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbx.Close() })
+	if err := db.Migrate(dbx); err != nil {
+		t.Fatal(err)
+	}
+	return dbx
+}
+
+func snapshotCount(t *testing.T, dbx *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := dbx.QueryRow(`SELECT COUNT(*) FROM repo_snapshots`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+const history = `namespace,repo,timestamp,pull_count,star_count
+# exported from the old tracker
+ns,r,2025-01-01,100,1
+ns,r,2025-01-03 12:00:00,300
+ns,r,2025-01-02T00:00:00Z,200
+ns,r,2025-01-01T00:00:00Z,100
+ns,r,1736294400,800
+ns,other,2025-01-01,5
+`
+
+func TestImportReportsDuplicatesAndOutOfOrderRows(t *testing.T) {
+	dbx := openDB(t)
+	repoID, err := db.EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1736294400 is 2025-01-08, already stored.
+	if _, err := db.InsertSnapshotAndDelta(dbx, repoID, time.Date(2025, 1, 8, 0, 0, 0, 0, time.UTC), 800, 0, "", false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := importer.Import(dbx, strings.NewReader(history), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Rows != 6 || rep.Inserted != 4 || rep.Repos != 2 || rep.NewRepos != 1 ||
+		rep.Duplicates != 2 || rep.OutOfOrder != 1 || rep.Invalid != 0 {
+		t.Errorf("report = %+v", rep)
+	}
+	lines := map[int]string{}
+	for _, is := range rep.Issues {
+		lines[is.Line] = is.Kind
+	}
+	want := map[int]string{5: importer.KindOutOfOrder, 6: importer.KindDuplicate, 7: importer.KindDuplicate}
+	if len(lines) != len(want) {
+		t.Errorf("issues = %+v, want lines %v", rep.Issues, want)
+	}
+	for l, kind := range want {
+		if lines[l] != kind {
+			t.Errorf("line %d: %q, want %q", l, lines[l], kind)
+		}
+	}
+	if n := snapshotCount(t, dbx); n != 5 {
+		t.Errorf("%d snapshots stored, want 5", n)
+	}
+}
+
+func TestImportDryRunStoresNothing(t *testing.T) {
+	dbx := openDB(t)
+	rep, err := importer.Import(dbx, strings.NewReader(history), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.DryRun || rep.Inserted != 5 || rep.NewRepos != 2 {
+		t.Errorf("report = %+v, want 5 snapshots into 2 new repos", rep)
+	}
+	if !strings.Contains(rep.Summary(), "would be imported") {
+		t.Errorf("summary = %q", rep.Summary())
+	}
+	if n := snapshotCount(t, dbx); n != 0 {
+		t.Errorf("dry run stored %d snapshots", n)
+	}
+}
+
+func TestImportInvalidRowsAbort(t *testing.T) {
+	dbx := openDB(t)
+	src := "ns,r,2025-01-01,100\nns,r,yesterday,200\nns/x,r,2025-01-02,1\nns,r,2025-01-03,-1\n"
+	rep, err := importer.Import(dbx, strings.NewReader(src), false)
+	if !errors.Is(err, importer.ErrInvalid) {
+		t.Fatalf("err = %v, want ErrInvalid", err)
+	}
+	if rep.Invalid != 3 {
+		t.Errorf("report = %+v, want 3 invalid rows", rep)
+	}
+	if n := snapshotCount(t, dbx); n != 0 {
+		t.Errorf("%d snapshots stored despite invalid rows", n)
+	}
+}
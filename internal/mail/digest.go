@@ -0,0 +1,117 @@
+package mail
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// topMovers is how many repos with the largest change in pulls gained the
+// digest highlights.
+const topMovers = 5
+
+// digestLoop sends the digest of the previous day or week once the UTC
+// clock passes DigestHour. mail_digests records what was sent, so restarts
+// don't send twice; after a longer outage only the latest digest is sent.
+func (m *Mailer) digestLoop() {
+	defer m.wg.Done()
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		m.maybeSendDigest(time.Now())
+		select {
+		case <-m.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (m *Mailer) maybeSendDigest(now time.Time) {
+	now = now.UTC()
+	if now.Sub(db.BucketStart(db.PeriodDay, now)) < time.Duration(m.cfg.DigestHour)*time.Hour {
+		return
+	}
+	start := db.PreviousBucket(m.cfg.Digest, now)
+	sent, err := db.DigestSent(m.db, m.cfg.Digest, start)
+	if err != nil || sent {
+		if err != nil {
+			log.Printf("mail: digest: %v", err)
+		}
+		return
+	}
+
+	d, err := db.BuildDigest(m.db, m.cfg.Digest, start)
+	if err != nil {
+		log.Printf("mail: build digest: %v", err)
+		return
+	}
+	if len(d.Repos) > 0 || len(d.Failing) > 0 {
+		subject, body := FormatDigest(d)
+		if err := m.sendRetry(subject, body); err != nil {
+			log.Printf("mail: send digest: %v", err)
+			return
+		}
+	}
+	if err := db.MarkDigestSent(m.db, m.cfg.Digest, start, now); err != nil {
+		log.Printf("mail: digest: %v", err)
+	}
+}
+
+// FormatDigest renders a digest as subject and plain-text body.
+func FormatDigest(d db.Digest) (subject, body string) {
+	start, _ := time.Parse(time.RFC3339, d.StartUTC)
+	end, _ := time.Parse(time.RFC3339, d.EndUTC)
+	label := "Daily"
+	span := start.Format("2006-01-02")
+	if d.Period == db.PeriodWeek {
+		label = "Weekly"
+		span = start.Format("2006-01-02") + " – " + end.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	subject = fmt.Sprintf("%s digest %s: +%d pulls", label, span, d.Pulls)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s digest for %s (UTC)\n\n", label, span)
+	fmt.Fprintf(&b, "Pulls gained: %d across %d repos\n", d.Pulls, len(d.Repos))
+
+	if len(d.Failing) > 0 {
+		b.WriteString("\nFailing targets\n")
+		for _, t := range d.Failing {
+			fmt.Fprintf(&b, "  %s: %d of %d runs failed", t.Name, t.FailedRuns, t.Runs)
+			if t.LastError != "" {
+				fmt.Fprintf(&b, " (last: %s)", t.LastError)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	if len(d.Repos) > 0 {
+		movers := slices.Clone(d.Repos)
+		slices.SortStableFunc(movers, func(x, y db.DigestRepo) int {
+			return cmp.Compare(abs(y.Pulls-y.PrevPulls), abs(x.Pulls-x.PrevPulls))
+		})
+		b.WriteString("\nTop movers (change vs. previous " + d.Period + ")\n")
+		for i, r := range movers[:min(topMovers, len(movers))] {
+			fmt.Fprintf(&b, "  %d. %s/%s %+d (%d, previously %d)\n", i+1, r.Namespace, r.Name, r.Pulls-r.PrevPulls, r.Pulls, r.PrevPulls)
+		}
+
+		b.WriteString("\nAll repos\n")
+		fmt.Fprintf(&b, "  %-40s %12s %12s %8s\n", "repo", "gained", "total", "stars")
+		for _, r := range d.Repos {
+			fmt.Fprintf(&b, "  %-40s %12d %12d %+8d\n", r.Namespace+"/"+r.Name, r.Pulls, r.PullCount, r.Stars)
+		}
+	}
+	return subject, b.String()
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,338 @@
+// Package mail sends watcher events and periodic digests by email over SMTP.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dockerhub-pull-watcher/internal/events"
+)
+
+// TLS modes.
+const (
+	TLSStartTLS = "starttls" // plain connection upgraded with STARTTLS (port 587)
+	TLSImplicit = "tls"      // TLS from the start (port 465)
+	TLSNone     = "none"     // no encryption, e.g. a local relay or test server
+)
+
+type Config struct {
+	Host     string // empty disables mail
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+	TLS      string
+	Timeout  time.Duration
+
+	Events      []string // event types mailed immediately; nil = none
+	Digest      string   // "", db.PeriodDay or db.PeriodWeek
+	DigestHour  int      // UTC hour a digest is sent at
+	BatchWindow time.Duration
+}
+
+// Enabled reports whether a server and recipients are configured.
+func (c Config) Enabled() bool {
+	return c.Host != "" && len(c.To) > 0
+}
+
+func (c Config) withDefaults() Config {
+	if c.Port <= 0 {
+		c.Port = 587
+	}
+	if c.TLS == "" {
+		c.TLS = TLSStartTLS
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 30 * time.Second
+	}
+	if c.From == "" {
+		c.From = "pullpulse@" + c.Host
+	}
+	if c.BatchWindow <= 0 {
+		c.BatchWindow = 10 * time.Second
+	}
+	return c
+}
+
+// Mailer implements events.Sink. Events are queued in memory and sent by a
+// background goroutine; events arriving within BatchWindow of each other are
+// combined into one mail.
+type Mailer struct {
+	db  *sql.DB
+	cfg Config
+
+	queue chan events.Event
+	stop  chan struct{}
+	wg    sync.WaitGroup
+	once  sync.Once
+}
+
+func NewMailer(dbx *sql.DB, cfg Config) *Mailer {
+	return &Mailer{
+		db:    dbx,
+		cfg:   cfg.withDefaults(),
+		queue: make(chan events.Event, 100),
+		stop:  make(chan struct{}),
+	}
+}
+
+// Emit queues e if its type is mailed immediately. A full queue drops the
+// event rather than blocking the watcher.
+func (m *Mailer) Emit(e events.Event) {
+	if !slices.Contains(m.cfg.Events, e.Type) {
+		return
+	}
+	select {
+	case m.queue <- e:
+	default:
+		log.Printf("mail: queue full, dropping %s event %s", e.Type, e.ID)
+	}
+}
+
+func (m *Mailer) Start() {
+	m.wg.Add(1)
+	go m.eventLoop()
+	if m.cfg.Digest != "" {
+		m.wg.Add(1)
+		go m.digestLoop()
+	}
+}
+
+// Stop ends the background loops. Events still queued are dropped.
+func (m *Mailer) Stop(ctx context.Context) error {
+	m.once.Do(func() { close(m.stop) })
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *Mailer) eventLoop() {
+	defer m.wg.Done()
+	for {
+		var batch []events.Event
+		select {
+		case <-m.stop:
+			return
+		case e := <-m.queue:
+			batch = append(batch, e)
+		}
+
+		window := time.NewTimer(m.cfg.BatchWindow)
+	collect:
+		for len(batch) < 50 {
+			select {
+			case e := <-m.queue:
+				batch = append(batch, e)
+			case <-window.C:
+				break collect
+			case <-m.stop:
+				break collect
+			}
+		}
+		window.Stop()
+
+		subject, body := formatEvents(batch)
+		if err := m.sendRetry(subject, body); err != nil {
+			log.Printf("mail: send %d event(s): %v", len(batch), err)
+		}
+	}
+}
+
+// Flush mails the queued events now, for one-shot runs without the event
+// loop.
+func (m *Mailer) Flush() {
+	for {
+		var batch []events.Event
+	collect:
+		for len(batch) < 50 {
+			select {
+			case e := <-m.queue:
+				batch = append(batch, e)
+			default:
+				break collect
+			}
+		}
+		if len(batch) == 0 {
+			return
+		}
+		subject, body := formatEvents(batch)
+		if err := m.sendRetry(subject, body); err != nil {
+			log.Printf("mail: send %d event(s): %v", len(batch), err)
+		}
+	}
+}
+
+// sendRetry tries a few times so a briefly unavailable relay doesn't lose
+// the mail, giving up early on shutdown.
+func (m *Mailer) sendRetry(subject, body string) error {
+	var err error
+	for attempt := 1; attempt <= 3; attempt++ {
+		if err = Send(m.cfg, subject, body); err == nil {
+			return nil
+		}
+		select {
+		case <-m.stop:
+			return err
+		case <-time.After(time.Duration(attempt) * 10 * time.Second):
+		}
+	}
+	return err
+}
+
+func formatEvents(batch []events.Event) (subject, body string) {
+	if len(batch) == 1 {
+		return batch[0].Summary, formatEvent(batch[0])
+	}
+	var b strings.Builder
+	for _, e := range batch {
+		fmt.Fprintf(&b, "- %s\n", e.Summary)
+	}
+	for _, e := range batch {
+		b.WriteString("\n")
+		b.WriteString(formatEvent(e))
+	}
+	return fmt.Sprintf("%d events", len(batch)), b.String()
+}
+
+func formatEvent(e events.Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", e.Summary)
+	fmt.Fprintf(&b, "  event:  %s\n", e.Type)
+	fmt.Fprintf(&b, "  time:   %s\n", e.TSUTC)
+	if e.Target != nil {
+		fmt.Fprintf(&b, "  target: %s (#%d)\n", e.Target.Name, e.Target.ID)
+	}
+	if e.Repo != nil {
+		fmt.Fprintf(&b, "  repo:   %s/%s\n", e.Repo.Namespace, e.Repo.Name)
+	}
+	keys := make([]string, 0, len(e.Data))
+	for k := range e.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "  %s: %v\n", k, e.Data[k])
+	}
+	return b.String()
+}
+
+// Send delivers one plain-text mail to all recipients of cfg. The subject
+// gets a "[pullpulse]" prefix.
+func Send(cfg Config, subject, body string) error {
+	cfg = cfg.withDefaults()
+	if !cfg.Enabled() {
+		return errors.New("mail is not configured (SMTP_HOST, SMTP_TO)")
+	}
+	msg, err := message(cfg, "[pullpulse] "+subject, body)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	var conn net.Conn
+	if cfg.TLS == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: cfg.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(cfg.Timeout))
+
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if cfg.TLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("server does not support STARTTLS (set SMTP_TLS=none to send unencrypted)")
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(msg); err != nil {
+		wc.Close()
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func message(cfg Config, subject, body string) ([]byte, error) {
+	var id [12]byte
+	_, _ = rand.Read(id[:])
+	domain := cfg.Host
+	if _, d, ok := strings.Cut(cfg.From, "@"); ok {
+		domain = d
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id[:]), domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&b)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
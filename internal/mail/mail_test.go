@@ -0,0 +1,273 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/events"
+)
+
+// smtpServer is a minimal SMTP stand-in that accepts every message.
+type smtpServer struct {
+	ln   net.Listener
+	msgs chan received
+}
+
+type received struct {
+	from string
+	to   []string
+	data string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln, msgs: make(chan received, 10)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+// config returns a Config sending to s without encryption.
+func (s *smtpServer) config() Config {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return Config{
+		Host: "127.0.0.1",
+		Port: addr.Port,
+		TLS:  TLSNone,
+		From: "pullpulse@example.com",
+		To:   []string{"ops@example.com", "dev@example.com"},
+	}
+}
+
+func (s *smtpServer) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	reply := func(line string) { io.WriteString(c, line+"\r\n") }
+	var m received
+
+	reply("220 test ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 test")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			m.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			m.to = append(m.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				b.WriteString(strings.TrimPrefix(l, "."))
+			}
+			m.data = b.String()
+			s.msgs <- m
+			m = received{}
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// next waits for the next message.
+func (s *smtpServer) next(t *testing.T, timeout time.Duration) received {
+	t.Helper()
+	select {
+	case m := <-s.msgs:
+		return m
+	case <-time.After(timeout):
+		t.Fatal("no mail received")
+		return received{}
+	}
+}
+
+// none fails if a message arrives within d.
+func (s *smtpServer) none(t *testing.T, d time.Duration) {
+	t.Helper()
+	select {
+	case m := <-s.msgs:
+		t.Errorf("unexpected mail: %q", m.data)
+	case <-time.After(d):
+	}
+}
+
+func TestSendWithoutTLS(t *testing.T) {
+	srv := newSMTPServer(t)
+	cfg := srv.config()
+
+	body := "Grüße from pullpulse\nratio = 3/4\n" + strings.Repeat("x", 100) + "\n"
+	if err := Send(cfg, "Grüße", body); err != nil {
+		t.Fatal(err)
+	}
+	got := srv.next(t, 5*time.Second)
+
+	if got.from != cfg.From || strings.Join(got.to, ",") != "ops@example.com,dev@example.com" {
+		t.Errorf("envelope = %s -> %v", got.from, got.to)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "[pullpulse] Grüße" {
+		t.Errorf("subject = %q, %v", subject, err)
+	}
+	for k, want := range map[string]string{
+		"From":                      cfg.From,
+		"To":                        "ops@example.com, dev@example.com",
+		"Content-Type":              "text/plain; charset=utf-8",
+		"Content-Transfer-Encoding": "quoted-printable",
+	} {
+		if v := msg.Header.Get(k); v != want {
+			t.Errorf("%s = %q, want %q", k, v, want)
+		}
+	}
+	if !strings.HasSuffix(msg.Header.Get("Message-ID"), "@example.com>") {
+		t.Errorf("Message-ID = %q", msg.Header.Get("Message-ID"))
+	}
+
+	raw, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range strings.Split(string(raw), "\r\n") {
+		if len(l) > 76 {
+			t.Errorf("encoded line longer than 76 characters: %q", l)
+		}
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(string(raw))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.ReplaceAll(body, "\n", "\r\n"); string(decoded) != want {
+		t.Errorf("body = %q, want %q", decoded, want)
+	}
+}
+
+func TestEventsWithinBatchWindowShareOneMail(t *testing.T) {
+	srv := newSMTPServer(t)
+	cfg := srv.config()
+	cfg.Events = []string{events.TypeRepoGone}
+	cfg.BatchWindow = 200 * time.Millisecond
+
+	m := NewMailer(nil, cfg)
+	m.Start()
+	defer m.Stop(context.Background())
+
+	m.Emit(events.New(events.TypeRepoGone, "ns/a is gone"))
+	m.Emit(events.New(events.TypePing, "not mailed"))
+	m.Emit(events.New(events.TypeRepoGone, "ns/b is gone"))
+	m.Emit(events.New(events.TypeRepoGone, "ns/c is gone"))
+
+	got := srv.next(t, 5*time.Second)
+	msg, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := msg.Header.Get("Subject"); s != "[pullpulse] 3 events" {
+		t.Errorf("subject = %q, want the 3 events in one mail", s)
+	}
+	body, _ := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if strings.Contains(string(body), "not mailed") {
+		t.Error("mail contains an event type that isn't configured")
+	}
+	srv.none(t, 2*cfg.BatchWindow)
+
+	// A later event starts a new batch.
+	m.Emit(events.New(events.TypeRepoGone, "ns/d is gone"))
+	got = srv.next(t, 5*time.Second)
+	if msg, err := mail.ReadMessage(strings.NewReader(got.data)); err != nil || msg.Header.Get("Subject") != "[pullpulse] ns/d is gone" {
+		t.Errorf("second mail = %q, %v", got.data, err)
+	}
+}
+
+func TestDigestIsSentOncePerPeriod(t *testing.T) {
+	srv := newSMTPServer(t)
+	dbx, err := db.Open(filepath.Join(t.TempDir(), "pulls.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbx.Close()
+	if err := db.Migrate(dbx); err != nil {
+		t.Fatal(err)
+	}
+
+	repoID, err := db.EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	yesterday := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i, pulls := range []int64{100, 130, 175} {
+		if _, err := db.InsertSnapshotAndDelta(dbx, repoID, yesterday.Add(time.Duration(i+1)*6*time.Hour), pulls, 0, "", false, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := srv.config()
+	cfg.Digest = db.PeriodDay
+	cfg.DigestHour = 7
+	m := NewMailer(dbx, cfg)
+
+	today := yesterday.AddDate(0, 0, 1)
+	m.maybeSendDigest(today.Add(6 * time.Hour)) // before DigestHour
+	srv.none(t, 100*time.Millisecond)
+
+	m.maybeSendDigest(today.Add(7 * time.Hour))
+	got := srv.next(t, 5*time.Second)
+	msg, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := msg.Header.Get("Subject"); s != "[pullpulse] Daily digest 2025-03-01: +75 pulls" {
+		t.Errorf("subject = %q", s)
+	}
+	if sent, err := db.DigestSent(dbx, db.PeriodDay, yesterday); err != nil || !sent {
+		t.Errorf("DigestSent = %t, %v; want the digest recorded", sent, err)
+	}
+
+	// Later the same day, and after a restart: nothing new.
+	m.maybeSendDigest(today.Add(8 * time.Hour))
+	NewMailer(dbx, cfg).maybeSendDigest(today.Add(9 * time.Hour))
+	srv.none(t, 200*time.Millisecond)
+}
@@ -0,0 +1,39 @@
+package watcher
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/dockerhub"
+	"dockerhub-pull-watcher/internal/events"
+)
+
+// evaluateAlerts runs the alert rules covering r against the snapshot just
+// stored and emits an event per firing. Failures are logged; they must not
+// fail the poll.
+func (s *Service) evaluateAlerts(tg db.Target, r db.Repo, now time.Time, info dockerhub.RepoInfo, res db.SnapshotResult) {
+	fired, err := db.EvaluateAlertRules(s.db, r.ID, r.Namespace, r.Name, now)
+	if err != nil {
+		log.Printf("watcher: evaluate alerts for %s/%s: %v", r.Namespace, r.Name, err)
+		return
+	}
+	for _, f := range fired {
+		e := events.New(events.TypeAlertFired, fmt.Sprintf("Alert %s: %s/%s %s (now %s)",
+			f.RuleName, r.Namespace, r.Name, f.Condition, db.FormatAlertValue(f.Value)))
+		e.Target = targetRef(tg)
+		e.Repo = snapshotRef(r, info, res.Prev)
+		e.Data = map[string]any{
+			"rule_id":   f.RuleID,
+			"rule":      f.RuleName,
+			"metric":    f.Metric,
+			"op":        f.Op,
+			"condition": f.Condition,
+			"value":     f.Value,
+			"threshold": f.Threshold,
+			"firing_id": f.ID,
+		}
+		s.emit(e)
+	}
+}
@@ -0,0 +1,204 @@
+package watcher
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/dockerhub"
+	"dockerhub-pull-watcher/internal/events"
+)
+
+func (s *Service) emit(e events.Event) {
+	if s.cfg.Events != nil {
+		s.cfg.Events.Emit(e)
+	}
+}
+
+func targetRef(tg db.Target) *events.Target {
+	return &events.Target{ID: tg.ID, Name: tg.Name, Namespace: tg.Namespace}
+}
+
+func repoRef(r db.Repo) *events.Repo {
+	return &events.Repo{ID: r.ID, Namespace: r.Namespace, Name: r.Name}
+}
+
+// snapshotRef is repoRef with the pull count of a fresh snapshot and its
+// delta to the previous one.
+func snapshotRef(r db.Repo, info dockerhub.RepoInfo, prev *db.RepoSnapshot) *events.Repo {
+	ref := repoRef(r)
+	pulls := info.PullCount
+	ref.PullCount = &pulls
+	if prev != nil {
+		delta := info.PullCount - prev.PullCount
+		ref.Delta = &delta
+	}
+	return ref
+}
+
+func (s *Service) emitRunFailed(tg db.Target, run db.TargetRun) {
+	summary := fmt.Sprintf("Target %s: %d of %d repos failed", tg.Name, run.ReposFailed, len(run.Repos))
+	if run.Error != "" {
+		summary = fmt.Sprintf("Target %s failed: %s", tg.Name, run.Error)
+	}
+	e := events.New(events.TypeRunFailed, summary)
+	e.Target = targetRef(tg)
+
+	var failed []string
+	for _, rr := range run.Repos {
+		if rr.Status == db.RepoStatusFailed {
+			failed = append(failed, rr.Repo)
+		}
+	}
+	e.Data = map[string]any{
+		"run_id":        run.ID,
+		"trigger":       run.Trigger,
+		"error":         run.Error,
+		"repos_total":   len(run.Repos),
+		"repos_failed":  run.ReposFailed,
+		"repos_skipped": run.ReposSkipped,
+		"failed_repos":  failed,
+	}
+	s.emit(e)
+}
+
+// syncNamespace compares a user-mode listing with the repos known for the
+// namespace: unknown repos are discovered, known ones missing from the
+// listing are gone. The first listing of a namespace only records repos.
+// Repos an enabled repos-mode target fetches directly are left to that
+// target, which marks them gone on a 404; a listing also misses repos it
+// can't see, such as private ones.
+func (s *Service) syncNamespace(tg db.Target, listed []string) {
+	known, err := db.QueryRepos(s.db, tg.Namespace, db.ListOpts{})
+	if err != nil {
+		log.Printf("watcher: list known repos of %s: %v", tg.Namespace, err)
+		return
+	}
+	byName := make(map[string]db.Repo, len(known))
+	for _, r := range known {
+		byName[r.Name] = r
+	}
+	initial := len(known) == 0
+
+	inListing := make(map[string]bool, len(listed))
+	for _, name := range listed {
+		inListing[name] = true
+		if _, ok := byName[name]; ok {
+			continue // reappearing repos are reported by store
+		}
+		id, err := db.EnsureRepo(s.db, tg.Namespace, name)
+		if err != nil {
+			log.Printf("watcher: ensure repo %s/%s: %v", tg.Namespace, name, err)
+			continue
+		}
+		if initial {
+			continue
+		}
+		e := events.New(events.TypeRepoDiscovered, fmt.Sprintf("New repo %s/%s", tg.Namespace, name))
+		e.Target = targetRef(tg)
+		e.Repo = repoRef(db.Repo{ID: id, Namespace: tg.Namespace, Name: name})
+		s.emit(e)
+	}
+
+	var direct []db.Target
+	for _, r := range known {
+		if inListing[r.Name] || r.GoneUTC != "" {
+			continue
+		}
+		if direct == nil {
+			if direct, err = directTargets(s.db, tg.Namespace); err != nil {
+				log.Printf("watcher: list targets of %s: %v", tg.Namespace, err)
+				return
+			}
+		}
+		if !slices.ContainsFunc(direct, func(o db.Target) bool { return o.Tracks(r) }) {
+			s.setGone(tg, r)
+		}
+	}
+}
+
+// directTargets returns the enabled repos-mode targets of namespace.
+func directTargets(dbx *sql.DB, namespace string) ([]db.Target, error) {
+	targets, err := db.ListTargets(dbx)
+	if err != nil {
+		return nil, err
+	}
+	out := []db.Target{}
+	for _, t := range targets {
+		if t.Enabled && t.Mode == "repos" && t.Namespace == namespace {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// markGone handles a 404 for a repo of a repos-mode target.
+func (s *Service) markGone(tg db.Target, name string) {
+	r, err := db.FindRepo(s.db, tg.Namespace, name)
+	if err != nil {
+		return // never seen, nothing disappeared
+	}
+	s.setGone(tg, r)
+}
+
+func (s *Service) setGone(tg db.Target, r db.Repo) {
+	changed, err := db.SetRepoGone(s.db, r.ID, true, time.Now())
+	if err != nil {
+		log.Printf("watcher: mark %s/%s gone: %v", r.Namespace, r.Name, err)
+		return
+	}
+	if !changed {
+		return
+	}
+	e := events.New(events.TypeRepoGone, fmt.Sprintf("Repo %s/%s disappeared from Docker Hub", r.Namespace, r.Name))
+	e.Target = targetRef(tg)
+	e.Repo = repoRef(r)
+	s.emit(e)
+}
+
+// snapshotEvents reports what a freshly stored snapshot says about the repo.
+func (s *Service) snapshotEvents(tg db.Target, r db.Repo, now time.Time, info dockerhub.RepoInfo, res db.SnapshotResult) {
+	if back, err := db.SetRepoGone(s.db, r.ID, false, now); err != nil {
+		log.Printf("watcher: mark %s/%s present: %v", r.Namespace, r.Name, err)
+	} else if back {
+		e := events.New(events.TypeRepoDiscovered, fmt.Sprintf("Repo %s/%s is back on Docker Hub", r.Namespace, r.Name))
+		e.Target = targetRef(tg)
+		e.Repo = snapshotRef(r, info, res.Prev)
+		e.Data = map[string]any{"reappeared": true}
+		s.emit(e)
+	}
+
+	prev := res.Prev
+	if !res.Inserted || prev == nil {
+		return
+	}
+	tsUTC := now.UTC().Format(time.RFC3339)
+
+	if prev.LastUpdate != "" && info.LastUpdated != "" && info.LastUpdated != prev.LastUpdate {
+		e := events.New(events.TypeImagePushed, fmt.Sprintf("New push to %s/%s", r.Namespace, r.Name))
+		e.Target = targetRef(tg)
+		e.Repo = snapshotRef(r, info, prev)
+		e.Data = map[string]any{
+			"last_updated":          info.LastUpdated,
+			"previous_last_updated": prev.LastUpdate,
+		}
+		s.emit(e)
+	}
+
+	if delta := info.PullCount - prev.PullCount; delta < 0 {
+		e := events.New(events.TypeNegativeDelta, fmt.Sprintf("Pull count of %s/%s dropped by %d", r.Namespace, r.Name, -delta))
+		e.Target = targetRef(tg)
+		e.Repo = snapshotRef(r, info, prev)
+		e.Data = map[string]any{
+			"from_ts_utc":     prev.TSUTC,
+			"to_ts_utc":       tsUTC,
+			"from_pull_count": prev.PullCount,
+			"to_pull_count":   info.PullCount,
+			"delta":           delta,
+		}
+		s.emit(e)
+	}
+}
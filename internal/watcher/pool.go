@@ -0,0 +1,98 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+)
+
+// workerPool bounds concurrent Hub fetches across all polls. When all slots
+// are taken, freed slots are handed out round-robin by target, so a target
+// with hundreds of repos cannot hold every slot while others wait.
+type workerPool struct {
+	mu     sync.Mutex
+	free   int
+	queues map[int64][]chan struct{}
+	order  []int64 // targets with waiters, in round-robin order
+}
+
+func newWorkerPool(n int) *workerPool {
+	return &workerPool{free: n, queues: map[int64][]chan struct{}{}}
+}
+
+// acquire blocks until target may use a slot or ctx is done.
+func (p *workerPool) acquire(ctx context.Context, target int64) error {
+	p.mu.Lock()
+	if p.free > 0 && len(p.order) == 0 {
+		p.free--
+		p.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	if len(p.queues[target]) == 0 {
+		p.order = append(p.order, target)
+	}
+	p.queues[target] = append(p.queues[target], ready)
+	p.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		select {
+		case <-ready:
+			// Granted concurrently; pass the slot on.
+			p.releaseLocked()
+		default:
+			p.remove(target, ready)
+		}
+		return ctx.Err()
+	}
+}
+
+// release returns a slot, handing it to the next waiting target if any.
+func (p *workerPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.releaseLocked()
+}
+
+func (p *workerPool) releaseLocked() {
+	if len(p.order) == 0 {
+		p.free++
+		return
+	}
+	target := p.order[0]
+	ready := p.queues[target][0]
+	p.queues[target] = p.queues[target][1:]
+	p.order = p.order[1:]
+	if len(p.queues[target]) > 0 {
+		p.order = append(p.order, target)
+	} else {
+		delete(p.queues, target)
+	}
+	close(ready)
+}
+
+// remove drops ready from its target's queue. Callers hold p.mu.
+func (p *workerPool) remove(target int64, ready chan struct{}) {
+	q := p.queues[target]
+	for i := range q {
+		if q[i] == ready {
+			q = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	if len(q) > 0 {
+		p.queues[target] = q
+		return
+	}
+	delete(p.queues, target)
+	for i, t := range p.order {
+		if t == target {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+}
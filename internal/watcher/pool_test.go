@@ -0,0 +1,82 @@
+package watcher
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// waiting returns how many acquires are queued.
+func (p *workerPool) waiting() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, q := range p.queues {
+		n += len(q)
+	}
+	return n
+}
+
+func TestWorkerPoolAlternatesTargets(t *testing.T) {
+	p := newWorkerPool(1)
+	if err := p.acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	granted := make(chan int64)
+	enqueue := func(target int64) {
+		n := p.waiting()
+		go func() {
+			if err := p.acquire(context.Background(), target); err != nil {
+				t.Error(err)
+				return
+			}
+			granted <- target
+		}()
+		for p.waiting() == n {
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+	// A big target queues first, a small one behind it.
+	for range 4 {
+		enqueue(1)
+	}
+	for range 2 {
+		enqueue(2)
+	}
+
+	var got []int64
+	for range 6 {
+		p.release()
+		got = append(got, <-granted)
+	}
+	p.release()
+
+	if want := []int64{1, 2, 1, 2, 1, 1}; !slices.Equal(got, want) {
+		t.Errorf("grant order = %v, want %v", got, want)
+	}
+	if p.free != 1 {
+		t.Errorf("free slots = %d, want 1", p.free)
+	}
+}
+
+func TestWorkerPoolAcquireHonorsContext(t *testing.T) {
+	p := newWorkerPool(1)
+	if err := p.acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.acquire(ctx, 2); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if n := p.waiting(); n != 0 {
+		t.Errorf("%d acquires still queued after giving up", n)
+	}
+
+	p.release()
+	if err := p.acquire(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+}
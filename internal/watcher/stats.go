@@ -0,0 +1,73 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+
+	"dockerhub-pull-watcher/internal/dockerhub"
+)
+
+// PollDurationBuckets are the upper bounds, in seconds, of the poll duration
+// histogram.
+var PollDurationBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// PollStats is a histogram of one target's poll durations since startup.
+type PollStats struct {
+	Count   int64
+	Sum     float64 // seconds
+	Buckets []int64 // cumulative counts per PollDurationBuckets bound
+}
+
+// Stats is a snapshot of the watcher's counters since startup.
+type Stats struct {
+	Hub   dockerhub.Stats
+	Polls map[int64]PollStats // by target ID
+
+	// LastTick is when the schedule loop last woke up, started polling a
+	// due target or a poll finished a repo; zero before Start.
+	LastTick time.Time
+	Stopped  bool
+}
+
+type pollStats struct {
+	mu    sync.Mutex
+	polls map[int64]*PollStats
+}
+
+func (ps *pollStats) observe(targetID int64, d time.Duration) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	p := ps.polls[targetID]
+	if p == nil {
+		p = &PollStats{Buckets: make([]int64, len(PollDurationBuckets))}
+		ps.polls[targetID] = p
+	}
+	sec := d.Seconds()
+	p.Count++
+	p.Sum += sec
+	for i, le := range PollDurationBuckets {
+		if sec <= le {
+			p.Buckets[i]++
+		}
+	}
+}
+
+// Stats returns a copy of the watcher's and its Hub client's counters.
+func (s *Service) Stats() Stats {
+	s.pollStats.mu.Lock()
+	defer s.pollStats.mu.Unlock()
+	out := Stats{
+		Hub:     s.dh.Stats(),
+		Polls:   make(map[int64]PollStats, len(s.pollStats.polls)),
+		Stopped: s.isStopping(),
+	}
+	if ns := s.lastTick.Load(); ns > 0 {
+		out.LastTick = time.Unix(0, ns)
+	}
+	for id, p := range s.pollStats.polls {
+		cp := *p
+		cp.Buckets = append([]int64(nil), p.Buckets...)
+		out.Polls[id] = cp
+	}
+	return out
+}
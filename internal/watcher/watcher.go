@@ -3,103 +3,431 @@ package watcher
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"dockerhub-pull-watcher/internal/db"
 	"dockerhub-pull-watcher/internal/dockerhub"
+	"dockerhub-pull-watcher/internal/events"
 )
 
+var (
+	// ErrTargetBusy is returned by PollNow when the target is already being polled.
+	ErrTargetBusy = errors.New("target poll already running")
+	// ErrStopped is returned by PollNow after Stop was called.
+	ErrStopped = errors.New("watcher stopped")
+)
+
+// TickInterval is how often the schedule loop checks for due targets.
+const TickInterval = 10 * time.Second
+
+// Config tunes the watcher. Zero values fall back to defaults.
+type Config struct {
+	Workers     int           // concurrent Hub fetches across all polls; default 4
+	RepoTimeout time.Duration // per repo fetch (and per repo list); default 30s
+
+	Retention       db.RetentionPolicy // compaction is off unless enabled
+	CompactInterval time.Duration      // default 1h
+
+	Events events.Sink // receives watcher events; nil = none
+}
+
+func (c Config) withDefaults() Config {
+	if c.Workers <= 0 {
+		c.Workers = 4
+	}
+	if c.RepoTimeout <= 0 {
+		c.RepoTimeout = 30 * time.Second
+	}
+	if c.CompactInterval <= 0 {
+		c.CompactInterval = time.Hour
+	}
+	return c
+}
+
 type Service struct {
-	db *sql.DB
-	dh *dockerhub.Client
+	db  *sql.DB
+	dh  *dockerhub.Client
+	cfg Config
+
+	workers *workerPool // bounds concurrent fetches, fair across targets
+
+	// ctx is cancelled when Stop gives up waiting; every poll derives from it.
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   chan struct{}
+	wg     sync.WaitGroup // loop + in-flight polls
+
+	mu      sync.Mutex
+	running map[int64]bool // target IDs currently being polled
+	stopped bool
+
+	pollStats pollStats
+	lastTick  atomic.Int64 // unix nanos of the last loop iteration
 }
 
-func NewService(dbx *sql.DB, dh *dockerhub.Client) *Service {
-	return &Service{db: dbx, dh: dh}
+func NewService(dbx *sql.DB, dh *dockerhub.Client, cfg Config) *Service {
+	cfg = cfg.withDefaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Service{
+		db:      dbx,
+		dh:      dh,
+		cfg:     cfg,
+		workers: newWorkerPool(cfg.Workers),
+		ctx:     ctx,
+		cancel:  cancel,
+		stop:    make(chan struct{}),
+		running: map[int64]bool{},
+
+		pollStats: pollStats{polls: map[int64]*PollStats{}},
+	}
 }
 
 func (s *Service) Start() {
+	s.markTick()
+	s.wg.Add(1)
 	go s.loop()
+
+	if s.cfg.Retention.Enabled() {
+		s.wg.Add(1)
+		go s.compactLoop()
+	}
+}
+
+// Stop ends the schedule loop, rejects new polls and waits for in-flight
+// polls to finish. If ctx expires first, in-flight polls are cancelled (their
+// remaining repos are recorded as skipped) and Stop waits for them to write
+// their results before returning ctx's error.
+func (s *Service) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	s.mu.Unlock()
+	close(s.stop)
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-done
+		return ctx.Err()
+	}
 }
 
 func (s *Service) loop() {
-	t := time.NewTicker(10 * time.Second)
+	defer s.wg.Done()
+
+	t := time.NewTicker(TickInterval)
 	defer t.Stop()
 
-	for range t.C {
-		s.runDue()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			s.markTick()
+			s.runDue()
+		}
+	}
+}
+
+// markTick records that the schedule loop or a poll is making progress.
+func (s *Service) markTick() {
+	s.lastTick.Store(time.Now().UnixNano())
+}
+
+// compactLoop applies the retention policy shortly after start and then every
+// CompactInterval.
+func (s *Service) compactLoop() {
+	defer s.wg.Done()
+
+	t := time.NewTimer(time.Minute)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			s.compact()
+			t.Reset(s.cfg.CompactInterval)
+		}
+	}
+}
+
+func (s *Service) compact() {
+	start := time.Now()
+	res, err := db.Compact(s.db, s.cfg.Retention, start)
+	if err != nil {
+		log.Printf("watcher: compaction: %v", err)
+		return
+	}
+	if res.SnapshotsDeleted > 0 || res.RawJSONCleared > 0 {
+		log.Printf("watcher: compaction: %d snapshots deleted, %d deltas added, %d removed, %d raw_json cleared in %s",
+			res.SnapshotsDeleted, res.DeltasAdded, res.DeltasRemoved, res.RawJSONCleared, time.Since(start).Round(time.Millisecond))
 	}
 }
 
 func (s *Service) runDue() {
+	if _, err := s.PollDue(s.ctx); err != nil && !errors.Is(err, ErrStopped) {
+		log.Printf("watcher: %v", err)
+	}
+}
+
+// PollDue polls every enabled target whose interval has elapsed and returns
+// their runs once all are done. Due targets are polled concurrently and share
+// the worker pool and the client's rate limit fairly, so one large target
+// doesn't hold up the others. The schedule loop calls it every TickInterval;
+// "poll-once" calls it once. Targets already being polled are skipped.
+func (s *Service) PollDue(ctx context.Context) ([]db.TargetRun, error) {
 	targets, err := db.ListTargets(s.db)
 	if err != nil {
-		log.Printf("watcher: list targets: %v", err)
-		return
+		return nil, fmt.Errorf("list targets: %w", err)
 	}
 
 	now := time.Now().UTC()
 
+	var due []db.Target
 	for _, tg := range targets {
 		if !tg.Enabled {
 			continue
 		}
-
-		due := true
 		if tg.LastRunUTC != "" {
-			if last, err := time.Parse(time.RFC3339, tg.LastRunUTC); err == nil {
-				due = now.Sub(last) >= time.Duration(tg.IntervalSeconds)*time.Second
+			if last, err := time.Parse(time.RFC3339, tg.LastRunUTC); err == nil &&
+				now.Sub(last) < time.Duration(tg.IntervalSeconds)*time.Second {
+				continue
 			}
 		}
+		due = append(due, tg)
+	}
 
-		if !due {
-			continue
+	results := make([]db.TargetRun, len(due))
+	errs := make([]error, len(due))
+	s.markTick()
+	var wg sync.WaitGroup
+	for i, tg := range due {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = s.run(ctx, tg, db.TriggerSchedule)
+		}()
+	}
+	wg.Wait()
+
+	var runs []db.TargetRun
+	stopped := false
+	for i := range due {
+		switch {
+		case errs[i] == nil:
+			runs = append(runs, results[i])
+		case errors.Is(errs[i], ErrStopped):
+			stopped = true
 		}
+	}
+	if stopped {
+		return runs, ErrStopped
+	}
+	return runs, nil
+}
 
-		err := s.pollTarget(tg)
-		db.UpdateTargetRun(s.db, tg.ID, now.Format(time.RFC3339), errString(err))
+// PollNow polls one target immediately, regardless of its schedule or
+// enabled flag. It fails with ErrTargetBusy if a poll of the same target is
+// already in flight.
+func (s *Service) PollNow(ctx context.Context, targetID int64) (db.TargetRun, error) {
+	tg, err := db.GetTarget(s.db, targetID)
+	if err != nil {
+		return db.TargetRun{}, err
 	}
+	return s.run(ctx, tg, db.TriggerManual)
 }
 
-func (s *Service) pollTarget(tg db.Target) error {
-	// Poll once immediately when due.
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// run polls tg unless it is already running, then records the run in
+// target_runs and on the target itself.
+func (s *Service) run(ctx context.Context, tg db.Target, trigger string) (db.TargetRun, error) {
+	if err := s.acquire(tg.ID); err != nil {
+		return db.TargetRun{}, err
+	}
+	defer s.release(tg.ID)
+
+	// Manual polls run on the request's context; tie them to shutdown too.
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	defer context.AfterFunc(s.ctx, cancel)()
+
+	start := time.Now().UTC()
+	run := db.TargetRun{TargetID: tg.ID, Trigger: trigger, StartedUTC: start.Format(time.RFC3339)}
+
+	repos, err := s.pollTarget(ctx, tg)
+	end := time.Now().UTC()
+	run.Repos = repos
+	run.Error = errString(err)
+	run.FinishedUTC = end.Format(time.RFC3339)
+	run.DurationMS = end.Sub(start).Milliseconds()
+	run.Count()
+	s.pollStats.observe(tg.ID, end.Sub(start))
+
+	if id, err := db.InsertTargetRun(s.db, run); err != nil {
+		log.Printf("watcher: record run of target %d: %v", tg.ID, err)
+	} else {
+		run.ID = id
+	}
+
+	// Surface partial failures on the target card too.
+	lastErr := run.Error
+	if lastErr == "" && run.ReposFailed+run.ReposSkipped > 0 {
+		lastErr = fmt.Sprintf("%d failed, %d skipped of %d repos", run.ReposFailed, run.ReposSkipped, len(run.Repos))
+	}
+	db.UpdateTargetRun(s.db, tg.ID, run.StartedUTC, lastErr)
+
+	if run.Error != "" || run.ReposFailed > 0 {
+		s.emitRunFailed(tg, run)
+	}
+	return run, nil
+}
+
+func (s *Service) acquire(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return ErrStopped
+	}
+	if s.running[id] {
+		return ErrTargetBusy
+	}
+	s.running[id] = true
+	s.wg.Add(1)
+	return nil
+}
+
+func (s *Service) release(id int64) {
+	s.mu.Lock()
+	delete(s.running, id)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+func (s *Service) isStopping() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+// fetched is the Hub answer for one repo of a poll.
+type fetched struct {
+	idx  int
+	info dockerhub.RepoInfo
+	raw  string
+	err  error
+}
+
+func (s *Service) pollTarget(ctx context.Context, tg db.Target) ([]db.TargetRunRepo, error) {
+	ctx = dockerhub.WithRateKey(ctx, fmt.Sprintf("target-%d", tg.ID))
 
 	var repos []string
 	if tg.Mode == "user" {
-		list, err := s.dh.ListRepos(ctx, tg.Namespace)
+		lctx, cancel := context.WithTimeout(ctx, s.cfg.RepoTimeout)
+		list, err := s.dh.ListRepos(lctx, tg.Namespace)
+		cancel()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		repos = list
+		s.syncNamespace(tg, list)
 	} else {
 		repos = tg.ReposList()
 	}
 
 	now := time.Now()
+	outcomes := make([]db.TargetRunRepo, len(repos))
+	results := make(chan fetched)
 
-	for _, repo := range repos {
-		info, raw, err := s.dh.GetRepo(ctx, tg.Namespace, repo)
-		if err != nil {
+	// Fetch in parallel, bounded by the service-wide worker pool. Each repo
+	// gets its own timeout; waiting for a worker slot doesn't count.
+	for i, repo := range repos {
+		go func() {
+			if err := s.workers.acquire(ctx, tg.ID); err != nil {
+				results <- fetched{idx: i, err: err}
+				return
+			}
+			defer s.workers.release()
+
+			rctx, cancel := context.WithTimeout(ctx, s.cfg.RepoTimeout)
+			defer cancel()
+			info, raw, err := s.dh.GetRepo(rctx, tg.Namespace, repo)
+			results <- fetched{idx: i, info: info, raw: raw, err: err}
+		}()
+	}
+
+	// Write from this goroutine only; db.Open allows a single connection.
+	for range repos {
+		f := <-results
+		repo := repos[f.idx]
+		out := db.TargetRunRepo{Repo: repo, Status: db.RepoStatusFailed}
+
+		switch {
+		case f.err != nil && ctx.Err() != nil:
+			// Poll was cancelled: don't count the rest as Hub failures.
+			out.Status = db.RepoStatusSkipped
+			out.Error = f.err.Error()
+		case f.err != nil:
 			// continue (partial success ok)
-			log.Printf("watcher: %s/%s: %v", tg.Namespace, repo, err)
-			continue
+			log.Printf("watcher: %s/%s: %v", tg.Namespace, repo, f.err)
+			out.Error = f.err.Error()
+			var se *dockerhub.StatusError
+			if errors.As(f.err, &se) && se.Code == http.StatusNotFound {
+				s.markGone(tg, repo)
+			}
+		default:
+			if err := s.store(tg, repo, now, f.info, f.raw); err != nil {
+				out.Error = err.Error()
+			} else {
+				out.Status = db.RepoStatusOK
+				out.PullCount = f.info.PullCount
+			}
 		}
+		outcomes[f.idx] = out
+		// A big target can take longer than readiness allows for one tick.
+		s.markTick()
+	}
 
-		repoID, err := db.EnsureRepo(s.db, tg.Namespace, repo)
-		if err != nil {
-			log.Printf("watcher: ensure repo %s/%s: %v", tg.Namespace, repo, err)
-			continue
-		}
+	return outcomes, nil
+}
 
-		if err := db.InsertSnapshotAndDelta(s.db, repoID, now, info.PullCount, info.StarCount, info.LastUpdated, info.IsPrivate, raw); err != nil {
-			log.Printf("watcher: insert snapshot %s/%s: %v", tg.Namespace, repo, err)
-			continue
-		}
+func (s *Service) store(tg db.Target, repo string, now time.Time, info dockerhub.RepoInfo, raw string) error {
+	namespace := tg.Namespace
+	repoID, err := db.EnsureRepo(s.db, namespace, repo)
+	if err != nil {
+		log.Printf("watcher: ensure repo %s/%s: %v", namespace, repo, err)
+		return err
+	}
+
+	res, err := db.InsertSnapshotAndDelta(s.db, repoID, now, info.PullCount, info.StarCount, info.LastUpdated, info.IsPrivate, raw)
+	if err != nil {
+		log.Printf("watcher: insert snapshot %s/%s: %v", namespace, repo, err)
+		return err
 	}
 
+	r := db.Repo{ID: repoID, Namespace: namespace, Name: repo}
+	s.snapshotEvents(tg, r, now, info, res)
+	if res.Inserted {
+		s.evaluateAlerts(tg, r, now, info, res)
+	}
 	return nil
 }
 
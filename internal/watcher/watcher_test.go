@@ -0,0 +1,289 @@
+package watcher_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/dockerhub"
+	"dockerhub-pull-watcher/internal/dockerhub/dockerhubtest"
+	"dockerhub-pull-watcher/internal/events"
+	"dockerhub-pull-watcher/internal/watcher"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (r *recorder) Emit(e events.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *recorder) types() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := map[string]int{}
+	for _, e := range r.events {
+		out[e.Type]++
+	}
+	return out
+}
+
+// setup returns a migrated temp database, a fake Hub and a watcher using
+// both. Everything is closed when the test ends.
+func setup(t *testing.T) (*sql.DB, *dockerhubtest.Server, *watcher.Service, *recorder) {
+	t.Helper()
+	d, err := db.Open(filepath.Join(t.TempDir(), "pulls.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := db.Migrate(d); err != nil {
+		t.Fatal(err)
+	}
+
+	hub := dockerhubtest.NewServer()
+	t.Cleanup(hub.Close)
+	dh := dockerhub.NewClient(dockerhub.ClientConfig{
+		BaseURL:     hub.URL(),
+		HTTPTimeout: 5 * time.Second,
+		Retry:       dockerhub.RetryPolicy{MaxAttempts: 1},
+	})
+
+	rec := &recorder{}
+	w := watcher.NewService(d, dh, watcher.Config{Workers: 2, RepoTimeout: 5 * time.Second, Events: rec})
+	t.Cleanup(func() { _ = w.Stop(context.Background()) })
+	return d, hub, w, rec
+}
+
+func TestPollStoresSnapshotsAndDeltas(t *testing.T) {
+	d, hub, w, _ := setup(t)
+	hub.AddRepo("floibach", "pullpulse", dockerhubtest.Repo{PullCounts: []int64{142}})
+
+	id, err := db.UpsertTarget(d, db.Target{Name: "pp", Mode: "repos", Namespace: "floibach", ReposCSV: "pullpulse", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoID, err := db.EnsureRepo(d, "floibach", "pullpulse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An earlier snapshot, so the poll produces a delta.
+	earlier := time.Now().Add(-time.Hour)
+	if _, err := db.InsertSnapshotAndDelta(d, repoID, earlier, 100, 0, "", false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := w.PollNow(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.ReposOK != 1 || run.ReposFailed != 0 || run.Error != "" {
+		t.Fatalf("run = %+v, want 1 ok repo", run)
+	}
+	if run.Repos[0].PullCount != 142 {
+		t.Errorf("run pull_count = %d, want 142", run.Repos[0].PullCount)
+	}
+
+	snaps, err := db.ListRepoSnapshots(d, repoID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 2 || snaps[0].PullCount != 142 {
+		t.Fatalf("snapshots = %+v, want the polled 142 on top of 100", snaps)
+	}
+
+	deltas, err := db.ListRepoDeltas(d, repoID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deltas) != 1 || deltas[0].Delta != 42 {
+		t.Fatalf("deltas = %+v, want one delta of 42", deltas)
+	}
+	if deltas[0].Seconds < 3590 || deltas[0].PerHour < 41 || deltas[0].PerHour > 43 {
+		t.Errorf("delta = %+v, want about 42 over one hour", deltas[0])
+	}
+
+	tg, err := db.GetTarget(d, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tg.LastRunUTC == "" || tg.LastError != "" {
+		t.Errorf("target after run = %+v, want last run set and no error", tg)
+	}
+	runs, err := db.QueryTargetRuns(d, id, db.ListOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].ID != run.ID {
+		t.Errorf("recorded runs = %+v, want the one run", runs)
+	}
+}
+
+func TestPollMarksMissingReposGone(t *testing.T) {
+	d, hub, w, rec := setup(t)
+	hub.SetPageSize(1)
+	hub.AddRepo("ns", "a", dockerhubtest.Repo{PullCounts: []int64{1}})
+	hub.AddRepo("ns", "b", dockerhubtest.Repo{PullCounts: []int64{2}})
+
+	id, err := db.UpsertTarget(d, db.Target{Name: "ns", Mode: "user", Namespace: "ns", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err := w.PollNow(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.ReposOK != 2 {
+		t.Fatalf("first run = %+v, want 2 ok repos", run)
+	}
+
+	// b disappears from the listing but is still fetched in repos mode.
+	hub.RemoveRepo("ns", "b")
+	id2, err := db.UpsertTarget(d, db.Target{Name: "b", Mode: "repos", Namespace: "ns", ReposCSV: "b", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err = w.PollNow(context.Background(), id2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.ReposFailed != 1 || run.Repos[0].Status != db.RepoStatusFailed {
+		t.Fatalf("run = %+v, want the missing repo failed", run)
+	}
+
+	r, err := db.FindRepo(d, "ns", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.GoneUTC == "" {
+		t.Error("repo b is not marked gone")
+	}
+	types := rec.types()
+	if types[events.TypeRepoGone] != 1 || types[events.TypeRunFailed] != 1 {
+		t.Errorf("events = %v, want one repo.gone and one target.run_failed", types)
+	}
+}
+
+func TestPollDuePollsDueTargetsTogether(t *testing.T) {
+	d, hub, w, _ := setup(t)
+	var repos []string
+	for i := range 20 {
+		name := fmt.Sprintf("r%d", i)
+		hub.AddRepo("big", name, dockerhubtest.Repo{PullCounts: []int64{1}})
+		repos = append(repos, name)
+	}
+	hub.AddRepo("small", "r", dockerhubtest.Repo{PullCounts: []int64{1}})
+
+	big, err := db.UpsertTarget(d, db.Target{Name: "big", Mode: "repos", Namespace: "big", ReposCSV: strings.Join(repos, ","), IntervalSeconds: 60, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := db.UpsertTarget(d, db.Target{Name: "small", Mode: "repos", Namespace: "small", ReposCSV: "r", IntervalSeconds: 60, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.UpsertTarget(d, db.Target{Name: "off", Mode: "repos", Namespace: "small", ReposCSV: "r", IntervalSeconds: 60}); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := w.PollDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := map[int64]int{}
+	for _, r := range runs {
+		ok[r.TargetID] = r.ReposOK
+	}
+	if len(runs) != 2 || ok[big] != 20 || ok[small] != 1 {
+		t.Fatalf("runs = %+v, want big and small with every repo ok", runs)
+	}
+
+	// Both just ran, so nothing is due.
+	if runs, err := w.PollDue(context.Background()); err != nil || len(runs) != 0 {
+		t.Errorf("second PollDue = %d runs, %v; want none", len(runs), err)
+	}
+}
+
+func TestPollCountsAsProgress(t *testing.T) {
+	d, hub, w, _ := setup(t)
+	for _, name := range []string{"a", "b", "c"} {
+		hub.AddRepo("ns", name, dockerhubtest.Repo{PullCounts: []int64{1}})
+	}
+	id, err := db.UpsertTarget(d, db.Target{Name: "ns", Mode: "repos", Namespace: "ns", ReposCSV: "a,b,c", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the schedule loop, only the poll itself records progress.
+	if tick := w.Stats().LastTick; !tick.IsZero() {
+		t.Fatalf("LastTick = %s before any work", tick)
+	}
+	start := time.Now()
+	if _, err := w.PollNow(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if tick := w.Stats().LastTick; tick.Before(start) {
+		t.Errorf("LastTick = %s, want it updated by the poll", tick)
+	}
+}
+
+func TestListingLeavesDirectlyTrackedReposAlone(t *testing.T) {
+	d, hub, w, rec := setup(t)
+	for _, name := range []string{"a", "b", "c"} {
+		hub.AddRepo("ns", name, dockerhubtest.Repo{PullCounts: []int64{1}})
+	}
+	user, err := db.UpsertTarget(d, db.Target{Name: "ns", Mode: "user", Namespace: "ns", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct, err := db.UpsertTarget(d, db.Target{Name: "b", Mode: "repos", Namespace: "ns", ReposCSV: "b", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.UpsertTarget(d, db.Target{Name: "c", Mode: "repos", Namespace: "ns", ReposCSV: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.PollNow(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	// b and c drop out of the listing. Only b is fetched directly by an
+	// enabled target; c's target is disabled.
+	hub.RemoveRepo("ns", "b")
+	hub.RemoveRepo("ns", "c")
+	if _, err := w.PollNow(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	gone := func(name string) bool {
+		t.Helper()
+		r, err := db.FindRepo(d, "ns", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.GoneUTC != ""
+	}
+	if gone("b") || !gone("c") {
+		t.Errorf("after the listing: b gone = %t, c gone = %t; want only c", gone("b"), gone("c"))
+	}
+
+	// The direct fetch decides for b.
+	if _, err := w.PollNow(context.Background(), direct); err != nil {
+		t.Fatal(err)
+	}
+	if !gone("b") {
+		t.Error("b not marked gone after a 404")
+	}
+	if n := rec.types()[events.TypeRepoGone]; n != 2 {
+		t.Errorf("%d repo.gone events, want 2", n)
+	}
+}
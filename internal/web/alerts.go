@@ -0,0 +1,315 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dockerhub-pull-watcher/internal/db"
+)
+
+func (h *Handlers) AlertsList(w http.ResponseWriter, r *http.Request) {
+	rules, err := db.ListAlertRules(h.db)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	firings, err := db.QueryAlertFirings(h.db, 0, db.ListOpts{Limit: 50})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	tpl, err := h.tpl.Page("alerts_list.html")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	_ = tpl.ExecuteTemplate(w, "alerts_list_page", map[string]any{
+		"Title":   "Alerts",
+		"Rules":   rules,
+		"Firings": firings,
+	})
+}
+
+func (h *Handlers) AlertNew(w http.ResponseWriter, r *http.Request) {
+	rule := db.AlertRule{
+		Namespace: r.URL.Query().Get("namespace"),
+		Repo:      r.URL.Query().Get("repo"),
+		Metric:    db.MetricPullCount,
+		Op:        ">=",
+		Enabled:   true,
+	}
+	h.renderAlertEdit(w, rule, true)
+}
+
+func (h *Handlers) AlertEditOrUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		h.saveAlert(w, r)
+		return
+	}
+
+	id, _ := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	rule, err := db.GetAlertRule(h.db, id)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	h.renderAlertEdit(w, rule, false)
+}
+
+func (h *Handlers) renderAlertEdit(w http.ResponseWriter, rule db.AlertRule, isNew bool) {
+	tpl, err := h.tpl.Page("alert_edit.html")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	_ = tpl.ExecuteTemplate(w, "alert_edit_page", map[string]any{
+		"Title":   "Edit Alert",
+		"IsNew":   isNew,
+		"Rule":    rule,
+		"Metrics": db.AlertMetrics,
+		"Ops":     db.AlertOps,
+	})
+}
+
+func (h *Handlers) saveAlert(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	threshold, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue("threshold")), 64)
+	if err != nil {
+		http.Error(w, "threshold must be a number", 400)
+		return
+	}
+	windowSec, _ := strconv.ParseInt(r.FormValue("window_seconds"), 10, 64)
+	cooldownSec, _ := strconv.ParseInt(r.FormValue("cooldown_seconds"), 10, 64)
+
+	rule := db.AlertRule{
+		ID:              id,
+		Name:            strings.TrimSpace(r.FormValue("name")),
+		Namespace:       strings.TrimSpace(r.FormValue("namespace")),
+		Repo:            strings.TrimSpace(r.FormValue("repo")),
+		Metric:          r.FormValue("metric"),
+		Op:              r.FormValue("op"),
+		Threshold:       threshold,
+		WindowSeconds:   windowSec,
+		CooldownSeconds: cooldownSec,
+		Enabled:         r.FormValue("enabled") == "on",
+	}
+	if err := rule.Validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if _, err := db.UpsertAlertRule(h.db, rule); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, err.Error(), 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	http.Redirect(w, r, "/alerts", http.StatusFound)
+}
+
+func (h *Handlers) AlertDelete(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err := db.DeleteAlertRule(h.db, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, err.Error(), 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	http.Redirect(w, r, "/alerts", http.StatusFound)
+}
+
+func (h *Handlers) AlertFirings(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	rule, err := db.GetAlertRule(h.db, id)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	firings, err := db.QueryAlertFirings(h.db, id, db.ListOpts{Limit: 100})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	tpl, err := h.tpl.Page("alert_firings.html")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	_ = tpl.ExecuteTemplate(w, "alert_firings_page", map[string]any{
+		"Title":   "Firings " + rule.Name,
+		"Rule":    rule,
+		"Firings": firings,
+	})
+}
+
+// apiAlertInput is the request body for create/update. Enabled is a
+// pointer so omitting it enables new rules.
+type apiAlertInput struct {
+	Name            string  `json:"name"`
+	Namespace       string  `json:"namespace"`
+	Repo            string  `json:"repo"`
+	Metric          string  `json:"metric"`
+	Op              string  `json:"op"`
+	Threshold       float64 `json:"threshold"`
+	WindowSeconds   int64   `json:"window_seconds"`
+	CooldownSeconds int64   `json:"cooldown_seconds"`
+	Enabled         *bool   `json:"enabled"`
+}
+
+func decodeAlertInput(r *http.Request, id int64) (db.AlertRule, error) {
+	var in apiAlertInput
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		return db.AlertRule{}, errors.New("invalid JSON body: " + err.Error())
+	}
+	rule := db.AlertRule{
+		ID:              id,
+		Name:            strings.TrimSpace(in.Name),
+		Namespace:       strings.TrimSpace(in.Namespace),
+		Repo:            strings.TrimSpace(in.Repo),
+		Metric:          in.Metric,
+		Op:              in.Op,
+		Threshold:       in.Threshold,
+		WindowSeconds:   in.WindowSeconds,
+		CooldownSeconds: in.CooldownSeconds,
+		Enabled:         true,
+	}
+	if in.Enabled != nil {
+		rule.Enabled = *in.Enabled
+	}
+	if err := rule.Validate(); err != nil {
+		return db.AlertRule{}, err
+	}
+	return rule, nil
+}
+
+func (h *Handlers) APIAlertsList(w http.ResponseWriter, r *http.Request) {
+	rules, err := db.ListAlertRules(h.db)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if rules == nil {
+		rules = []db.AlertRule{}
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: rules, Limit: len(rules)})
+}
+
+func (h *Handlers) APIAlertCreate(w http.ResponseWriter, r *http.Request) {
+	rule, err := decodeAlertInput(r, 0)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := db.UpsertAlertRule(h.db, rule)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	created, err := db.GetAlertRule(h.db, id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handlers) APIAlertGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rule, err := db.GetAlertRule(h.db, id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func (h *Handlers) APIAlertUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := db.GetAlertRule(h.db, id); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	rule, err := decodeAlertInput(r, id)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := db.UpsertAlertRule(h.db, rule); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	updated, err := db.GetAlertRule(h.db, id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (h *Handlers) APIAlertDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := db.DeleteAlertRule(h.db, id); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deleted": id})
+}
+
+// APIAlertFirings lists the firings of one rule ({id}) or, on
+// /api/v1/alerts/firings, of all rules.
+func (h *Handlers) APIAlertFirings(w http.ResponseWriter, r *http.Request) {
+	var id int64
+	if r.PathValue("id") != "" {
+		var err error
+		if id, err = pathID(r); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, err := db.GetAlertRule(h.db, id); err != nil {
+			writeDBError(w, err)
+			return
+		}
+	}
+	opts, err := parseListOpts(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	firings, err := db.QueryAlertFirings(h.db, id, opts)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: firings, Limit: opts.Limit, Offset: opts.Offset})
+}
@@ -0,0 +1,502 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/watcher"
+)
+
+const (
+	apiDefaultLimit = 100
+	apiMaxLimit     = 1000
+)
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiList struct {
+	Data   any `json:"data"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// apiTargetInput is the request body for create/update. Enabled is a
+// pointer so that omitting it keeps new targets enabled.
+type apiTargetInput struct {
+	Name            string   `json:"name"`
+	Mode            string   `json:"mode"`
+	Namespace       string   `json:"namespace"`
+	Repos           []string `json:"repos"`
+	ReposCSV        string   `json:"repos_csv"`
+	IntervalSeconds int64    `json:"interval_seconds"`
+	Enabled         *bool    `json:"enabled"`
+}
+
+func (in apiTargetInput) target() db.Target {
+	repos := strings.TrimSpace(in.ReposCSV)
+	if len(in.Repos) > 0 {
+		repos = strings.Join(in.Repos, ",")
+	}
+	t := db.Target{
+		Name:            strings.TrimSpace(in.Name),
+		Mode:            strings.TrimSpace(in.Mode),
+		Namespace:       strings.TrimSpace(in.Namespace),
+		ReposCSV:        strings.Join(db.Target{ReposCSV: repos}.ReposList(), ","),
+		IntervalSeconds: in.IntervalSeconds,
+		Enabled:         true,
+	}
+	if in.Enabled != nil {
+		t.Enabled = *in.Enabled
+	}
+	return t
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Error: msg})
+}
+
+// writeDBError maps sql.ErrNoRows to 404, db.ErrTargetReadOnly to 403,
+// everything else to 500.
+func writeDBError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if errors.Is(err, db.ErrTargetReadOnly) {
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, err.Error())
+}
+
+func pathID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.New("invalid id")
+	}
+	return id, nil
+}
+
+// parseListOpts reads from, to, limit and offset from the query string.
+// from/to accept RFC3339 timestamps or plain dates (YYYY-MM-DD); a date in
+// to includes that whole day.
+func parseListOpts(r *http.Request) (db.ListOpts, error) {
+	q := r.URL.Query()
+	opts := db.ListOpts{Limit: apiDefaultLimit}
+
+	var err error
+	if opts.From, err = db.ParseTime(q.Get("from"), false); err != nil {
+		return opts, errors.New("invalid from: " + err.Error())
+	}
+	if opts.To, err = db.ParseTime(q.Get("to"), true); err != nil {
+		return opts, errors.New("invalid to: " + err.Error())
+	}
+	if !opts.From.IsZero() && !opts.To.IsZero() && opts.To.Before(opts.From) {
+		return opts, errors.New("to must not be before from")
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return opts, errors.New("invalid limit")
+		}
+		opts.Limit = min(n, apiMaxLimit)
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, errors.New("invalid offset")
+		}
+		opts.Offset = n
+	}
+	return opts, nil
+}
+
+func decodeTargetInput(r *http.Request) (db.Target, error) {
+	var in apiTargetInput
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		return db.Target{}, errors.New("invalid JSON body: " + err.Error())
+	}
+	t := in.target()
+	if err := t.Validate(); err != nil {
+		return db.Target{}, err
+	}
+	return t, nil
+}
+
+func (h *Handlers) APITargetsList(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOpts(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	targets, err := db.QueryTargets(h.db, opts)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if targets == nil {
+		targets = []db.Target{}
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: targets, Limit: opts.Limit, Offset: opts.Offset})
+}
+
+func (h *Handlers) APITargetCreate(w http.ResponseWriter, r *http.Request) {
+	t, err := decodeTargetInput(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := db.UpsertTarget(h.db, t)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	created, err := db.GetTarget(h.db, id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handlers) APITargetGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t, err := db.GetTarget(h.db, id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (h *Handlers) APITargetUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := db.GetTarget(h.db, id); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	t, err := decodeTargetInput(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t.ID = id
+	if _, err := db.UpsertTarget(h.db, t); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	updated, err := db.GetTarget(h.db, id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (h *Handlers) APITargetDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	purge, _ := strconv.ParseBool(r.URL.Query().Get("purge"))
+	purged, err := db.DeleteTarget(h.db, id, purge)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deleted": id, "purged_repos": purged})
+}
+
+func (h *Handlers) APITargetPoll(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	res, err := h.w.PollNow(r.Context(), id)
+	if errors.Is(err, watcher.ErrTargetBusy) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, watcher.ErrStopped) {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handlers) APITargetRuns(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts, err := parseListOpts(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := db.GetTarget(h.db, id); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	runs, err := db.QueryTargetRuns(h.db, id, opts)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if runs == nil {
+		runs = []db.TargetRun{}
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: runs, Limit: opts.Limit, Offset: opts.Offset})
+}
+
+type apiBulkEnable struct {
+	IDs     []int64 `json:"ids"`
+	Enabled *bool   `json:"enabled"`
+}
+
+func (h *Handlers) APITargetsBulkEnable(w http.ResponseWriter, r *http.Request) {
+	var in apiBulkEnable
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if len(in.IDs) == 0 || in.Enabled == nil {
+		writeJSONError(w, http.StatusBadRequest, "ids and enabled are required")
+		return
+	}
+	n, err := db.SetTargetsEnabled(h.db, in.IDs, *in.Enabled)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"updated": n, "enabled": *in.Enabled})
+}
+
+func (h *Handlers) APIReposList(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOpts(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	repos, err := db.QueryRepos(h.db, strings.TrimSpace(r.URL.Query().Get("namespace")), opts)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if repos == nil {
+		repos = []db.Repo{}
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: repos, Limit: opts.Limit, Offset: opts.Offset})
+}
+
+func (h *Handlers) APIRepoGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	repo, err := db.GetRepo(h.db, id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, repo)
+}
+
+func (h *Handlers) APIRepoSnapshots(w http.ResponseWriter, r *http.Request) {
+	id, opts, ok := h.apiRepoListParams(w, r)
+	if !ok {
+		return
+	}
+	snaps, err := db.QueryRepoSnapshots(h.db, id, opts)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if snaps == nil {
+		snaps = []db.RepoSnapshot{}
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: snaps, Limit: opts.Limit, Offset: opts.Offset})
+}
+
+func (h *Handlers) APIRepoDeltas(w http.ResponseWriter, r *http.Request) {
+	id, opts, ok := h.apiRepoListParams(w, r)
+	if !ok {
+		return
+	}
+	deltas, err := db.QueryRepoDeltas(h.db, id, opts)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if deltas == nil {
+		deltas = []db.RepoDelta{}
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: deltas, Limit: opts.Limit, Offset: opts.Offset})
+}
+
+func (h *Handlers) APIRepoRollups(w http.ResponseWriter, r *http.Request) {
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = db.PeriodDay
+	}
+	if !db.ValidPeriod(period) {
+		writeJSONError(w, http.StatusBadRequest, "period must be one of hour, day, week")
+		return
+	}
+	id, opts, ok := h.apiRepoListParams(w, r)
+	if !ok {
+		return
+	}
+	rollups, err := db.QueryRepoRollups(h.db, id, period, opts)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: rollups, Limit: opts.Limit, Offset: opts.Offset})
+}
+
+// apiRepoListParams resolves the repo id and list options shared by the
+// per-repo list endpoints. It writes the error response itself.
+func (h *Handlers) apiRepoListParams(w http.ResponseWriter, r *http.Request) (int64, db.ListOpts, bool) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return 0, db.ListOpts{}, false
+	}
+	opts, err := parseListOpts(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return 0, db.ListOpts{}, false
+	}
+	if _, err := db.GetRepo(h.db, id); err != nil {
+		writeDBError(w, err)
+		return 0, db.ListOpts{}, false
+	}
+	return id, opts, true
+}
+
+func (h *Handlers) APINotFound(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusNotFound, "no such endpoint")
+}
+
+// chartRanges maps the range selector on the repo page to a lookback window.
+// Zero means all data.
+var chartRanges = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+	"all": 0,
+}
+
+// chartPeriods selects rollups instead of raw snapshots for long ranges.
+var chartPeriods = map[string]string{
+	"30d": db.PeriodHour,
+	"all": db.PeriodDay,
+}
+
+// chartMaxPoints caps each series so "all" stays cheap to render.
+const chartMaxPoints = 1000
+
+type apiChart struct {
+	Range   string           `json:"range"`
+	Pulls   []db.SeriesPoint `json:"pulls"`
+	PerHour []db.SeriesPoint `json:"per_hour"`
+}
+
+func (h *Handlers) APIRepoChart(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rng := r.URL.Query().Get("range")
+	if rng == "" {
+		rng = "7d"
+	}
+	window, ok := chartRanges[rng]
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "range must be one of 24h, 7d, 30d, all")
+		return
+	}
+	if _, err := db.GetRepo(h.db, id); err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	var from time.Time
+	if window > 0 {
+		from = time.Now().UTC().Add(-window)
+	}
+
+	var pulls, perHour []db.SeriesPoint
+	if period, ok := chartPeriods[rng]; ok {
+		pulls, perHour, err = db.RollupSeries(h.db, id, period, from)
+	} else {
+		pulls, err = db.PullCountSeries(h.db, id, from)
+		if err == nil {
+			perHour, err = db.PerHourSeries(h.db, id, from)
+		}
+	}
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiChart{
+		Range:   rng,
+		Pulls:   downsample(pulls, chartMaxPoints),
+		PerHour: downsample(perHour, chartMaxPoints),
+	})
+}
+
+// downsample keeps every n-th point so that at most limit points remain
+// (plus the newest point, which is always kept).
+func downsample(pts []db.SeriesPoint, limit int) []db.SeriesPoint {
+	if len(pts) <= limit {
+		return pts
+	}
+	step := (len(pts) + limit - 1) / limit
+	out := make([]db.SeriesPoint, 0, limit+1)
+	for i := 0; i < len(pts); i += step {
+		out = append(out, pts[i])
+	}
+	if last := pts[len(pts)-1]; out[len(out)-1] != last {
+		out = append(out, last)
+	}
+	return out
+}
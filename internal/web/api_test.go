@@ -0,0 +1,96 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dockerhub-pull-watcher/internal/db"
+)
+
+// newTestRouter returns a router on a migrated temp database, without a
+// watcher, templates or backups.
+func newTestRouter(t *testing.T) (*sql.DB, http.Handler) {
+	t.Helper()
+	dbx, err := db.Open(filepath.Join(t.TempDir(), "pulls.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbx.Close() })
+	if err := db.Migrate(dbx); err != nil {
+		t.Fatal(err)
+	}
+	return dbx, NewRouter(dbx, nil, nil, nil)
+}
+
+func TestAPITargetsListPaginates(t *testing.T) {
+	dbx, router := newTestRouter(t)
+	for i := range 5 {
+		if _, err := db.UpsertTarget(dbx, db.Target{Name: fmt.Sprintf("t%d", i), Mode: "user", Namespace: fmt.Sprintf("ns%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for url, want := range map[string][]string{
+		"/api/v1/targets":                  {"t4", "t3", "t2", "t1", "t0"},
+		"/api/v1/targets?limit=2":          {"t4", "t3"},
+		"/api/v1/targets?limit=2&offset=2": {"t2", "t1"},
+		"/api/v1/targets?offset=4":         {"t0"},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		var body struct {
+			Data   []db.Target `json:"data"`
+			Limit  int         `json:"limit"`
+			Offset int         `json:"offset"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, %v: %s", url, rec.Code, err, rec.Body)
+		}
+		var names []string
+		for _, tg := range body.Data {
+			names = append(names, tg.Name)
+		}
+		if fmt.Sprint(names) != fmt.Sprint(want) {
+			t.Errorf("%s: targets = %v, want %v", url, names, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/targets?limit=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("limit=x: status %d, want 400", rec.Code)
+	}
+}
+
+func TestAPITargetsBulkEnableRejectsUnknownFields(t *testing.T) {
+	dbx, router := newTestRouter(t)
+	id, err := db.UpsertTarget(dbx, db.Target{Name: "t", Mode: "user", Namespace: "ns", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(body string) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/targets/bulk", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	// A misspelt "enabled" must not be read as a missing field or ignored.
+	if code := post(fmt.Sprintf(`{"ids":[%d],"enabled":false,"enable":true}`, id)); code != http.StatusBadRequest {
+		t.Errorf("unknown field: status %d, want 400", code)
+	}
+	if tg, err := db.GetTarget(dbx, id); err != nil || !tg.Enabled {
+		t.Fatalf("target = %+v, %v; want it unchanged", tg, err)
+	}
+	if code := post(fmt.Sprintf(`{"ids":[%d],"enabled":false}`, id)); code != http.StatusOK {
+		t.Errorf("status %d, want 200", code)
+	}
+	if tg, err := db.GetTarget(dbx, id); err != nil || tg.Enabled {
+		t.Errorf("target = %+v, %v; want it disabled", tg, err)
+	}
+}
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"dockerhub-pull-watcher/internal/backup"
+	"dockerhub-pull-watcher/internal/db"
+)
+
+func (h *Handlers) BackupsList(w http.ResponseWriter, r *http.Request) {
+	files, err := h.backups.List()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	size, _ := db.Size(h.db)
+	tpl, err := h.tpl.Page("backups.html")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	_ = tpl.ExecuteTemplate(w, "backups_page", map[string]any{
+		"Title":  "Backups",
+		"Config": h.backups.Config(),
+		"Files":  files,
+		"DBSize": backup.File{Size: size}.SizeText(),
+	})
+}
+
+// BackupDownload sends a stored backup (?name=) or, without a name, a fresh
+// one taken now.
+func (h *Handlers) BackupDownload(w http.ResponseWriter, r *http.Request) {
+	if name := r.URL.Query().Get("name"); name != "" {
+		path, err := h.backups.Path(name)
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "backup not found", 404)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		w.Header().Set("Content-Type", "application/vnd.sqlite3")
+		http.ServeFile(w, r, path)
+		return
+	}
+
+	f, err := h.backups.Fresh()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+backup.FileName(time.Now())+`"`)
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	if _, err := f.WriteTo(w); err != nil {
+		log.Printf("web: backup download: %v", err)
+	}
+}
+
+// BackupRun takes a scheduled-style backup into the backup directory now.
+func (h *Handlers) BackupRun(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.backups.Run(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	http.Redirect(w, r, "/backups", http.StatusFound)
+}
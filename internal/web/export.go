@@ -0,0 +1,78 @@
+package web
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/export"
+)
+
+// APIExport streams a dataset as a download:
+// GET /api/v1/export/{dataset}?format=csv|ndjson|json&repo_id=&namespace=&from=&to=&resolution=raw|hour|day|week
+func (h *Handlers) APIExport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := cmp.Or(q.Get("format"), export.FormatCSV)
+	if !export.ValidFormat(format) {
+		writeJSONError(w, http.StatusBadRequest, "format must be one of csv, ndjson, json")
+		return
+	}
+
+	eq := db.ExportQuery{
+		Dataset:    r.PathValue("dataset"),
+		Namespace:  q.Get("namespace"),
+		Resolution: cmp.Or(q.Get("resolution"), db.ResolutionRaw),
+	}
+	if err := eq.Validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if v := q.Get("repo_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid repo_id")
+			return
+		}
+		if _, err := db.GetRepo(h.db, id); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		eq.RepoID = id
+	}
+	opts, err := parseListOpts(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	eq.From, eq.To = opts.From, opts.To
+
+	name := fmt.Sprintf("pullpulse-%s-%s.%s", eq.Dataset, time.Now().UTC().Format(time.DateOnly), format)
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+	cw := &startedWriter{w: w}
+	if _, err := export.Write(h.db, cw, format, eq); err != nil {
+		if !cw.started {
+			w.Header().Del("Content-Disposition")
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		// Too late for an error status; the download ends truncated.
+		log.Printf("web: export %s: %v", eq.Dataset, err)
+	}
+}
+
+// startedWriter records whether any of the response has been written.
+type startedWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (s *startedWriter) Write(p []byte) (int, error) {
+	s.started = true
+	return s.w.Write(p)
+}
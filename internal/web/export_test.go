@@ -0,0 +1,84 @@
+package web
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+)
+
+func TestAPIExport(t *testing.T) {
+	dbx, err := db.Open(filepath.Join(t.TempDir(), "pulls.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbx.Close()
+	if err := db.Migrate(dbx); err != nil {
+		t.Fatal(err)
+	}
+	id, err := db.EnsureRepo(dbx, "ns", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, pulls := range []int64{100, 150, 225} {
+		if _, err := db.InsertSnapshotAndDelta(dbx, id, day.Add(time.Duration(i)*12*time.Hour), pulls, 0, "", false, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := NewRouter(dbx, nil, nil, nil)
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	// A date in to covers that whole day.
+	rec := get("/api/v1/export/snapshots?format=csv&to=2025-01-01")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("status %d, Content-Type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="pullpulse-snapshots-`) {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Errorf("records = %q, %v; want a header and two rows", records, err)
+	}
+
+	for url, want := range map[string]int{
+		"/api/v1/export/snapshots?format=xml":                 http.StatusBadRequest,
+		"/api/v1/export/pulls":                                http.StatusBadRequest,
+		"/api/v1/export/deltas?resolution=month":              http.StatusBadRequest,
+		"/api/v1/export/deltas?repo_id=999":                   http.StatusNotFound,
+		"/api/v1/export/deltas?from=yesterday":                http.StatusBadRequest,
+		"/api/v1/export/deltas?to=2024-12-31&from=2025-01-01": http.StatusBadRequest,
+	} {
+		if rec := get(url); rec.Code != want {
+			t.Errorf("%s: status %d, want %d", url, rec.Code, want)
+		}
+	}
+
+	// A failure before anything was written is a JSON error, not a download.
+	dbx.Close()
+	rec = get("/api/v1/export/deltas?format=ndjson")
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != "" {
+		t.Errorf("Content-Disposition = %q on an error", cd)
+	}
+	var body apiError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" {
+		t.Errorf("body = %q, %v", rec.Body, err)
+	}
+}
@@ -2,22 +2,25 @@ package web
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"dockerhub-pull-watcher/internal/backup"
 	"dockerhub-pull-watcher/internal/db"
 	"dockerhub-pull-watcher/internal/watcher"
 )
 
 type Handlers struct {
-	db  *sql.DB
-	w   *watcher.Service
-	tpl *Templates
+	db      *sql.DB
+	w       *watcher.Service
+	tpl     *Templates
+	backups *backup.Service
 }
 
-func NewHandlers(dbx *sql.DB, w *watcher.Service, tpl *Templates) *Handlers {
-	return &Handlers{db: dbx, w: w, tpl: tpl}
+func NewHandlers(dbx *sql.DB, w *watcher.Service, tpl *Templates, backups *backup.Service) *Handlers {
+	return &Handlers{db: dbx, w: w, tpl: tpl, backups: backups}
 }
 
 func (h *Handlers) Home(w http.ResponseWriter, r *http.Request) {
@@ -113,13 +116,136 @@ func (h *Handlers) updateTarget(w http.ResponseWriter, r *http.Request) {
 	t.ID = id
 
 	_, err := db.UpsertTarget(h.db, t)
+	if err != nil {
+		http.Error(w, err.Error(), targetErrorStatus(err))
+		return
+	}
+	http.Redirect(w, r, "/targets", http.StatusFound)
+}
+
+func (h *Handlers) TargetDelete(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	purge := r.FormValue("purge") == "on"
+
+	if _, err := db.DeleteTarget(h.db, id, purge); err != nil {
+		http.Error(w, err.Error(), targetErrorStatus(err))
+		return
+	}
+	http.Redirect(w, r, "/targets", http.StatusFound)
+}
+
+func (h *Handlers) TargetPoll(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	t, err := db.GetTarget(h.db, id)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	res, err := h.w.PollNow(r.Context(), id)
+	if err != nil {
+		status := 500
+		switch {
+		case errors.Is(err, watcher.ErrTargetBusy):
+			status = 409
+		case errors.Is(err, watcher.ErrStopped):
+			status = 503
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	tpl, err := h.tpl.Page("target_poll.html")
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+
+	_ = tpl.ExecuteTemplate(w, "target_poll_page", map[string]any{
+		"Title":  "Poll " + t.Name,
+		"Target": t,
+		"Result": res,
+	})
+}
+
+func (h *Handlers) TargetRuns(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	t, err := db.GetTarget(h.db, id)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	runs, err := db.QueryTargetRuns(h.db, id, db.ListOpts{Limit: 50})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	tpl, err := h.tpl.Page("target_runs.html")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	_ = tpl.ExecuteTemplate(w, "target_runs_page", map[string]any{
+		"Title":  "Runs " + t.Name,
+		"Target": t,
+		"Runs":   runs,
+	})
+}
+
+func (h *Handlers) TargetsBulk(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	var ids []int64
+	for _, v := range r.Form["ids"] {
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	var enabled bool
+	switch r.FormValue("action") {
+	case "enable":
+		enabled = true
+	case "disable":
+		enabled = false
+	default:
+		http.Error(w, "unknown action", 400)
+		return
+	}
+
+	if _, err := db.SetTargetsEnabled(h.db, ids, enabled); err != nil {
+		http.Error(w, err.Error(), targetErrorStatus(err))
+		return
+	}
 	http.Redirect(w, r, "/targets", http.StatusFound)
 }
 
+// targetErrorStatus maps a failed target change to 404, 403 for targets the
+// config file locks, or 500.
+func targetErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return 404
+	case errors.Is(err, db.ErrTargetReadOnly):
+		return 403
+	}
+	return 500
+}
+
 func parseTargetForm(r *http.Request) db.Target {
 	intervalSec, _ := strconv.ParseInt(r.FormValue("interval_seconds"), 10, 64)
 	enabled := r.FormValue("enabled") == "on"
@@ -0,0 +1,97 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/watcher"
+)
+
+const (
+	// readyTickMaxAge is how long the watcher may go without progress. Polls
+	// count every finished repo, so only a stuck loop or a poll waiting this
+	// long on a single repo trips it.
+	readyTickMaxAge = 30 * watcher.TickInterval
+	// readyHubMaxFailures is how many Hub calls in a row may fail before the
+	// instance reports not ready. 404s don't count.
+	readyHubMaxFailures = 10
+	readyDBTimeout      = 2 * time.Second
+)
+
+type healthCheck struct {
+	Status string `json:"status"` // ok|fail
+	Error  string `json:"error,omitempty"`
+	Detail any    `json:"detail,omitempty"`
+}
+
+type healthStatus struct {
+	Status string                 `json:"status"` // ok|unavailable
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// Healthz is the liveness probe: it answers as long as the process serves HTTP.
+func (h *Handlers) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+// Readyz is the readiness probe. It checks the DB, the schema version, the
+// watcher loop and the Hub client and answers 503 if any check fails.
+func (h *Handlers) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]healthCheck{}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyDBTimeout)
+	defer cancel()
+	if err := h.db.PingContext(ctx); err != nil {
+		checks["db"] = healthCheck{Status: "fail", Error: err.Error()}
+	} else {
+		checks["db"] = healthCheck{Status: "ok"}
+
+		st, err := db.GetMigrationStatus(h.db)
+		switch {
+		case err != nil:
+			checks["migrations"] = healthCheck{Status: "fail", Error: err.Error()}
+		case st.Current != st.Latest:
+			checks["migrations"] = healthCheck{Status: "fail",
+				Error:  fmt.Sprintf("schema at version %d, expected %d", st.Current, st.Latest),
+				Detail: map[string]int{"current": st.Current, "latest": st.Latest}}
+		default:
+			checks["migrations"] = healthCheck{Status: "ok", Detail: map[string]int{"current": st.Current, "latest": st.Latest}}
+		}
+	}
+
+	ws := h.w.Stats()
+	wc := healthCheck{Status: "ok"}
+	switch age := time.Since(ws.LastTick); {
+	case ws.Stopped:
+		wc = healthCheck{Status: "fail", Error: "watcher stopped"}
+	case ws.LastTick.IsZero():
+		wc = healthCheck{Status: "fail", Error: "watcher not started"}
+	case age > readyTickMaxAge:
+		wc = healthCheck{Status: "fail", Error: fmt.Sprintf("watcher loop idle for %s", age.Round(time.Second))}
+	}
+	if !ws.LastTick.IsZero() {
+		wc.Detail = map[string]string{"last_tick_ts_utc": ws.LastTick.UTC().Format(time.RFC3339)}
+	}
+	checks["watcher"] = wc
+
+	hub := ws.Hub
+	hc := healthCheck{Status: "ok", Detail: map[string]any{"consecutive_failures": hub.ConsecutiveFailures}}
+	if hub.ConsecutiveFailures >= readyHubMaxFailures {
+		hc.Status = "fail"
+		hc.Error = fmt.Sprintf("%d Hub calls failed in a row, last: %s", hub.ConsecutiveFailures, hub.LastError)
+	}
+	checks["hub"] = hc
+
+	out := healthStatus{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, c := range checks {
+		if c.Status != "ok" {
+			out.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, out)
+}
@@ -0,0 +1,57 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"dockerhub-pull-watcher/internal/importer"
+)
+
+// maxImportSize caps the size of an uploaded CSV.
+const maxImportSize = 64 << 20
+
+// RepoImport shows the CSV import form (GET) and runs an import (POST).
+func (h *Handlers) RepoImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.renderImport(w, nil, nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	dryRun := r.FormValue("dry_run") == "on"
+
+	var rep importer.Report
+	var err error
+	if f, _, ferr := r.FormFile("file"); ferr == nil {
+		defer f.Close()
+		rep, err = importer.Import(h.db, f, dryRun)
+	} else if text := r.FormValue("csv"); strings.TrimSpace(text) != "" {
+		rep, err = importer.Import(h.db, strings.NewReader(text), dryRun)
+	} else {
+		http.Error(w, "choose a CSV file or paste rows", 400)
+		return
+	}
+	if err != nil && !errors.Is(err, importer.ErrInvalid) {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	h.renderImport(w, &rep, err)
+}
+
+func (h *Handlers) renderImport(w http.ResponseWriter, rep *importer.Report, err error) {
+	tpl, terr := h.tpl.Page("repo_import.html")
+	if terr != nil {
+		http.Error(w, terr.Error(), 500)
+		return
+	}
+	_ = tpl.ExecuteTemplate(w, "repo_import_page", map[string]any{
+		"Title":  "Import history",
+		"Report": rep,
+		"Failed": err != nil,
+	})
+}
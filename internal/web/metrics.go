@@ -0,0 +1,159 @@
+package web
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/watcher"
+)
+
+// Metrics serves pull counts and watcher health in the Prometheus text
+// exposition format.
+func (h *Handlers) Metrics(w http.ResponseWriter, r *http.Request) {
+	repos, err := db.ListRepoLatest(h.db)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	targets, err := db.ListTargets(h.db)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	lastOK, err := db.LastSuccessfulRuns(h.db)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	size, err := db.Size(h.db)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	st := h.w.Stats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	p := promWriter{w: bw}
+
+	p.help("pullpulse_pull_count", "gauge", "Docker Hub pull count at the latest snapshot.")
+	for _, rp := range repos {
+		p.sample("pullpulse_pull_count", float64(rp.PullCount), "namespace", rp.Namespace, "repo", rp.Name)
+	}
+	p.help("pullpulse_star_count", "gauge", "Docker Hub star count at the latest snapshot.")
+	for _, rp := range repos {
+		p.sample("pullpulse_star_count", float64(rp.StarCount), "namespace", rp.Namespace, "repo", rp.Name)
+	}
+	p.help("pullpulse_pulls_per_hour", "gauge", "Pull rate between the two latest snapshots.")
+	for _, rp := range repos {
+		if rp.HasPerHour {
+			p.sample("pullpulse_pulls_per_hour", rp.PerHour, "namespace", rp.Namespace, "repo", rp.Name)
+		}
+	}
+	p.help("pullpulse_last_snapshot_timestamp_seconds", "gauge", "Unix time of the latest snapshot.")
+	for _, rp := range repos {
+		p.sample("pullpulse_last_snapshot_timestamp_seconds", unixSeconds(rp.TSUTC), "namespace", rp.Namespace, "repo", rp.Name)
+	}
+
+	names := make(map[int64]string, len(targets))
+	for _, tg := range targets {
+		names[tg.ID] = tg.Name
+	}
+
+	p.help("pullpulse_target_last_success_timestamp_seconds", "gauge", "Unix time of the target's latest run without errors.")
+	for _, tg := range targets {
+		if ts, ok := lastOK[tg.ID]; ok {
+			p.sample("pullpulse_target_last_success_timestamp_seconds", unixSeconds(ts), "target_id", strconv.FormatInt(tg.ID, 10), "target", tg.Name)
+		}
+	}
+
+	p.help("pullpulse_poll_duration_seconds", "histogram", "Duration of target polls since startup.")
+	ids := make([]int64, 0, len(st.Polls))
+	for id := range st.Polls {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		ps := st.Polls[id]
+		tid, name := strconv.FormatInt(id, 10), names[id]
+		for i, le := range watcher.PollDurationBuckets {
+			p.sample("pullpulse_poll_duration_seconds_bucket", float64(ps.Buckets[i]),
+				"target_id", tid, "target", name, "le", strconv.FormatFloat(le, 'g', -1, 64))
+		}
+		p.sample("pullpulse_poll_duration_seconds_bucket", float64(ps.Count), "target_id", tid, "target", name, "le", "+Inf")
+		p.sample("pullpulse_poll_duration_seconds_sum", ps.Sum, "target_id", tid, "target", name)
+		p.sample("pullpulse_poll_duration_seconds_count", float64(ps.Count), "target_id", tid, "target", name)
+	}
+
+	hub := st.Hub
+	p.help("pullpulse_hub_requests_total", "counter", "Docker Hub HTTP attempts, including retries.")
+	p.sample("pullpulse_hub_requests_total", float64(hub.Requests))
+	p.help("pullpulse_hub_responses_total", "counter", "Docker Hub HTTP responses by status code.")
+	codes := make([]int, 0, len(hub.ByStatus))
+	for c := range hub.ByStatus {
+		codes = append(codes, c)
+	}
+	sort.Ints(codes)
+	for _, c := range codes {
+		p.sample("pullpulse_hub_responses_total", float64(hub.ByStatus[c]), "code", strconv.Itoa(c))
+	}
+	p.help("pullpulse_hub_rate_limited_total", "counter", "Docker Hub 429 responses.")
+	p.sample("pullpulse_hub_rate_limited_total", float64(hub.RateLimited))
+	p.help("pullpulse_hub_retries_total", "counter", "Docker Hub attempts that were retried.")
+	p.sample("pullpulse_hub_retries_total", float64(hub.Retries))
+	p.help("pullpulse_hub_network_errors_total", "counter", "Docker Hub attempts without an HTTP response.")
+	p.sample("pullpulse_hub_network_errors_total", float64(hub.NetworkErrors))
+	p.help("pullpulse_hub_failures_total", "counter", "Docker Hub calls that failed after all attempts.")
+	p.sample("pullpulse_hub_failures_total", float64(hub.Failures))
+	if hub.QuotaRemaining >= 0 {
+		p.help("pullpulse_hub_quota_remaining", "gauge", "Last X-RateLimit-Remaining reported by Docker Hub.")
+		p.sample("pullpulse_hub_quota_remaining", float64(hub.QuotaRemaining))
+	}
+
+	p.help("pullpulse_db_size_bytes", "gauge", "Size of the SQLite database file.")
+	p.sample("pullpulse_db_size_bytes", float64(size))
+}
+
+// promWriter writes the Prometheus text format.
+type promWriter struct {
+	w *bufio.Writer
+}
+
+func (p promWriter) help(name, typ, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one sample; labels are name/value pairs.
+func (p promWriter) sample(name string, v float64, labels ...string) {
+	p.w.WriteString(name)
+	if len(labels) > 0 {
+		p.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				p.w.WriteByte(',')
+			}
+			fmt.Fprintf(p.w, `%s="%s"`, labels[i], promEscaper.Replace(labels[i+1]))
+		}
+		p.w.WriteByte('}')
+	}
+	p.w.WriteByte(' ')
+	p.w.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	p.w.WriteByte('\n')
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func unixSeconds(tsUTC string) float64 {
+	t, err := time.Parse(time.RFC3339, tsUTC)
+	if err != nil {
+		return 0
+	}
+	return float64(t.Unix())
+}
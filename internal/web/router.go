@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"net/http"
 
+	"dockerhub-pull-watcher/internal/backup"
 	"dockerhub-pull-watcher/internal/watcher"
 )
 
@@ -11,19 +12,82 @@ type Router struct {
 	h *Handlers
 }
 
-func NewRouter(db *sql.DB, w *watcher.Service, tpl *Templates) http.Handler {
-	h := NewHandlers(db, w, tpl)
+func NewRouter(db *sql.DB, w *watcher.Service, tpl *Templates, backups *backup.Service) http.Handler {
+	h := NewHandlers(db, w, tpl, backups)
 	mux := http.NewServeMux()
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("web/static"))))
 
 	mux.HandleFunc("/", h.Home)
 
-	mux.HandleFunc("/targets", h.TargetsListOrCreate)     // GET list, POST create
-	mux.HandleFunc("/targets/new", h.TargetNew)           // GET
-	mux.HandleFunc("/targets/edit", h.TargetEditOrUpdate) // GET?id=, POST update
+	mux.HandleFunc("/targets", h.TargetsListOrCreate)      // GET list, POST create
+	mux.HandleFunc("/targets/new", h.TargetNew)            // GET
+	mux.HandleFunc("/targets/edit", h.TargetEditOrUpdate)  // GET?id=, POST update
+	mux.HandleFunc("POST /targets/delete", h.TargetDelete) // id, purge
+	mux.HandleFunc("POST /targets/bulk", h.TargetsBulk)    // ids, action=enable|disable
+	mux.HandleFunc("POST /targets/poll", h.TargetPoll)     // id
+	mux.HandleFunc("/targets/runs", h.TargetRuns)          // GET?id=
 
-	mux.HandleFunc("/repos", h.ReposList) // GET
-	mux.HandleFunc("/repo", h.RepoDetail) // GET?repo_id=
+	mux.HandleFunc("/webhooks", h.WebhooksList)                 // GET
+	mux.HandleFunc("/webhooks/new", h.WebhookNew)               // GET
+	mux.HandleFunc("/webhooks/edit", h.WebhookEditOrUpdate)     // GET?id=, POST create/update
+	mux.HandleFunc("POST /webhooks/delete", h.WebhookDelete)    // id
+	mux.HandleFunc("POST /webhooks/test", h.WebhookTest)        // id
+	mux.HandleFunc("/webhooks/deliveries", h.WebhookDeliveries) // GET?id=
+
+	mux.HandleFunc("/alerts", h.AlertsList)              // GET
+	mux.HandleFunc("/alerts/new", h.AlertNew)            // GET?namespace=&repo=
+	mux.HandleFunc("/alerts/edit", h.AlertEditOrUpdate)  // GET?id=, POST create/update
+	mux.HandleFunc("POST /alerts/delete", h.AlertDelete) // id
+	mux.HandleFunc("/alerts/firings", h.AlertFirings)    // GET?id=
+
+	mux.HandleFunc("/backups", h.BackupsList)             // GET
+	mux.HandleFunc("/backups/download", h.BackupDownload) // GET?name= (none = fresh backup)
+	mux.HandleFunc("POST /backups/run", h.BackupRun)      // into BACKUP_DIR
+
+	mux.HandleFunc("/repos", h.ReposList)         // GET
+	mux.HandleFunc("/repos/import", h.RepoImport) // GET, POST csv upload
+	mux.HandleFunc("/repo", h.RepoDetail)         // GET?repo_id=
+
+	// JSON API
+	mux.HandleFunc("GET /api/v1/targets", h.APITargetsList)
+	mux.HandleFunc("POST /api/v1/targets", h.APITargetCreate)
+	mux.HandleFunc("GET /api/v1/targets/{id}", h.APITargetGet)
+	mux.HandleFunc("PUT /api/v1/targets/{id}", h.APITargetUpdate)
+	mux.HandleFunc("DELETE /api/v1/targets/{id}", h.APITargetDelete) // ?purge=true
+	mux.HandleFunc("POST /api/v1/targets/bulk", h.APITargetsBulkEnable)
+	mux.HandleFunc("POST /api/v1/targets/{id}/poll", h.APITargetPoll)
+	mux.HandleFunc("GET /api/v1/targets/{id}/runs", h.APITargetRuns) // ?from=&to=&limit=&offset=
+
+	mux.HandleFunc("GET /api/v1/repos", h.APIReposList) // ?namespace=&limit=&offset=
+	mux.HandleFunc("GET /api/v1/repos/{id}", h.APIRepoGet)
+	mux.HandleFunc("GET /api/v1/repos/{id}/snapshots", h.APIRepoSnapshots) // ?from=&to=&limit=&offset=
+	mux.HandleFunc("GET /api/v1/repos/{id}/deltas", h.APIRepoDeltas)       // ?from=&to=&limit=&offset=
+	mux.HandleFunc("GET /api/v1/repos/{id}/rollups", h.APIRepoRollups)     // ?period=hour|day|week&from=&to=&limit=&offset=
+	mux.HandleFunc("GET /api/v1/repos/{id}/chart", h.APIRepoChart)         // ?range=24h|7d|30d|all
+
+	mux.HandleFunc("GET /api/v1/webhooks", h.APIWebhooksList)
+	mux.HandleFunc("POST /api/v1/webhooks", h.APIWebhookCreate)
+	mux.HandleFunc("GET /api/v1/webhooks/{id}", h.APIWebhookGet)
+	mux.HandleFunc("PUT /api/v1/webhooks/{id}", h.APIWebhookUpdate)
+	mux.HandleFunc("DELETE /api/v1/webhooks/{id}", h.APIWebhookDelete)
+	mux.HandleFunc("POST /api/v1/webhooks/{id}/test", h.APIWebhookTest)
+	mux.HandleFunc("GET /api/v1/webhooks/{id}/deliveries", h.APIWebhookDeliveries) // ?from=&to=&limit=&offset=
+
+	mux.HandleFunc("GET /api/v1/alerts", h.APIAlertsList)
+	mux.HandleFunc("POST /api/v1/alerts", h.APIAlertCreate)
+	mux.HandleFunc("GET /api/v1/alerts/firings", h.APIAlertFirings) // all rules; ?from=&to=&limit=&offset=
+	mux.HandleFunc("GET /api/v1/alerts/{id}", h.APIAlertGet)
+	mux.HandleFunc("PUT /api/v1/alerts/{id}", h.APIAlertUpdate)
+	mux.HandleFunc("DELETE /api/v1/alerts/{id}", h.APIAlertDelete)
+	mux.HandleFunc("GET /api/v1/alerts/{id}/firings", h.APIAlertFirings) // ?from=&to=&limit=&offset=
+
+	mux.HandleFunc("GET /api/v1/export/{dataset}", h.APIExport) // snapshots|deltas; ?format=&repo_id=&namespace=&from=&to=&resolution=
+
+	mux.HandleFunc("/api/", h.APINotFound)
+
+	mux.HandleFunc("GET /metrics", h.Metrics) // Prometheus
+	mux.HandleFunc("GET /healthz", h.Healthz) // liveness
+	mux.HandleFunc("GET /readyz", h.Readyz)   // readiness
 
 	return mux
 }
@@ -0,0 +1,358 @@
+package web
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/events"
+	"dockerhub-pull-watcher/internal/webhook"
+)
+
+func (h *Handlers) WebhooksList(w http.ResponseWriter, r *http.Request) {
+	hooks, err := db.ListWebhooks(h.db)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	tpl, err := h.tpl.Page("webhooks_list.html")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	_ = tpl.ExecuteTemplate(w, "webhooks_list_page", map[string]any{
+		"Title":    "Webhooks",
+		"Webhooks": hooks,
+	})
+}
+
+func (h *Handlers) WebhookNew(w http.ResponseWriter, r *http.Request) {
+	h.renderWebhookEdit(w, db.Webhook{Format: db.FormatJSON, Enabled: true}, true)
+}
+
+func (h *Handlers) WebhookEditOrUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		h.saveWebhook(w, r)
+		return
+	}
+
+	id, _ := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	hook, err := db.GetWebhook(h.db, id)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	h.renderWebhookEdit(w, hook, false)
+}
+
+func (h *Handlers) renderWebhookEdit(w http.ResponseWriter, hook db.Webhook, isNew bool) {
+	selected := map[string]bool{}
+	for _, t := range hook.EventList() {
+		selected[t] = true
+	}
+
+	tpl, err := h.tpl.Page("webhook_edit.html")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	_ = tpl.ExecuteTemplate(w, "webhook_edit_page", map[string]any{
+		"Title":      "Edit Webhook",
+		"IsNew":      isNew,
+		"Webhook":    hook,
+		"EventTypes": events.Types,
+		"Selected":   selected,
+		"Formats":    db.WebhookFormats,
+	})
+}
+
+func (h *Handlers) saveWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err := checkEventTypes(r.Form["events"]); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	hook := db.Webhook{
+		ID:        id,
+		Name:      strings.TrimSpace(r.FormValue("name")),
+		URL:       strings.TrimSpace(r.FormValue("url")),
+		Secret:    r.FormValue("secret"),
+		EventsCSV: strings.Join(r.Form["events"], ","),
+		Format:    r.FormValue("format"),
+		Enabled:   r.FormValue("enabled") == "on",
+	}
+	if hook.Format == "" {
+		hook.Format = db.FormatJSON
+	}
+	if id != 0 {
+		old, err := db.GetWebhook(h.db, id)
+		if err != nil {
+			http.Error(w, err.Error(), 404)
+			return
+		}
+		// An empty secret field keeps the stored secret.
+		if hook.Secret == "" && r.FormValue("clear_secret") != "on" {
+			hook.Secret = old.Secret
+		}
+	}
+	if err := hook.Validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if _, err := db.UpsertWebhook(h.db, hook); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	http.Redirect(w, r, "/webhooks", http.StatusFound)
+}
+
+func (h *Handlers) WebhookDelete(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err := db.DeleteWebhook(h.db, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, err.Error(), 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	http.Redirect(w, r, "/webhooks", http.StatusFound)
+}
+
+// WebhookTest queues a ping for one webhook and shows its delivery log.
+func (h *Handlers) WebhookTest(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if _, err := webhook.Enqueue(h.db, id, events.New(events.TypePing, "Test delivery from pullpulse")); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, err.Error(), 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	http.Redirect(w, r, "/webhooks/deliveries?id="+strconv.FormatInt(id, 10), http.StatusFound)
+}
+
+func (h *Handlers) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	hook, err := db.GetWebhook(h.db, id)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	deliveries, err := db.QueryWebhookDeliveries(h.db, id, db.ListOpts{Limit: 100})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	tpl, err := h.tpl.Page("webhook_deliveries.html")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	_ = tpl.ExecuteTemplate(w, "webhook_deliveries_page", map[string]any{
+		"Title":      "Deliveries " + hook.Name,
+		"Webhook":    hook,
+		"Deliveries": deliveries,
+	})
+}
+
+// apiWebhookInput is the request body for create/update. Secret and Enabled
+// are pointers: omitting them keeps the stored secret and enables new
+// webhooks; "" removes the secret. An empty format keeps the stored one
+// (json for new webhooks).
+type apiWebhookInput struct {
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Secret  *string  `json:"secret"`
+	Events  []string `json:"events"`
+	Format  string   `json:"format"`
+	Enabled *bool    `json:"enabled"`
+}
+
+func decodeWebhookInput(r *http.Request, old db.Webhook) (db.Webhook, error) {
+	var in apiWebhookInput
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		return db.Webhook{}, errors.New("invalid JSON body: " + err.Error())
+	}
+	if err := checkEventTypes(in.Events); err != nil {
+		return db.Webhook{}, err
+	}
+	hook := db.Webhook{
+		ID:        old.ID,
+		Name:      strings.TrimSpace(in.Name),
+		URL:       strings.TrimSpace(in.URL),
+		Secret:    old.Secret,
+		EventsCSV: strings.Join(in.Events, ","),
+		Format:    in.Format,
+		Enabled:   true,
+	}
+	if hook.Format == "" {
+		hook.Format = cmp.Or(old.Format, db.FormatJSON)
+	}
+	if in.Secret != nil {
+		hook.Secret = *in.Secret
+	}
+	if in.Enabled != nil {
+		hook.Enabled = *in.Enabled
+	}
+	if err := hook.Validate(); err != nil {
+		return db.Webhook{}, err
+	}
+	return hook, nil
+}
+
+func (h *Handlers) APIWebhooksList(w http.ResponseWriter, r *http.Request) {
+	hooks, err := db.ListWebhooks(h.db)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if hooks == nil {
+		hooks = []db.Webhook{}
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: hooks, Limit: len(hooks)})
+}
+
+func (h *Handlers) APIWebhookCreate(w http.ResponseWriter, r *http.Request) {
+	hook, err := decodeWebhookInput(r, db.Webhook{})
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := db.UpsertWebhook(h.db, hook)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	created, err := db.GetWebhook(h.db, id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handlers) APIWebhookGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hook, err := db.GetWebhook(h.db, id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, hook)
+}
+
+func (h *Handlers) APIWebhookUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	old, err := db.GetWebhook(h.db, id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	hook, err := decodeWebhookInput(r, old)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := db.UpsertWebhook(h.db, hook); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	updated, err := db.GetWebhook(h.db, id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (h *Handlers) APIWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := db.DeleteWebhook(h.db, id); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deleted": id})
+}
+
+func (h *Handlers) APIWebhookTest(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	e := events.New(events.TypePing, "Test delivery from pullpulse")
+	if _, err := webhook.Enqueue(h.db, id, e); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"queued": e.ID})
+}
+
+func (h *Handlers) APIWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts, err := parseListOpts(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := db.GetWebhook(h.db, id); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	deliveries, err := db.QueryWebhookDeliveries(h.db, id, opts)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: deliveries, Limit: opts.Limit, Offset: opts.Offset})
+}
+
+// checkEventTypes rejects event types a webhook can't subscribe to.
+func checkEventTypes(types []string) error {
+	for _, t := range types {
+		if !events.KnownType(t) {
+			return errors.New("unknown event type " + strconv.Quote(t))
+		}
+	}
+	return nil
+}
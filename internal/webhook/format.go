@@ -0,0 +1,338 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/events"
+)
+
+// Accent colors of chat messages.
+const (
+	colorInfo      = 0x0d6efd
+	colorOK        = 0x198754
+	colorWarn      = 0xfd7e14
+	colorError     = 0xdc3545
+	colorMuted     = 0x6c757d
+	colorMilestone = 0xffc107
+)
+
+// message is the chat-neutral rendering of an event that the Slack,
+// Discord, Teams and Matrix formats are built from.
+type message struct {
+	Title  string
+	Text   string // optional second paragraph
+	Link   string // pullpulse page of the repo or target, if PUBLIC_URL is set
+	Color  int
+	Facts  []fact
+	Footer string
+}
+
+type fact struct {
+	Name  string
+	Value string
+}
+
+// request is what a delivery sends for a webhook's format.
+type request struct {
+	Method string
+	URL    string
+	Body   []byte
+	Signed bool // add HeaderSignature
+	Token  string
+}
+
+// render builds the request for del. The queued payload is always the
+// generic event JSON; chat formats are rendered from it at send time.
+func render(w db.Webhook, del db.WebhookDelivery, publicURL string) (request, error) {
+	if w.Format == db.FormatJSON || w.Format == "" {
+		return request{Method: http.MethodPost, URL: w.URL, Body: []byte(del.Payload), Signed: true}, nil
+	}
+
+	var e events.Event
+	if err := json.Unmarshal([]byte(del.Payload), &e); err != nil {
+		return request{}, fmt.Errorf("decode event: %w", err)
+	}
+	m := newMessage(e, publicURL)
+
+	var body any
+	switch w.Format {
+	case db.FormatSlack:
+		body = slackBody(m)
+	case db.FormatDiscord:
+		body = discordBody(m, e.TSUTC)
+	case db.FormatTeams:
+		body = teamsBody(m)
+	case db.FormatMatrix:
+		b, err := json.Marshal(matrixBody(m))
+		if err != nil {
+			return request{}, err
+		}
+		// The delivery ID as transaction ID makes retries idempotent.
+		u := strings.TrimSuffix(w.URL, "/") + "/pullpulse-" + strconv.FormatInt(del.ID, 10)
+		return request{Method: http.MethodPut, URL: u, Body: b, Token: w.Secret}, nil
+	default:
+		return request{}, fmt.Errorf("unknown format %q", w.Format)
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return request{}, err
+	}
+	return request{Method: http.MethodPost, URL: w.URL, Body: b}, nil
+}
+
+func newMessage(e events.Event, publicURL string) message {
+	m := message{Title: e.Summary, Color: colorInfo, Footer: "pullpulse · " + e.Type}
+
+	if r := e.Repo; r != nil {
+		m.Link = pageURL(publicURL, "/repo?repo_id="+strconv.FormatInt(r.ID, 10))
+		m.Facts = append(m.Facts, fact{"Repo", r.Namespace + "/" + r.Name})
+		if r.PullCount != nil {
+			m.Facts = append(m.Facts, fact{"Pulls", grouped(*r.PullCount)})
+		}
+		if r.Delta != nil {
+			m.Facts = append(m.Facts, fact{"Since last poll", signed(*r.Delta)})
+		}
+	}
+	if t := e.Target; t != nil {
+		if m.Link == "" {
+			m.Link = pageURL(publicURL, "/targets/runs?id="+strconv.FormatInt(t.ID, 10))
+		}
+		m.Facts = append(m.Facts, fact{"Target", t.Name})
+	}
+
+	switch e.Type {
+	case events.TypeRunFailed:
+		m.Color = colorError
+		if s := dataString(e, "error"); s != "" {
+			m.Facts = append(m.Facts, fact{"Error", s})
+		}
+		if repos, ok := e.Data["failed_repos"].([]any); ok && len(repos) > 0 {
+			names := make([]string, 0, len(repos))
+			for i, r := range repos {
+				if i == 10 {
+					names = append(names, fmt.Sprintf("and %d more", len(repos)-10))
+					break
+				}
+				names = append(names, fmt.Sprint(r))
+			}
+			m.Text = "Failed repos: " + strings.Join(names, ", ")
+		}
+	case events.TypeRepoGone:
+		m.Color = colorMuted
+	case events.TypeImagePushed:
+		m.Color = colorOK
+		if s := dataString(e, "last_updated"); s != "" {
+			m.Facts = append(m.Facts, fact{"Last updated", s})
+		}
+	case events.TypeNegativeDelta:
+		m.Color = colorWarn
+		from, _ := dataNum(e, "from_pull_count")
+		to, _ := dataNum(e, "to_pull_count")
+		m.Text = fmt.Sprintf("Pull count went from %s to %s.", grouped(int64(from)), grouped(int64(to)))
+	case events.TypeAlertFired:
+		threshold, _ := dataNum(e, "threshold")
+		op := dataString(e, "op")
+		if dataString(e, "metric") == db.MetricPullCount && (op == ">" || op == ">=") && e.Repo != nil {
+			// A milestone rather than a problem.
+			m.Color = colorMilestone
+			m.Title = fmt.Sprintf("🎉 %s/%s passed %s pulls", e.Repo.Namespace, e.Repo.Name, grouped(int64(threshold)))
+			m.Text = "Milestone rule: " + dataString(e, "rule")
+		} else {
+			m.Color = colorError
+			m.Title = "🔔 " + e.Summary
+			m.Facts = append(m.Facts, fact{"Rule", dataString(e, "rule")}, fact{"Condition", dataString(e, "condition")})
+			if v, ok := dataNum(e, "value"); ok {
+				m.Facts = append(m.Facts, fact{"Value", db.FormatAlertValue(v)})
+			}
+		}
+	case events.TypePing:
+		m.Color = colorMuted
+	}
+	return m
+}
+
+func pageURL(publicURL, path string) string {
+	if publicURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(publicURL, "/") + path
+}
+
+func dataString(e events.Event, key string) string {
+	if v, ok := e.Data[key]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+func dataNum(e events.Event, key string) (float64, bool) {
+	v, ok := e.Data[key].(float64) // JSON numbers
+	return v, ok
+}
+
+// grouped formats n with thousands separators: 1,234,567.
+func grouped(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	if neg {
+		return "-" + b.String()
+	}
+	return b.String()
+}
+
+func signed(n int64) string {
+	if n > 0 {
+		return "+" + grouped(n)
+	}
+	return grouped(n)
+}
+
+// Slack incoming webhook: Block Kit with a plain-text fallback.
+func slackBody(m message) map[string]any {
+	esc := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+	title := "*" + esc(m.Title) + "*"
+	if m.Link != "" {
+		title = "*<" + m.Link + "|" + esc(m.Title) + ">*"
+	}
+	if m.Text != "" {
+		title += "\n" + esc(m.Text)
+	}
+	blocks := []any{
+		map[string]any{"type": "section", "text": map[string]any{"type": "mrkdwn", "text": title}},
+	}
+	if len(m.Facts) > 0 {
+		var fields []any
+		for _, f := range m.Facts[:min(len(m.Facts), 10)] {
+			fields = append(fields, map[string]any{"type": "mrkdwn", "text": "*" + esc(f.Name) + "*\n" + esc(f.Value)})
+		}
+		blocks = append(blocks, map[string]any{"type": "section", "fields": fields})
+	}
+	blocks = append(blocks, map[string]any{
+		"type":     "context",
+		"elements": []any{map[string]any{"type": "mrkdwn", "text": esc(m.Footer)}},
+	})
+	return map[string]any{"text": esc(m.Title), "blocks": blocks}
+}
+
+// Discord webhook: one embed.
+func discordBody(m message, ts string) map[string]any {
+	embed := map[string]any{
+		"title":  truncate(m.Title, 256),
+		"color":  m.Color,
+		"footer": map[string]any{"text": m.Footer},
+	}
+	if m.Link != "" {
+		embed["url"] = m.Link
+	}
+	if m.Text != "" {
+		embed["description"] = truncate(m.Text, 4096)
+	}
+	if ts != "" {
+		embed["timestamp"] = ts
+	}
+	var fields []any
+	for _, f := range m.Facts[:min(len(m.Facts), 25)] {
+		fields = append(fields, map[string]any{"name": f.Name, "value": truncate(f.Value, 1024), "inline": true})
+	}
+	if fields != nil {
+		embed["fields"] = fields
+	}
+	return map[string]any{"username": "pullpulse", "embeds": []any{embed}}
+}
+
+// Microsoft Teams Workflows webhook: an Adaptive Card attachment.
+func teamsBody(m message) map[string]any {
+	body := []any{
+		map[string]any{"type": "TextBlock", "text": m.Title, "weight": "Bolder", "size": "Medium", "wrap": true},
+	}
+	if m.Text != "" {
+		body = append(body, map[string]any{"type": "TextBlock", "text": m.Text, "wrap": true})
+	}
+	if len(m.Facts) > 0 {
+		var facts []any
+		for _, f := range m.Facts {
+			facts = append(facts, map[string]any{"title": f.Name, "value": f.Value})
+		}
+		body = append(body, map[string]any{"type": "FactSet", "facts": facts})
+	}
+	body = append(body, map[string]any{"type": "TextBlock", "text": m.Footer, "isSubtle": true, "size": "Small", "wrap": true})
+
+	card := map[string]any{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+		"msteams": map[string]any{"width": "Full"},
+	}
+	if m.Link != "" {
+		card["actions"] = []any{map[string]any{"type": "Action.OpenUrl", "title": "Open in pullpulse", "url": m.Link}}
+	}
+	return map[string]any{
+		"type": "message",
+		"attachments": []any{map[string]any{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	}
+}
+
+// Matrix m.room.message: a notice with an HTML body.
+func matrixBody(m message) map[string]any {
+	var plain, rich strings.Builder
+	plain.WriteString(m.Title)
+	title := html.EscapeString(m.Title)
+	if m.Link != "" {
+		title = `<a href="` + html.EscapeString(m.Link) + `">` + title + `</a>`
+	}
+	rich.WriteString("<b>" + title + "</b>")
+	if m.Text != "" {
+		plain.WriteString("\n" + m.Text)
+		rich.WriteString("<br>" + html.EscapeString(m.Text))
+	}
+	if len(m.Facts) > 0 {
+		rich.WriteString("<ul>")
+		for _, f := range m.Facts {
+			plain.WriteString("\n" + f.Name + ": " + f.Value)
+			rich.WriteString("<li><b>" + html.EscapeString(f.Name) + ":</b> " + html.EscapeString(f.Value) + "</li>")
+		}
+		rich.WriteString("</ul>")
+	}
+	if m.Link != "" {
+		plain.WriteString("\n" + m.Link)
+	}
+	return map[string]any{
+		"msgtype":        "m.notice",
+		"body":           plain.String(),
+		"format":         "org.matrix.custom.html",
+		"formatted_body": rich.String(),
+	}
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// validPublicURL reports whether u can prefix links in chat messages.
+func validPublicURL(u string) bool {
+	p, err := url.Parse(u)
+	return err == nil && (p.Scheme == "http" || p.Scheme == "https") && p.Host != ""
+}
@@ -0,0 +1,270 @@
+// Package webhook delivers watcher events to HTTP endpoints. Events are
+// queued in webhook_deliveries first, so deliveries survive restarts and
+// failed ones are retried with backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"dockerhub-pull-watcher/internal/db"
+	"dockerhub-pull-watcher/internal/events"
+)
+
+// Request headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Pullpulse-Event"
+	HeaderDelivery  = "X-Pullpulse-Delivery"
+	HeaderSignature = "X-Pullpulse-Signature" // "sha256=" + hex HMAC of the body
+)
+
+// Config tunes delivery. Zero values fall back to defaults.
+type Config struct {
+	Timeout     time.Duration // per attempt; default 10s
+	MaxAttempts int           // default 6
+	BaseDelay   time.Duration // first retry delay, doubled per attempt; default 30s
+	UserAgent   string
+	PublicURL   string // base URL for links in chat messages; empty = no links
+}
+
+func (c Config) withDefaults() Config {
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 6
+	}
+	if c.BaseDelay <= 0 {
+		c.BaseDelay = 30 * time.Second
+	}
+	if c.PublicURL != "" && !validPublicURL(c.PublicURL) {
+		log.Printf("webhook: ignoring PUBLIC_URL %q: not an absolute http(s) URL", c.PublicURL)
+		c.PublicURL = ""
+	}
+	return c
+}
+
+// pollInterval is how often the queue is checked for due retries; new
+// events wake the dispatcher immediately.
+const pollInterval = 5 * time.Second
+
+// Dispatcher is an events.Sink that queues events for all subscribed
+// webhooks and delivers them in the background.
+type Dispatcher struct {
+	db  *sql.DB
+	cfg Config
+	hc  *http.Client
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func NewDispatcher(dbx *sql.DB, cfg Config) *Dispatcher {
+	cfg = cfg.withDefaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		db:     dbx,
+		cfg:    cfg,
+		hc:     &http.Client{Timeout: cfg.Timeout},
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Emit queues e for every enabled webhook subscribed to its type.
+func (d *Dispatcher) Emit(e events.Event) {
+	if _, err := Enqueue(d.db, 0, e); err != nil {
+		log.Printf("webhook: queue %s: %v", e.Type, err)
+		return
+	}
+	d.signal()
+}
+
+// Enqueue queues e for delivery. With webhookID 0 it goes to all subscribed
+// webhooks, otherwise only to that one. The running dispatcher picks it up
+// within a few seconds.
+func Enqueue(dbx *sql.DB, webhookID int64, e events.Event) (int, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	return db.EnqueueWebhookDeliveries(dbx, webhookID, e.ID, e.Type, string(payload))
+}
+
+// Sign returns the X-Pullpulse-Signature value for body.
+func Sign(secret string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+func (d *Dispatcher) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) Start() {
+	go d.loop()
+}
+
+// Stop ends the delivery loop. An attempt in flight is cancelled if ctx
+// expires first; it stays pending and is retried after the next start.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	d.once.Do(func() { close(d.stop) })
+	select {
+	case <-d.done:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-d.done
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) loop() {
+	defer close(d.done)
+
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+
+	for {
+		d.deliverDue()
+		select {
+		case <-d.stop:
+			return
+		case <-t.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// Flush attempts every due delivery once, for one-shot runs without the
+// delivery loop. Failed deliveries stay queued for their next retry.
+func (d *Dispatcher) Flush() {
+	for d.deliverDue() == deliveryBatch {
+	}
+}
+
+// deliveryBatch is how many due deliveries are sent per loop iteration.
+const deliveryBatch = 50
+
+// deliverDue sends up to deliveryBatch due deliveries and returns how many
+// were due.
+func (d *Dispatcher) deliverDue() int {
+	due, err := db.DueWebhookDeliveries(d.db, time.Now(), deliveryBatch)
+	if err != nil {
+		log.Printf("webhook: load queue: %v", err)
+		return 0
+	}
+	hooks := map[int64]db.Webhook{}
+	for _, del := range due {
+		select {
+		case <-d.stop:
+			return 0
+		default:
+		}
+		w, ok := hooks[del.WebhookID]
+		if !ok {
+			if w, err = db.GetWebhook(d.db, del.WebhookID); err != nil {
+				log.Printf("webhook: load webhook %d: %v", del.WebhookID, err)
+				return 0
+			}
+			hooks[del.WebhookID] = w
+		}
+		d.attempt(w, del)
+	}
+	if len(due) == deliveryBatch {
+		d.signal()
+	}
+	return len(due)
+}
+
+// attempt sends one delivery and records the outcome.
+func (d *Dispatcher) attempt(w db.Webhook, del db.WebhookDelivery) {
+	code, err := d.post(w, del)
+	if d.ctx.Err() != nil {
+		// Shutting down: leave it pending for the next start.
+		return
+	}
+
+	now := time.Now().UTC()
+	del.Attempts++
+	del.ResponseCode = code
+	del.Error = ""
+	del.NextAttemptTS = ""
+	switch {
+	case err == nil:
+		del.Status = db.DeliveryOK
+		del.FinishedUTC = now.Format(time.RFC3339)
+	case del.Attempts >= d.cfg.MaxAttempts:
+		del.Status = db.DeliveryFailed
+		del.Error = err.Error()
+		del.FinishedUTC = now.Format(time.RFC3339)
+		log.Printf("webhook: %s delivery %d: giving up after %d attempts: %v", w.Name, del.ID, del.Attempts, err)
+	default:
+		delay := min(d.cfg.BaseDelay<<(del.Attempts-1), time.Hour)
+		del.Error = err.Error()
+		del.NextAttemptTS = now.Add(delay).Format(time.RFC3339)
+	}
+
+	if err := db.UpdateWebhookDelivery(d.db, del); err != nil {
+		log.Printf("webhook: record delivery %d: %v", del.ID, err)
+	}
+}
+
+func (d *Dispatcher) post(w db.Webhook, del db.WebhookDelivery) (int, error) {
+	r, err := render(w, del, d.cfg.PublicURL)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(d.ctx, r.Method, r.URL, bytes.NewReader(r.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", d.cfg.UserAgent)
+	}
+	req.Header.Set(HeaderEvent, del.EventType)
+	req.Header.Set(HeaderDelivery, fmt.Sprint(del.ID))
+	if r.Signed && w.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(w.Secret, r.Body))
+	}
+	if r.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+
+	resp, err := d.hc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}